@@ -1,12 +1,16 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 
 	"github.com/hrmsk66/terraformify/pkg/cli"
 	"github.com/hrmsk66/terraformify/pkg/file"
+	"github.com/hrmsk66/terraformify/pkg/naming"
 	"github.com/hrmsk66/terraformify/pkg/prop"
 	"github.com/hrmsk66/terraformify/pkg/terraform"
 	"github.com/hrmsk66/terraformify/pkg/tfconf"
@@ -47,7 +51,18 @@ var computeCmd = &cobra.Command{
 			return err
 		}
 
-		if err = file.CheckDir(workingDir, autoYes); err != nil {
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			return err
+		}
+
+		strict, err := cmd.Flags().GetBool("strict")
+		if err != nil {
+			return err
+		}
+
+		// Nothing is written to the working directory in a dry run, so there is no need to confirm
+		if err = file.CheckDir(cmd.Context(), workingDir, autoYes || dryRun); err != nil {
 			return err
 		}
 
@@ -56,7 +71,67 @@ var computeCmd = &cobra.Command{
 			return err
 		}
 
-		if err = file.CheckFile(workingDir, resourceName); err != nil {
+		modulePath, err := cmd.Flags().GetString("module-path")
+		if err != nil {
+			return err
+		}
+
+		asModule, err := cmd.Flags().GetBool("as-module")
+		if err != nil {
+			return err
+		}
+		if asModule && modulePath == "" {
+			modulePath = "module." + naming.Normalize(resourceName)
+		}
+
+		format, err := formatFlag(cmd)
+		if err != nil {
+			return err
+		}
+
+		dynamicBlocks, dynamicBlocksData, err := dynamicBlocksFlags(cmd)
+		if err != nil {
+			return err
+		}
+
+		// The TF file is written to the module directory with a module path
+		configDir := workingDir
+		if modulePath != "" {
+			moduleName, err := naming.ModuleName(modulePath)
+			if err != nil {
+				return err
+			}
+			configDir = filepath.Join(workingDir, file.ModuleDir(moduleName))
+		}
+
+		// The inputs of the module are named after the attributes of the service alone
+		if asModule {
+			if _, err := os.Stat(configDir); err == nil {
+				return fmt.Errorf("aborted writing the module to %s as it already exists. --as-module writes a module for the service alone", configDir)
+			}
+		}
+
+		if err = file.CheckFile(configDir, resourceName); err != nil {
+			return err
+		}
+
+		tfBinary, err := cmd.Flags().GetString("tf-binary")
+		if err != nil {
+			return err
+		}
+
+		workspace, err := cmd.Flags().GetString("workspace")
+		if err != nil {
+			return err
+		}
+
+		timeout, err := cmd.Flags().GetDuration("timeout")
+		if err != nil {
+			return err
+		}
+
+		keepSnapshots, err := cmd.Flags().GetInt("keep-snapshots")
+		if err != nil {
 			return err
 		}
 
@@ -85,6 +160,11 @@ var computeCmd = &cobra.Command{
 			return err
 		}
 
+		writeImports, err := cmd.Flags().GetBool("write-imports")
+		if err != nil {
+			return err
+		}
+
 		testMode, err := cmd.Flags().GetBool("test-mode")
 		if err != nil {
 			return err
@@ -101,14 +181,26 @@ var computeCmd = &cobra.Command{
 			ResourceName:      resourceName,
 			Version:           version,
 			Directory:         workingDir,
+			TFBinary:          tfBinary,
+			Workspace:         workspace,
+			ModulePath:        modulePath,
+			AsModule:          asModule,
+			Format:            format,
+			DynamicBlocks:     dynamicBlocks,
+			DynamicBlocksData: dynamicBlocksData,
+			Timeout:           timeout,
+			KeepSnapshots:     keepSnapshots,
 			ManageAll:         manageAll,
 			ForceDestroy:      forceDestroy,
 			SkipEditState:     skipEditState,
+			WriteImports:      writeImports,
+			DryRun:            dryRun,
+			Strict:            strict,
 			TestMode:          testMode,
 			ReplaceDictionary: replaceDictionary,
 		}
 
-		return ImportCompute(c)
+		return ImportCompute(cmd.Context(), c)
 	},
 }
 
@@ -121,51 +213,111 @@ func init() {
 	serviceCmd.PersistentFlags().Lookup("replace-edge-dictionary").Hidden = true
 }
 
-func ImportCompute(c cli.Config) error {
+func ImportCompute(ctx context.Context, c cli.Config) error {
+	if c.DryRun {
+		return dryRun(ctx, c, importCompute)
+	}
+
 	log.Printf("[INFO] Initializing Terraform")
-	// Find Terraform binary
-	tf, err := terraform.FindExec(c.Directory)
+	// Find Terraform or OpenTofu binary
+	tf, err := terraform.FindExec(c.Directory, c.TFBinary, c.Timeout)
 	if err != nil {
 		return err
 	}
 
+	rep := &report{}
+	if err := importCompute(ctx, tf, c, rep); err != nil {
+		return err
+	}
+	return checkStrict(c, rep)
+}
+
+func importCompute(ctx context.Context, tf terraform.Runner, c cli.Config, rep *report) (err error) {
 	// Run "terraform version"
-	if err = terraform.Version(tf); err != nil {
+	mode, err := terraform.Version(ctx, tf)
+	if err != nil {
 		return err
 	}
+	if c.WriteImports && mode != terraform.ConfigDrivenImport {
+		return errors.New("write-imports flag requires Terraform 1.5.0 or later")
+	}
+
+	var moduleName string
+	if c.ModulePath != "" {
+		if moduleName, err = naming.ModuleName(c.ModulePath); err != nil {
+			return err
+		}
+	}
 
 	// Create provider.tf
-	// Create temp*.tf with empty service resource blocks
+	// Create temp*.tf with empty service resource blocks or import blocks
 	log.Printf("[INFO] Creating provider.tf and temp*.tf")
-	tempf, err := file.CreateInitTerraformFiles(c.Directory)
+	tx, err := file.Begin(c.Directory)
+	if err != nil {
+		return err
+	}
+	if c.Format == cli.FormatJSON {
+		tx.UseJSONSyntax()
+	}
+	tempf, err := tx.CreateInitTerraformFiles()
 	if err != nil {
+		if err1 := tx.Rollback(); err1 != nil {
+			log.Printf("[ERROR] %s", err1)
+		}
 		return err
 	}
+	if moduleName != "" {
+		tx.SetModuleDir(file.ModuleDir(moduleName))
+	}
+
+	// Undo the changes if the import does not complete, including when it is interrupted by Ctrl-C
+	rb := &rollback{tf: tf, tx: tx, tempf: tempf}
+	defer func() {
+		if err != nil {
+			rb.run(err)
+			return
+		}
+		err = tx.Close()
+	}()
 
 	// Run "terraform init"
 	log.Printf(`[INFO] Running "terraform init"`)
-	if err = terraform.Init(tf); err != nil {
+	if err = terraform.Init(ctx, tf); err != nil {
 		return err
 	}
 
+	if c.Workspace != "" {
+		if rb.workspace, err = terraform.SelectWorkspace(ctx, tf, c.Workspace); err != nil {
+			return err
+		}
+	}
+
 	// Create ComputeServiceResourceProp struct
 	serviceProp := prop.NewComputeServiceResource(c.ID, c.ResourceName, c.Version)
 
-	if err = terraform.Import(tf, serviceProp, tempf); err != nil {
+	// Read the state before making any change so that it can be restored if the import does not complete
+	if rb.snapshot, err = terraform.SnapshotState(ctx, tf); err != nil {
+		return err
+	}
+	rb.snapshotted = true
+	if rb.snapshotID, err = saveSnapshot(ctx, tf, c, rb.snapshot); err != nil {
 		return err
 	}
 
-	// Get the config represented in HCL from the "terraform show" output
-	log.Print(`[INFO] Running "terraform show" to get the current Terraform state in HCL format`)
-	rawHCL, err := terraform.Show(tf)
-	if err != nil {
+	// Refuse to import over a resource already managed in the workspace
+	if err = checkNotManaged(rb.snapshot, c, serviceProp); err != nil {
 		return err
 	}
+	if err = importResource(ctx, tf, mode, serviceProp, tempf); err != nil {
+		return err
+	}
+	imported := []prop.TFBlock{serviceProp}
 
 	// Parse HCL and obtain Terraform block props as a list of struct
 	// to get the overall picture of the service configuration
 	log.Print("[INFO] Parsing the HCL")
-	hcl, err := tfconf.Load(rawHCL)
+	hcl, _, planFile, err := readConfig(ctx, tf, mode, &c)
+	defer removePlanFile(planFile)
 	if err != nil {
 		return err
 	}
@@ -175,37 +327,57 @@ func ImportCompute(c cli.Config) error {
 		return err
 	}
 
-	// Iterate over the list of props and run terraform import for Dictionary items
+	// Iterate over the list of props and collect Dictionary items and linked resources
+	var targets []prop.TFBlock
 	for _, p := range props {
 		switch p := p.(type) {
 		case *prop.DictionaryResource:
-			if err = terraform.Import(tf, p, tempf); err != nil {
-				return err
+			targets = append(targets, p)
+			// The dictionary is replaced with a new config store and has nothing to import
+			if !c.ReplaceDictionary {
+				imported = append(imported, p)
 			}
 		case *prop.LinkedResource:
 			if c.TestMode {
-				if err = terraform.RecursiveImport(tf, p, tempf); err != nil {
+				// The type is found by trying to import the resource, so it cannot wait for the single pass
+				if err = recursiveImport(ctx, tf, mode, p, tempf); err != nil {
 					return err
 				}
 			} else {
-				t := cli.AskDataStoreType(p.GetName())
-				p.SetDataStoreType(t)
-
-				if err = terraform.Import(tf, p, tempf); err != nil {
+				var t string
+				if t, err = cli.AskDataStoreType(ctx, p.GetName()); err != nil {
 					return err
 				}
+				p.SetDataStoreType(t)
+				targets = append(targets, p)
 			}
+			imported = append(imported, p)
 
 			var entries *prop.LinkedResource
 			entries, err = p.CloneForEntriesImport()
 			if err == nil {
-				if err = terraform.Import(tf, entries, tempf); err != nil {
-					return err
-				}
+				targets = append(targets, entries)
+				imported = append(imported, entries)
 			}
 		}
 	}
 
+	// Import the collected resources in a single pass
+	if err = checkNotManaged(rb.snapshot, c, targets...); err != nil {
+		return err
+	}
+	if err = importResources(ctx, tf, mode, targets, tempf); err != nil {
+		return err
+	}
+
+	// Make changes to the configuration
+	// log.Print("[INFO] Parsing the HCL and making corrections removing read-only attrs and replacing embedded VCL/logformat with the file function")
+	log.Print("[INFO] Parsing the HCL and making corrections")
+	hcl, state, planFile, err := readConfig(ctx, tf, mode, &c)
+	if err != nil {
+		return err
+	}
+
 	// temp*.tf no longer needed
 	if err = tempf.Close(); err != nil {
 		return err
@@ -214,51 +386,85 @@ func ImportCompute(c cli.Config) error {
 		return err
 	}
 
-	// Get the config represented in HCL from the "terraform show" output
-	log.Print(`[INFO] Running "terraform show" to get the current Terraform state in HCL format`)
-	rawHCL, err = terraform.Show(tf)
+	schema, err := providerSchema(ctx, tf)
 	if err != nil {
 		return err
 	}
 
-	// Make changes to the configuration
-	// log.Print("[INFO] Parsing the HCL and making corrections removing read-only attrs and replacing embedded VCL/logformat with the file function")
-	log.Print("[INFO] Parsing the HCL and making corrections")
-	hcl, err = tfconf.Load(rawHCL)
+	sensitiveAttrs, err := hcl.RewriteResources(serviceProp, props, state, schema, &c, tx)
 	if err != nil {
 		return err
 	}
+	hcl.RemoveComputedAttributes(schema.Computed)
+	warnSecretLookingValues(os.Stderr, tfconf.FindSecretLookingValues(state, serviceProp.GetType(), c.ID, schema))
 
-	sensitiveAttrs, err := hcl.RewriteResources(serviceProp, props, &c)
-	if err != nil {
-		return err
+	var inputs []tfconf.ModuleInput
+	if c.AsModule {
+		log.Print("[INFO] Lifting the values that differ between environments into the inputs of the module")
+		inputs = hcl.LiftModuleInputs(serviceProp.GetType(), sensitiveAttrs)
 	}
 
-	if err := file.WriteTF(c.Directory, c.ResourceName, hcl.Bytes()); err != nil {
-		return err
+	if c.DynamicBlocks {
+		log.Print("[INFO] Collapsing the repeated blocks into dynamic blocks")
+		if err = hcl.CollapseDynamicBlocks(&c, tx); err != nil {
+			return err
+		}
 	}
 
-	if err := file.WriteGitIgnore(c.Directory); err != nil {
+	if err = writeConfig(tx, c, hcl, serviceProp, sensitiveAttrs, inputs); err != nil {
 		return err
 	}
 
-	if len(sensitiveAttrs) > 0 {
-		variables := tfconf.BuildVariableDefinitions(sensitiveAttrs)
-		if err := file.WriteVariablesTF(c.Directory, variables); err != nil {
+	rep.imported = imported
+	rep.sensitiveAttrs = sensitiveAttrs
+
+	if c.WriteImports {
+		log.Print("[INFO] Writing import blocks to imports.tf")
+		imports, err := configContent(c, tfconf.BuildImportBlocks(moduleName, imported))
+		if err != nil {
+			return err
+		}
+		if err = tx.WriteImportsTF(imports); err != nil {
+			return err
+		}
+		if err = tx.Commit(); err != nil {
 			return err
 		}
 
-		tfvars := tfconf.BuildTFVars(sensitiveAttrs)
-		if err := file.WriteTFVars(c.Directory, tfvars); err != nil {
+		fmt.Fprintln(os.Stderr)
+		cli.BoldGreen(os.Stderr, `Completed! Run "terraform plan" to review the import and "terraform apply" to import the resources`)
+		return nil
+	}
+
+	// Move the generated files into the working directory. They are still removed if the state edits below fail.
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	if moduleName != "" {
+		log.Printf(`[INFO] Running "terraform init" to install %s`, c.ModulePath)
+		if err = terraform.Init(ctx, tf); err != nil {
 			return err
 		}
 	}
 
 	if c.SkipEditState {
 		cli.BoldYellow(os.Stderr, "skip-edit-state flag detected. Leaving terraform.tfstate untouched")
+		if mode == terraform.ConfigDrivenImport {
+			cli.BoldYellow(os.Stderr, "The resources have not been imported into the state")
+		} else if moduleName != "" {
+			cli.BoldYellow(os.Stderr, fmt.Sprintf(`The resources remain in the root module in the state. Move them into %s with "terraform state mv"`, c.ModulePath))
+		}
 	} else {
+		if mode == terraform.ConfigDrivenImport {
+			log.Print(`[INFO] Running "terraform apply" on the saved plan to import the resources into terraform.tfstate`)
+			if err := terraform.ApplyPlan(ctx, tf, planFile); err != nil {
+				return err
+			}
+		}
+
 		log.Print(`[INFO] Setting "activate" in terraform.tfstate`)
-		curState, err := tfstate.Load(c.Directory)
+		curState, err := terraform.PullState(ctx, tf)
 		if err != nil {
 			return err
 		}
@@ -300,41 +506,58 @@ func ImportCompute(c cli.Config) error {
 			}
 		}
 
+		var indexKeys []tfstate.SetIndexKeyParams
 		for _, p := range props {
 			switch p := p.(type) {
 			case *prop.DictionaryResource:
 				log.Printf(`[INFO] Inserting "index_key" in terraform.tfstate for %s`, p.GetRef())
-				newState, err = newState.SetIndexKey(tfstate.SetIndexKeyParams{
+				indexKeys = append(indexKeys, tfstate.SetIndexKeyParams{
 					ServiceId:    c.ID,
 					ResourceType: p.GetType(),
 					ResourceName: p.GetNormalizedName(),
 					Name:         p.GetName(),
 				})
-				if err != nil {
-					return err
-				}
+			}
+		}
+		if len(indexKeys) > 0 {
+			newState, err = newState.SetIndexKey(indexKeys...)
+			if err != nil {
+				return err
 			}
 		}
 
 		if len(sensitiveAttrs) > 0 {
 			log.Print(`[INFO] Inserting items in "sensitive_attributes" in terraform.tfstate`)
-			// Need to set once for each sensitive attribute
-			blockTypes := map[string]struct{}{}
+			paths := make([]tfstate.Path, 0, len(sensitiveAttrs))
 			for _, attr := range sensitiveAttrs {
-				blockTypes[attr.BlockType] = struct{}{}
+				paths = append(paths, attr.Path())
 			}
-			newState, err = newState.SetSensitiveAttributes(c.ID, blockTypes)
+			newState, err = newState.SetSensitiveAttributes(c.ID, paths)
 			if err != nil {
 				return err
 			}
 		}
 
-		if err = file.WriteTFState(c.Directory, newState.Bytes()); err != nil {
+		if c.ModulePath != "" {
+			newState, err = moveIntoModule(newState, c, imported)
+			if err != nil {
+				return err
+			}
+		}
+
+		rep.stateEdits = tfstate.Diff(curState, newState)
+
+		log.Printf("[INFO] Pushing the state edited by terraformify %s", getVersion())
+		if err = terraform.PushState(ctx, tf, newState); err != nil {
 			return err
 		}
 
 		log.Print(`[INFO] Running "terraform refresh" to format the state file and check errors`)
-		if err = terraform.Refresh(tf); err != nil {
+		if err = terraform.Refresh(ctx, tf); err != nil {
+			return err
+		}
+
+		if rep.drift, err = verifyImport(ctx, tf); err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hrmsk66/terraformify/pkg/cli"
+	"github.com/hrmsk66/terraformify/pkg/terraform/terraformtest"
+)
+
+func TestImportComputeWriteImports(t *testing.T) {
+	dir := t.TempDir()
+	tf := &terraformtest.Runner{
+		Dir:       dir,
+		TFVersion: "1.6.0",
+		Plans: []terraformtest.Plan{
+			{
+				GeneratedConfig: readRecording(t, "compute_config_driven", "generated_1.tf"),
+				JSON:            readRecording(t, "compute_config_driven", "plan_1.json"),
+			},
+			// The linked resource is not a config store
+			{Err: errors.New("Error: Cannot import non-existent remote object")},
+			{
+				GeneratedConfig: readRecording(t, "compute_config_driven", "generated_probe.tf"),
+				JSON:            readRecording(t, "compute_config_driven", "plan_probe.json"),
+			},
+			{
+				GeneratedConfig: readRecording(t, "compute_config_driven", "generated_2.tf"),
+				JSON:            readRecording(t, "compute_config_driven", "plan_2.json"),
+			},
+		},
+	}
+	c := cli.Config{
+		ID:           "7ManTUgtlSytxeXRMPYY33",
+		ResourceName: "service",
+		Directory:    dir,
+		WriteImports: true,
+		TestMode:     true,
+	}
+
+	if err := importCompute(context.Background(), tf, c, &report{}); err != nil {
+		t.Fatalf("importCompute failed: %v", err)
+	}
+
+	expectedCalls := []string{
+		"version",
+		"init",
+		"state pull",
+		"plan", "show -json",
+		"plan",
+		"plan", "show -json",
+		"plan", "show -json",
+		"providers schema",
+	}
+	if !reflect.DeepEqual(tf.Calls, expectedCalls) {
+		t.Errorf("Calls = %q, want %q", tf.Calls, expectedCalls)
+	}
+
+	conf := readOutput(t, dir, "service.tf")
+	for _, expected := range []string{
+		`resource "fastly_service_compute" "service"`,
+		`resource "fastly_secretstore" "my_store"`,
+		`fastly_secretstore.my_store.id`,
+	} {
+		if !strings.Contains(conf, expected) {
+			t.Errorf("service.tf does not contain %q:\n%s", expected, conf)
+		}
+	}
+
+	imports := readOutput(t, dir, "imports.tf")
+	for _, expected := range []string{
+		`to = fastly_service_compute.service`,
+		`id = "7ManTUgtlSytxeXRMPYY33"`,
+		`to = fastly_secretstore.my_store`,
+		`id = "4t1ATv0LyQ6GPNXxZHpcAz"`,
+	} {
+		if !strings.Contains(imports, expected) {
+			t.Errorf("imports.tf does not contain %q:\n%s", expected, imports)
+		}
+	}
+
+	// The state and the saved plan are left untouched
+	for _, name := range []string{"terraform.tfstate", "terraformify.tfplan"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s exists after importing with write-imports", name)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hrmsk66/terraformify/pkg/cli"
+	"github.com/hrmsk66/terraformify/pkg/file"
+	"github.com/hrmsk66/terraformify/pkg/prop"
+	"github.com/hrmsk66/terraformify/pkg/terraform"
+	"github.com/hrmsk66/terraformify/pkg/tfconf"
+	"github.com/hrmsk66/terraformify/pkg/tfstate"
+)
+
+// report collects what an import did, so that a dry run can show it
+type report struct {
+	// imported lists the resources imported into the state or written to imports.tf
+	imported []prop.TFBlock
+	// stateEdits are the changes made to the state after the resources were imported
+	stateEdits []tfstate.Patch
+	// drift lists the changes "terraform plan" shows right after the import
+	drift []terraform.ResourceDrift
+	// sensitiveAttrs are the sensitive attributes moved to variables, with their values
+	sensitiveAttrs []tfconf.SensitiveAttr
+}
+
+type importFunc func(ctx context.Context, tf terraform.Runner, c cli.Config, rep *report) error
+
+// dryRun runs the import in a copy of the working directory and prints the changes it made to the copy
+func dryRun(ctx context.Context, c cli.Config, run importFunc) error {
+	sandbox, err := os.MkdirTemp("", "terraformify-dry-run-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := os.RemoveAll(sandbox); err != nil {
+			log.Printf("[WARN] failed to remove %s: %s", sandbox, err)
+		}
+	}()
+
+	log.Printf("[INFO] Copying %s to %s for the dry run", c.Directory, sandbox)
+	if err := file.CopyDir(c.Directory, sandbox); err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] Initializing Terraform")
+	tf, err := terraform.FindExec(sandbox, c.TFBinary, c.Timeout)
+	if err != nil {
+		return err
+	}
+
+	return runDryRun(ctx, tf, c, run, os.Stdout)
+}
+
+// runDryRun runs the import with tf, which works in a copy of the working directory, and prints the changes to w
+func runDryRun(ctx context.Context, tf terraform.Runner, c cli.Config, run importFunc, w io.Writer) error {
+	sandbox := tf.WorkingDir()
+	before, err := file.Snapshot(sandbox)
+	if err != nil {
+		return err
+	}
+
+	// Keep the imports and the state edits away from the configured backend
+	workspace, err := terraform.Isolate(ctx, tf, c.Workspace)
+	if err != nil {
+		return err
+	}
+
+	sc := c
+	sc.Directory = sandbox
+	sc.Workspace = workspace
+	rep := &report{}
+	if err := run(ctx, tf, sc, rep); err != nil {
+		return err
+	}
+
+	after, err := file.Snapshot(sandbox)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(os.Stderr)
+	cli.BoldYellow(os.Stderr, fmt.Sprintf("Dry run: nothing has been written to %s or the backend", c.Directory))
+	if err := printDryRun(w, before, after, rep); err != nil {
+		return err
+	}
+	return checkStrict(c, rep)
+}
+
+// isTerraformFile tells whether the file is written by Terraform itself. The changes to the state are
+// shown as state edits instead, and the rest are not part of the configuration.
+func isTerraformFile(name string) bool {
+	name = filepath.ToSlash(name)
+	for _, prefix := range []string{".terraform/", "terraform.tfstate.d/"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	switch name {
+	case ".terraform.lock.hcl", "terraform.tfstate", "terraform.tfstate.backup", terraform.LocalBackendOverrideFile:
+		return true
+	}
+	return false
+}
+
+// sensitiveVariables returns the names of the sensitive variables, which are the variables the import moved
+// the sensitive attributes to and the others declared sensitive in the configuration
+func sensitiveVariables(files map[string]string, attrs []tfconf.SensitiveAttr) map[string]bool {
+	names := map[string]bool{}
+	for _, attr := range attrs {
+		names[attr.Key] = true
+	}
+	for name, content := range files {
+		if isTerraformFile(name) || !(strings.HasSuffix(name, ".tf") || strings.HasSuffix(name, ".tf.json")) {
+			continue
+		}
+		declared, err := tfconf.SensitiveVariables([]byte(content), name)
+		if err != nil {
+			log.Printf("[WARN] %s", err)
+			continue
+		}
+		for v := range declared {
+			names[v] = true
+		}
+	}
+	return names
+}
+
+// redactTFVars hides the values of the sensitive variables in a variable definitions file, so that the secrets
+// are not printed. The whole content is hidden if the file cannot be parsed.
+func redactTFVars(name, content string, sensitive map[string]bool) string {
+	if content == "" || !(strings.HasSuffix(name, ".tfvars") || strings.HasSuffix(name, ".tfvars.json")) {
+		return content
+	}
+	redacted, err := tfconf.RedactTFVars([]byte(content), name, sensitive)
+	if err != nil {
+		log.Printf("[WARN] %s", err)
+		return tfconf.RedactedValue + "\n"
+	}
+	return string(redacted)
+}
+
+func printDryRun(w io.Writer, before, after map[string]string, rep *report) error {
+	var names []string
+	for name := range after {
+		if strings.HasSuffix(name, string(filepath.Separator)) || isTerraformFile(name) {
+			continue
+		}
+		if content, ok := before[name]; ok && content == after[name] {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w, cli.Bold("Resources to import:"))
+	if len(rep.imported) == 0 {
+		fmt.Fprintln(w, "  (none)")
+	}
+	for _, p := range rep.imported {
+		fmt.Fprintf(w, "  %s (%s)\n", p.GetRef(), p.GetIDforTFImport())
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, cli.Bold("Files:"))
+	if len(names) == 0 {
+		fmt.Fprintln(w, "  (none)")
+	}
+	for _, name := range names {
+		content, existed := before[name]
+		action := "create"
+		switch {
+		case existed && strings.HasPrefix(after[name], content):
+			action = "append"
+		case existed:
+			action = "overwrite"
+		}
+		fmt.Fprintf(w, "  %-9s %s\n", action, filepath.ToSlash(name))
+	}
+	sensitive := sensitiveVariables(after, rep.sensitiveAttrs)
+	for _, name := range names {
+		fmt.Fprintln(w)
+		fmt.Fprint(w, file.UnifiedDiff(filepath.ToSlash(name), redactTFVars(name, before[name], sensitive), redactTFVars(name, after[name], sensitive)))
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, cli.Bold("State edits:"))
+	if len(rep.stateEdits) == 0 {
+		fmt.Fprintln(w, "  (none)")
+		return nil
+	}
+	b, err := json.MarshalIndent(rep.stateEdits, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(w, string(b))
+	return nil
+}
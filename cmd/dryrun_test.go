@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hrmsk66/terraformify/pkg/cli"
+	"github.com/hrmsk66/terraformify/pkg/file"
+	"github.com/hrmsk66/terraformify/pkg/terraform/terraformtest"
+)
+
+func TestImportVCLDryRun(t *testing.T) {
+	backend := terraformtest.NewHTTPBackend("")
+	defer backend.Close()
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"backend.tf":       "terraform {\n  backend \"http\" {}\n}\n",
+		"variables.tf":     "variable \"region\" {}\n",
+		"terraform.tfvars": "region = \"us\"\n",
+	})
+	before, err := file.Snapshot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sandbox := t.TempDir()
+	if err := file.CopyDir(dir, sandbox); err != nil {
+		t.Fatal(err)
+	}
+	tf := &terraformtest.Runner{
+		Dir:       sandbox,
+		Backend:   backend.URL,
+		TFVersion: "1.4.5",
+		States: []string{
+			readRecording(t, "vcl_legacy", "state_1.json"),
+			readRecording(t, "vcl_legacy", "state_2.json"),
+		},
+		ShowOutputs: []string{
+			readRecording(t, "vcl_legacy", "show_1.txt"),
+			readRecording(t, "vcl_legacy", "show_2.txt"),
+		},
+	}
+	c := cli.Config{
+		ID:           "7ManTUgtlSytxeXRMPYY33",
+		ResourceName: "service",
+		Directory:    dir,
+		DryRun:       true,
+	}
+
+	var out bytes.Buffer
+	if err := runDryRun(context.Background(), tf, c, importVCL, &out); err != nil {
+		t.Fatalf("runDryRun failed: %v", err)
+	}
+
+	for _, expected := range []string{
+		"fastly_service_vcl.service (7ManTUgtlSytxeXRMPYY33)",
+		"create    service.tf",
+		"append    variables.tf",
+		"append    terraform.tfvars",
+		"--- /dev/null\n+++ b/service.tf\n",
+		"--- a/variables.tf\n+++ b/variables.tf\n@@ -1 +1,",
+		`"op": "replace"`,
+		`/attributes/activate"`,
+		`"value": true`,
+		`"path": "/resources/0/instances/0/index_key"`,
+		`+httpbin_ssl_client_key = (sensitive value)`,
+		` region = "us"`,
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("output does not contain %q", expected)
+		}
+	}
+	// The secrets moved to terraform.tfvars are not printed
+	if strings.Contains(out.String(), "BEGIN PRIVATE KEY") {
+		t.Errorf("output contains the value of a sensitive attribute:\n%s", out.String())
+	}
+
+	// Neither the working directory nor the backend is written to
+	after, err := file.Snapshot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(after, before) {
+		t.Errorf("working directory is changed:\ngot  %q\nwant %q", after, before)
+	}
+	if s := backend.State(); s != "" {
+		t.Errorf("state is written to the backend:\n%s", s)
+	}
+}
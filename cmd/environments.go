@@ -0,0 +1,561 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hrmsk66/terraformify/pkg/cli"
+	"github.com/hrmsk66/terraformify/pkg/file"
+	"github.com/hrmsk66/terraformify/pkg/naming"
+	"github.com/hrmsk66/terraformify/pkg/terraform"
+	"github.com/hrmsk66/terraformify/pkg/tfconf"
+	"github.com/hrmsk66/terraformify/pkg/tfstate"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// environmentsCmd represents the service environments command
+var environmentsCmd = &cobra.Command{
+	Use:          "environments <environment>=<service-id> <environment>=<service-id>...",
+	Short:        "Import the VCL services of several environments, such as staging and production, into a module shared by them",
+	Args:         cobra.MinimumNArgs(2),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		filter := cli.CreateLogFilter()
+		log.Printf("[INFO] CLI version: %s", getVersion())
+		log.SetOutput(filter)
+
+		// The flags that change where or how a single service is written do not apply to the shared module
+		for _, name := range []string{"version", "as-module", "format", "dynamic-blocks", "dynamic-blocks-data", "write-imports", "skip-edit-state", "dry-run", "workspace"} {
+			if cmd.Flags().Changed(name) {
+				return fmt.Errorf("--%s is not supported with service environments", name)
+			}
+		}
+
+		envs, err := parseEnvironments(args)
+		if err != nil {
+			return err
+		}
+
+		workingDir, err := cmd.Flags().GetString("working-dir")
+		if err != nil {
+			return err
+		}
+
+		autoYes, err := cmd.Flags().GetBool("yes")
+		if err != nil {
+			return err
+		}
+
+		if err = file.CheckDir(cmd.Context(), workingDir, autoYes); err != nil {
+			return err
+		}
+
+		resourceName, err := cmd.Flags().GetString("resource-name")
+		if err != nil {
+			return err
+		}
+
+		modulePath, err := cmd.Flags().GetString("module-path")
+		if err != nil {
+			return err
+		}
+		if modulePath == "" {
+			modulePath = "module." + naming.Normalize(resourceName)
+		}
+		moduleName, err := naming.ModuleName(modulePath)
+		if err != nil {
+			return err
+		}
+
+		// The module is written for the environments alone
+		configDir := filepath.Join(workingDir, file.ModuleDir(moduleName))
+		if _, err := os.Stat(configDir); err == nil {
+			return fmt.Errorf("aborted writing the module to %s as it already exists", configDir)
+		}
+		for _, env := range envs {
+			tfvars := filepath.Join(workingDir, env.name+".tfvars")
+			if _, err := os.Stat(tfvars); err == nil {
+				return fmt.Errorf("aborted creating %s as it already exists", tfvars)
+			}
+		}
+
+		strict, err := cmd.Flags().GetBool("strict")
+		if err != nil {
+			return err
+		}
+
+		tfBinary, err := cmd.Flags().GetString("tf-binary")
+		if err != nil {
+			return err
+		}
+
+		timeout, err := cmd.Flags().GetDuration("timeout")
+		if err != nil {
+			return err
+		}
+
+		keepSnapshots, err := cmd.Flags().GetInt("keep-snapshots")
+		if err != nil {
+			return err
+		}
+
+		apiKey := viper.GetString("api-key")
+		if err = os.Setenv("FASTLY_API_KEY", apiKey); err != nil {
+			return err
+		}
+
+		manageAll, err := cmd.Flags().GetBool("manage-all")
+		if err != nil {
+			return err
+		}
+
+		forceDestroy, err := cmd.Flags().GetBool("force-destroy")
+		if err != nil {
+			return err
+		}
+
+		testMode, err := cmd.Flags().GetBool("test-mode")
+		if err != nil {
+			return err
+		}
+
+		c := cli.Config{
+			ResourceName:  resourceName,
+			Directory:     workingDir,
+			TFBinary:      tfBinary,
+			ModulePath:    modulePath,
+			Timeout:       timeout,
+			KeepSnapshots: keepSnapshots,
+			ManageAll:     manageAll,
+			ForceDestroy:  forceDestroy,
+			Strict:        strict,
+			TestMode:      testMode,
+		}
+
+		log.Printf("[INFO] Initializing Terraform")
+		tf, err := terraform.FindExec(c.Directory, c.TFBinary, c.Timeout)
+		if err != nil {
+			return err
+		}
+		sandboxRunner := func(dir string) (terraform.Runner, error) {
+			return terraform.FindExec(dir, c.TFBinary, c.Timeout)
+		}
+
+		return importEnvironments(cmd.Context(), tf, sandboxRunner, c, envs, os.Stderr)
+	},
+}
+
+func init() {
+	serviceCmd.AddCommand(environmentsCmd)
+}
+
+// environmentName is what an environment can be named, as it names the workspace and the .tfvars file
+var environmentName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// environment is a service imported as one of the environments sharing the module
+type environment struct {
+	name string
+	id   string
+
+	// base is the state of the workspace before the import, which is nil if it has none
+	base *tfstate.TFState
+	// state is the state with the service imported, which replaces base
+	state *tfstate.TFState
+	// conf is the TF file of the service written by the import
+	conf *tfconf.TFConf
+	// files are the files the TF file reads, such as the VCL files, relative to the module directory
+	files map[string][]byte
+	rep   *report
+}
+
+// parseEnvironments parses the arguments in the form of <environment>=<service-id>
+func parseEnvironments(args []string) ([]*environment, error) {
+	var envs []*environment
+	seen := map[string]bool{}
+	for _, arg := range args {
+		name, id, ok := strings.Cut(arg, "=")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("invalid argument %q. specify each environment as <environment>=<service-id>", arg)
+		}
+		if !environmentName.MatchString(name) || name == "default" {
+			return nil, fmt.Errorf("invalid environment name %q. use letters, digits, - and _ other than default, as it names the workspace", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("environment %q is given more than once", name)
+		}
+		seen[name] = true
+		envs = append(envs, &environment{name: name, id: id})
+	}
+	return envs, nil
+}
+
+// importEnvironments imports the service of each environment into a copy of the working directory, one by one,
+// and writes the module shared by them to the working directory. The values that differ between the environments
+// become variables of the module, which are set in <environment>.tfvars. The resources of each environment are
+// written to the workspace of the same name. The differences that cannot be turned into variables are reported to w.
+func importEnvironments(ctx context.Context, tf terraform.Runner, sandboxRunner func(dir string) (terraform.Runner, error), c cli.Config, envs []*environment, w io.Writer) (err error) {
+	moduleName, err := naming.ModuleName(c.ModulePath)
+	if err != nil {
+		return err
+	}
+
+	log.Printf(`[INFO] Running "terraform init"`)
+	if err = terraform.Init(ctx, tf); err != nil {
+		return err
+	}
+	workspaces, current, err := tf.WorkspaceList(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Reading the state of each environment selects its workspace, so the cleanup is set up before it.
+	// If the run fails, the states pushed are put back, the workspace selected before is selected again,
+	// the workspaces created are deleted and the working directory is restored.
+	var tx *file.Transaction
+	var pushed []*environment
+	var created []string
+	defer func() {
+		if err == nil {
+			err = tx.Close()
+			return
+		}
+		// The context of the command may have been canceled, so the cleanup is done with a new one
+		ctx := context.Background()
+		for _, env := range pushed {
+			if _, err1 := terraform.SelectWorkspace(ctx, tf, env.name); err1 != nil {
+				log.Printf("[ERROR] %s", err1)
+				continue
+			}
+			if err1 := terraform.RestoreState(ctx, tf, env.base); err1 != nil {
+				log.Printf("[ERROR] %s", err1)
+			}
+		}
+		if _, err1 := terraform.SelectWorkspace(ctx, tf, current); err1 != nil {
+			log.Printf("[ERROR] %s", err1)
+			cli.BoldYellow(os.Stderr, fmt.Sprintf(`The workspace could not be restored. Check it with "terraform workspace list" and select %q again`, current))
+		} else {
+			for _, name := range created {
+				log.Printf(`[INFO] Running "terraform workspace delete %s"`, name)
+				if err1 := tf.WorkspaceDelete(ctx, name); err1 != nil {
+					log.Printf("[ERROR] %s", err1)
+				}
+			}
+		}
+		if tx != nil {
+			if err1 := tx.Rollback(); err1 != nil {
+				log.Printf("[ERROR] %s", err1)
+			}
+		}
+	}()
+
+	for _, env := range envs {
+		if env.base, err = workspaceState(ctx, tf, workspaces, env.name); err != nil {
+			return err
+		}
+		log.Printf("[INFO] Importing %s for %s", env.id, env.name)
+		if err = importEnvironment(ctx, sandboxRunner, c, moduleName, env); err != nil {
+			return fmt.Errorf("%s: %w", env.name, err)
+		}
+	}
+
+	names := make([]string, len(envs))
+	confs := make([]*tfconf.TFConf, len(envs))
+	for i, env := range envs {
+		names[i], confs[i] = env.name, env.conf
+	}
+	log.Print("[INFO] Factoring the values that differ between the environments into the variables of the module")
+	inputs, diffs := tfconf.FactorEnvironments(names, confs)
+	diffs = append(diffs, diffFiles(file.ModuleDir(moduleName), envs)...)
+
+	if tx, err = file.Begin(c.Directory); err != nil {
+		return err
+	}
+	tx.SetModuleDir(file.ModuleDir(moduleName))
+
+	if err = writeEnvironments(tx, c, moduleName, envs, inputs); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	log.Printf(`[INFO] Running "terraform init" to install %s`, c.ModulePath)
+	if err = terraform.Init(ctx, tf); err != nil {
+		return err
+	}
+
+	for _, env := range envs {
+		var sw *terraform.WorkspaceSwitch
+		if sw, err = terraform.SelectWorkspace(ctx, tf, env.name); err != nil {
+			return err
+		}
+		if sw != nil && sw.Created {
+			created = append(created, env.name)
+		}
+		ec := c
+		ec.Workspace = env.name
+		if _, err = saveSnapshot(ctx, tf, ec, env.base); err != nil {
+			return err
+		}
+		log.Printf("[INFO] Writing the resources of %s to the workspace", env.name)
+		if err = terraform.ReplaceState(ctx, tf, env.base, env.state); err != nil {
+			return err
+		}
+		pushed = append(pushed, env)
+	}
+	if _, err = terraform.SelectWorkspace(ctx, tf, current); err != nil {
+		return err
+	}
+
+	printEnvironmentDiffs(w, envs[0].name, diffs)
+
+	fmt.Fprintln(w)
+	cli.BoldGreen(w, "Completed!")
+	fmt.Fprintf(w, "Select the workspace of an environment and plan with its variables, such as:\n  terraform workspace select %s\n  terraform plan -var-file=%s.tfvars\n", envs[0].name, envs[0].name)
+
+	if c.Strict {
+		for _, env := range envs {
+			if err := checkStrict(c, env.rep); err != nil {
+				return fmt.Errorf("%s: %w", env.name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// workspaceState reads the state of the workspace, which is nil if the workspace or its state does not exist
+func workspaceState(ctx context.Context, tf terraform.Runner, workspaces []string, workspace string) (*tfstate.TFState, error) {
+	for _, w := range workspaces {
+		if w == workspace {
+			if _, err := terraform.SelectWorkspace(ctx, tf, workspace); err != nil {
+				return nil, err
+			}
+			return terraform.SnapshotState(ctx, tf)
+		}
+	}
+	return nil, nil
+}
+
+// importEnvironment imports the service of the environment into the module in a copy of the working directory,
+// which is switched to the local backend seeded with the state of the workspace, and reads what the import wrote
+func importEnvironment(ctx context.Context, sandboxRunner func(dir string) (terraform.Runner, error), c cli.Config, moduleName string, env *environment) error {
+	sandbox, err := os.MkdirTemp("", "terraformify-environment-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := os.RemoveAll(sandbox); err != nil {
+			log.Printf("[WARN] failed to remove %s: %s", sandbox, err)
+		}
+	}()
+
+	log.Printf("[INFO] Copying %s to %s for the import", c.Directory, sandbox)
+	if err := file.CopyDir(c.Directory, sandbox); err != nil {
+		return err
+	}
+	tf, err := sandboxRunner(sandbox)
+	if err != nil {
+		return err
+	}
+	workspace, err := terraform.Isolate(ctx, tf, env.name)
+	if err != nil {
+		return err
+	}
+
+	sc := c
+	sc.ID = env.id
+	sc.Directory = sandbox
+	sc.Workspace = workspace
+	// The snapshots are saved when the state is written to the workspace
+	sc.KeepSnapshots = 0
+	env.rep = &report{}
+	if err := importVCL(ctx, tf, sc, env.rep); err != nil {
+		return err
+	}
+
+	if env.state, err = terraform.PullState(ctx, tf); err != nil {
+		return err
+	}
+
+	moduleDir := filepath.Join(sandbox, file.ModuleDir(moduleName))
+	if env.conf, err = loadConfigFile(moduleDir, c.ResourceName+".tf"); err != nil {
+		return err
+	}
+	env.files, err = readModuleFiles(moduleDir)
+	return err
+}
+
+// readModuleFiles reads the files in the module directory that the TF files read, such as the VCL files
+func readModuleFiles(moduleDir string) (map[string][]byte, error) {
+	files := map[string][]byte{}
+	for _, dir := range []string{"vcl", "content", "logformat"} {
+		err := filepath.WalkDir(filepath.Join(moduleDir, dir), func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(moduleDir, path)
+			if err != nil {
+				return err
+			}
+			files[rel], err = os.ReadFile(path)
+			return err
+		})
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// diffFiles reports the files the TF file reads, such as the VCL files, that differ between the environments.
+// They cannot be turned into variables, and the module has the ones of the first environment.
+func diffFiles(moduleDir string, envs []*environment) []string {
+	var rels []string
+	for _, env := range envs {
+		for rel := range env.files {
+			if !contains(rels, rel) {
+				rels = append(rels, rel)
+			}
+		}
+	}
+	sort.Strings(rels)
+
+	var diffs []string
+	for _, rel := range rels {
+		var in, notIn []string
+		same := true
+		for _, env := range envs {
+			content, ok := env.files[rel]
+			if !ok {
+				notIn = append(notIn, env.name)
+				continue
+			}
+			in = append(in, env.name)
+			same = same && string(content) == string(envs[0].files[rel])
+		}
+		path := filepath.ToSlash(filepath.Join(moduleDir, rel))
+		switch {
+		case len(notIn) > 0:
+			diffs = append(diffs, fmt.Sprintf("%s is in %s but not in %s", path, strings.Join(in, ", "), strings.Join(notIn, ", ")))
+		case !same:
+			diffs = append(diffs, fmt.Sprintf("%s differs between the environments", path))
+		}
+	}
+	return diffs
+}
+
+// writeEnvironments stages the shared module, the module block calling it, the variables and the .tfvars file of each environment
+func writeEnvironments(tx *file.Transaction, c cli.Config, moduleName string, envs []*environment, inputs []tfconf.EnvironmentInput) error {
+	if err := tx.WriteProviderTF(); err != nil {
+		return err
+	}
+	if err := tx.WriteGitIgnore(); err != nil {
+		return err
+	}
+
+	first := envs[0]
+	if err := tx.WriteTF(c.ResourceName, first.conf.Bytes()); err != nil {
+		return err
+	}
+	for _, rel := range sortedKeys(first.files) {
+		parts := strings.SplitN(rel, string(filepath.Separator), 3)
+		if len(parts) != 3 {
+			continue
+		}
+		var err error
+		switch parts[0] {
+		case "vcl":
+			err = tx.WriteVCL(parts[1], parts[2], first.files[rel])
+		case "content":
+			err = tx.WriteContent(parts[1], parts[2], first.files[rel])
+		case "logformat":
+			err = tx.WriteLogFormat(parts[1], parts[2], first.files[rel])
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// The sensitive attributes are declared once, while their values are set for each environment
+	var sensitiveAttrs []tfconf.SensitiveAttr
+	keys := map[string]bool{}
+	for _, env := range envs {
+		for _, attr := range env.rep.sensitiveAttrs {
+			if !keys[attr.Key] {
+				keys[attr.Key] = true
+				sensitiveAttrs = append(sensitiveAttrs, attr)
+			}
+		}
+	}
+
+	var variables []byte
+	var passed []string
+	if len(sensitiveAttrs) > 0 {
+		variables = tfconf.BuildVariableDefinitions(sensitiveAttrs)
+	}
+	if len(sensitiveAttrs) > 0 && len(inputs) > 0 {
+		variables = append(variables, '\n')
+	}
+	variables = append(variables, tfconf.BuildEnvironmentVariables(inputs)...)
+	for _, input := range inputs {
+		passed = append(passed, input.Key)
+	}
+	for _, attr := range sensitiveAttrs {
+		passed = append(passed, attr.Key)
+	}
+
+	log.Printf("[INFO] Writing the module block for %s", c.ModulePath)
+	if err := tx.WriteModuleTF(moduleName, tfconf.BuildEnvironmentModuleBlock(moduleName, passed)); err != nil {
+		return err
+	}
+	if err := tx.WriteModuleVersionsTF(); err != nil {
+		return err
+	}
+	if len(variables) > 0 {
+		if err := tx.WriteVariablesTF(variables); err != nil {
+			return err
+		}
+		if err := tx.WriteModuleVariablesTF(variables); err != nil {
+			return err
+		}
+	}
+
+	for i, env := range envs {
+		if err := tx.WriteEnvironmentTFVars(env.name, tfconf.BuildEnvironmentTFVars(inputs, i, env.rep.sensitiveAttrs)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printEnvironmentDiffs reports the differences between the environments that the variables do not cover
+func printEnvironmentDiffs(w io.Writer, first string, diffs []string) {
+	if len(diffs) == 0 {
+		return
+	}
+	fmt.Fprintln(w)
+	cli.BoldYellow(w, fmt.Sprintf("Warning: %d difference(s) between the environments cannot be turned into variables", len(diffs)))
+	fmt.Fprintf(w, "The module follows %s in the following, so \"terraform plan\" shows changes in the other environments:\n", first)
+	for _, d := range diffs {
+		fmt.Fprintf(w, "  - %s\n", d)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
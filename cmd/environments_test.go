@@ -0,0 +1,182 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hrmsk66/terraformify/pkg/cli"
+	"github.com/hrmsk66/terraformify/pkg/terraform"
+	"github.com/hrmsk66/terraformify/pkg/terraform/terraformtest"
+)
+
+// recordedEnvironments returns the staging and qa environments, and the runner of the sandbox
+// each of them is imported in, which replays the recorded service
+func recordedEnvironments(t *testing.T) ([]*environment, func(dir string) (terraform.Runner, error)) {
+	t.Helper()
+	// qa is the recorded service with another ID, domain and snippet
+	qa := strings.NewReplacer(
+		"7ManTUgtlSytxeXRMPYY33", "3QaTUgtlSytxeXRMPYY44",
+		"test.terraformify.me", "qa.terraformify.me",
+		`error 403 "Forbidden"`, `error 401 "Unauthorized"`,
+		`error 403 \"Forbidden\"`, `error 401 \"Unauthorized\"`,
+	)
+	recordings := map[string]*strings.Replacer{"staging": strings.NewReplacer(), "qa": qa}
+	var sandboxes []*terraformtest.Runner
+	envs, err := parseEnvironments([]string{"staging=7ManTUgtlSytxeXRMPYY33", "qa=3QaTUgtlSytxeXRMPYY44"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sandboxRunner := func(sandbox string) (terraform.Runner, error) {
+		r := recordings[envs[len(sandboxes)].name]
+		tf := &terraformtest.Runner{
+			Dir:       sandbox,
+			TFVersion: "1.4.5",
+			States: []string{
+				r.Replace(readRecording(t, "vcl_legacy", "state_1.json")),
+				r.Replace(readRecording(t, "vcl_legacy", "state_2.json")),
+			},
+			ShowOutputs: []string{
+				r.Replace(readRecording(t, "vcl_legacy", "show_1.txt")),
+				r.Replace(readRecording(t, "vcl_legacy", "show_2.txt")),
+			},
+		}
+		sandboxes = append(sandboxes, tf)
+		return tf, nil
+	}
+	return envs, sandboxRunner
+}
+
+func TestImportEnvironments(t *testing.T) {
+	dir := t.TempDir()
+	envs, sandboxRunner := recordedEnvironments(t)
+
+	tf := &terraformtest.Runner{Dir: dir, TFVersion: "1.4.5"}
+	c := cli.Config{ResourceName: "service", Directory: dir, ModulePath: "module.service", KeepSnapshots: 10}
+	var out bytes.Buffer
+	if err := importEnvironments(context.Background(), tf, sandboxRunner, c, envs, &out); err != nil {
+		t.Fatalf("importEnvironments failed: %v", err)
+	}
+
+	conf := readOutput(t, dir, filepath.Join("modules", "service", "service.tf"))
+	for _, expected := range []string{`name = var.domain_name`, `var.httpbin_ssl_client_key`, `name               = "terraformify test"`} {
+		if !strings.Contains(conf, expected) {
+			t.Errorf("modules/service/service.tf does not contain %q:\n%s", expected, conf)
+		}
+	}
+	if strings.Contains(conf, "terraformify.me") {
+		t.Errorf("modules/service/service.tf has the domain of an environment:\n%s", conf)
+	}
+
+	module := readOutput(t, dir, "module_service.tf")
+	for _, expected := range []string{`source = "./modules/service"`, "domain_name", "= var.domain_name", "= var.httpbin_ssl_client_key"} {
+		if !strings.Contains(module, expected) {
+			t.Errorf("module_service.tf does not contain %q:\n%s", expected, module)
+		}
+	}
+	for _, name := range []string{"variables.tf", filepath.Join("modules", "service", "variables.tf")} {
+		if variables := readOutput(t, dir, name); !strings.Contains(variables, `variable "domain_name"`) || !strings.Contains(variables, `variable "httpbin_ssl_client_key"`) {
+			t.Errorf("%s does not declare the variables:\n%s", name, variables)
+		}
+	}
+
+	for env, domain := range map[string]string{"staging": "test.terraformify.me", "qa": "qa.terraformify.me"} {
+		tfvars := readOutput(t, dir, env+".tfvars")
+		if !strings.Contains(tfvars, `domain_name`) || !strings.Contains(tfvars, `"`+domain+`"`) || !strings.Contains(tfvars, "httpbin_ssl_client_key") {
+			t.Errorf("%s.tfvars does not have the values of %s:\n%s", env, env, tfvars)
+		}
+	}
+
+	// Each environment has the resources of its own service in its workspace
+	for env, id := range map[string]string{"staging": "7ManTUgtlSytxeXRMPYY33", "qa": "3QaTUgtlSytxeXRMPYY44"} {
+		state := readOutput(t, dir, filepath.Join("terraform.tfstate.d", env, "terraform.tfstate"))
+		if !strings.Contains(state, `"module":"module.service","mode":"managed","type":"fastly_service_vcl"`) || !strings.Contains(state, id) {
+			t.Errorf("the state of %s does not have the service in the module:\n%s", env, state)
+		}
+	}
+	if tf.Workspace != "" && tf.Workspace != "default" {
+		t.Errorf("Workspace = %q, want the one selected before", tf.Workspace)
+	}
+
+	// The snippet differs in the VCL file, which cannot be a variable
+	if !strings.Contains(out.String(), "modules/service/vcl/service/snippet_allow_list_in_recv.vcl differs between the environments") {
+		t.Errorf("output does not report the snippet:\n%s", out.String())
+	}
+}
+
+func TestImportEnvironmentsInterrupted(t *testing.T) {
+	dir := t.TempDir()
+	envs, sandboxRunner := recordedEnvironments(t)
+
+	// Interrupted while pushing the state of qa, after the state of staging has been pushed
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pushes := 0
+	tf := &terraformtest.Runner{Dir: dir, TFVersion: "1.4.5", Hook: func(call string) error {
+		if strings.HasPrefix(call, "state push") {
+			if pushes++; pushes == 2 {
+				cancel()
+			}
+		}
+		return nil
+	}}
+	c := cli.Config{ResourceName: "service", Directory: dir, ModulePath: "module.service", KeepSnapshots: 10}
+	if err := importEnvironments(ctx, tf, sandboxRunner, c, envs, &bytes.Buffer{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+
+	// The state of staging is put back, and the workspaces created by the run are deleted from the one selected before
+	if pushes != 3 {
+		t.Errorf("state pushed %d times, want 3 with the one emptying the state of staging", pushes)
+	}
+	if tf.Workspace != "default" {
+		t.Errorf("Workspace = %q, want default", tf.Workspace)
+	}
+	if len(tf.Workspaces) != 0 {
+		t.Errorf("Workspaces = %q, want none", tf.Workspaces)
+	}
+	for _, name := range []string{"staging.tfvars", "module_service.tf", "modules"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s is left in the working directory", name)
+		}
+	}
+}
+
+func TestImportEnvironmentsFailedImport(t *testing.T) {
+	dir := t.TempDir()
+	envs, sandboxRunner := recordedEnvironments(t)
+	failing := func(sandbox string) (terraform.Runner, error) {
+		tf, err := sandboxRunner(sandbox)
+		if strings.Contains(tf.(*terraformtest.Runner).States[0], "3QaTUgtlSytxeXRMPYY44") {
+			return nil, errors.New("failed to import qa")
+		}
+		return tf, err
+	}
+
+	// Reading the states of the existing workspaces selects them before anything is written
+	tf := &terraformtest.Runner{Dir: dir, TFVersion: "1.4.5", Workspaces: []string{"staging", "qa"}}
+	c := cli.Config{ResourceName: "service", Directory: dir, ModulePath: "module.service", KeepSnapshots: 10}
+	if err := importEnvironments(context.Background(), tf, failing, c, envs, &bytes.Buffer{}); err == nil {
+		t.Fatal("importEnvironments succeeded, want the import error")
+	}
+	if tf.Workspace != "default" {
+		t.Errorf("Workspace = %q, want default", tf.Workspace)
+	}
+}
+
+func TestParseEnvironments(t *testing.T) {
+	for _, args := range [][]string{
+		{"staging=a", "staging=b"},
+		{"staging", "production=b"},
+		{"default=a", "production=b"},
+		{"stag/ing=a", "production=b"},
+	} {
+		if _, err := parseEnvironments(args); err == nil {
+			t.Errorf("parseEnvironments(%q) succeeded", args)
+		}
+	}
+}
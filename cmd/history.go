@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/hrmsk66/terraformify/pkg/cli"
+	"github.com/hrmsk66/terraformify/pkg/file"
+	"github.com/spf13/cobra"
+)
+
+// historyCmd represents the state history command
+var historyCmd = &cobra.Command{
+	Use:          "history",
+	Short:        "List the snapshots of the state saved before each run that modified it",
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		workingDir, err := cmd.Flags().GetString("working-dir")
+		if err != nil {
+			return err
+		}
+
+		workspace, err := cmd.Flags().GetString("workspace")
+		if err != nil {
+			return err
+		}
+
+		return printHistory(os.Stdout, workingDir, workspace)
+	},
+}
+
+func init() {
+	stateCmd.AddCommand(historyCmd)
+}
+
+// printHistory prints the snapshots of the workspace to w, or those of every workspace if it is empty
+func printHistory(w io.Writer, workingDir, workspace string) error {
+	workspaces := []string{workspace}
+	if workspace == "" {
+		var err error
+		if workspaces, err = file.StateSnapshotWorkspaces(workingDir); err != nil {
+			return err
+		}
+	}
+
+	found := false
+	for _, ws := range workspaces {
+		snapshots, err := file.StateSnapshots(workingDir, ws)
+		if err != nil {
+			return err
+		}
+		if len(snapshots) == 0 {
+			continue
+		}
+
+		if found {
+			fmt.Fprintln(w)
+		}
+		found = true
+		fmt.Fprintln(w, cli.Bold(fmt.Sprintf("Workspace %q (%s):", ws, filepath.ToSlash(file.StateSnapshotDir(ws)))))
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "  ID\tSAVED\tSERIAL\tRESOURCES\tVERSION")
+		for _, s := range snapshots {
+			version := s.Version
+			if version == "" {
+				version = "-"
+			}
+			fmt.Fprintf(tw, "  %s\t%s\t%d\t%d\t%s\n", s.ID, s.Saved.Local().Format("2006-01-02 15:04:05"), s.Serial, s.Resources, version)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if !found {
+		fmt.Fprintln(w, "No snapshots found. A snapshot of the state is saved before each run that modifies it")
+	}
+	return nil
+}
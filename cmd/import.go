@@ -0,0 +1,358 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/hrmsk66/terraformify/pkg/cli"
+	"github.com/hrmsk66/terraformify/pkg/file"
+	"github.com/hrmsk66/terraformify/pkg/naming"
+	"github.com/hrmsk66/terraformify/pkg/prop"
+	"github.com/hrmsk66/terraformify/pkg/terraform"
+	"github.com/hrmsk66/terraformify/pkg/tfconf"
+	"github.com/hrmsk66/terraformify/pkg/tfstate"
+)
+
+// importResource imports the resource with "terraform import" or adds an import block for it, depending on the mode
+func importResource(ctx context.Context, tf terraform.Runner, mode terraform.ImportMode, p prop.TFBlock, f io.Writer) error {
+	if mode == terraform.ConfigDrivenImport {
+		return terraform.ImportBlock(p, f)
+	}
+	return terraform.Import(ctx, tf, p, f)
+}
+
+// importResources imports the resources in a single pass.
+// In the config-driven mode, the import blocks for all the resources are read by one plan.
+// "terraform import" takes one resource at a time, so the legacy mode still runs it for each resource.
+func importResources(ctx context.Context, tf terraform.Runner, mode terraform.ImportMode, props []prop.TFBlock, f io.Writer) error {
+	if len(props) == 0 {
+		return nil
+	}
+
+	if mode == terraform.ConfigDrivenImport {
+		log.Printf("[INFO] Importing %d resources in a single pass", len(props))
+		return terraform.ImportBlocks(props, f)
+	}
+
+	if len(props) > 1 {
+		log.Printf(`[INFO] Running "terraform import" for each of the %d resources. Use Terraform 1.5.0 or later to import them in a single pass`, len(props))
+	}
+	for _, p := range props {
+		if err := terraform.Import(ctx, tf, p, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func recursiveImport(ctx context.Context, tf terraform.Runner, mode terraform.ImportMode, p prop.MutatableTfBlock, f io.Writer) error {
+	if mode == terraform.ConfigDrivenImport {
+		return terraform.RecursiveImportBlock(ctx, tf, p, f)
+	}
+	return terraform.RecursiveImport(ctx, tf, p, f)
+}
+
+// readConfig returns the configuration of the imported resources in HCL and the state they were read from.
+// In the config-driven mode, it also returns the path to the saved plan that imports the resources.
+func readConfig(ctx context.Context, tf terraform.Runner, mode terraform.ImportMode, c *cli.Config) (*tfconf.TFConf, *tfstate.TFState, string, error) {
+	if mode == terraform.ConfigDrivenImport {
+		g, err := terraform.GenerateConfig(ctx, tf)
+		if err != nil {
+			return nil, nil, "", err
+		}
+
+		state, err := tfstate.FromPlan(g.Plan)
+		if err != nil {
+			return nil, nil, g.PlanFile, err
+		}
+
+		hcl, err := tfconf.LoadGenerated(g.HCL)
+		if err != nil {
+			return nil, nil, g.PlanFile, err
+		}
+
+		if err = hcl.RestoreIDs(state, c); err != nil {
+			return nil, nil, g.PlanFile, err
+		}
+		return hcl, state, g.PlanFile, nil
+	}
+
+	// Get the config represented in HCL from the "terraform show" output
+	log.Print(`[INFO] Running "terraform show" to get the current Terraform state in HCL format`)
+	rawHCL, err := terraform.Show(ctx, tf)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	hcl, err := tfconf.Load(rawHCL)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	state, err := terraform.PullState(ctx, tf)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return hcl, state, "", nil
+}
+
+// providerSchema returns how to handle the attributes of the resources, read from the schema of the installed fastly provider.
+// If the provider has no schema, as with a provider cache that has been cleared, the attributes known to be computed or sensitive are used.
+func providerSchema(ctx context.Context, tf terraform.Runner) (*tfconf.Schema, error) {
+	schema, err := terraform.ProviderSchema(ctx, tf)
+	if errors.Is(err, terraform.ErrNoProviderSchema) {
+		log.Printf("[WARN] %s. Handling the attributes known to be computed or sensitive instead", err)
+		return tfconf.KnownSchema, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return tfconf.SchemaFrom(schema), nil
+}
+
+// warnSecretLookingValues warns of the values that look like secrets but are written in plain text,
+// since the provider does not mark their attributes sensitive
+func warnSecretLookingValues(w io.Writer, values []tfconf.SecretLookingValue) {
+	for _, v := range values {
+		where := v.Attribute
+		if v.BlockType != "" {
+			where = fmt.Sprintf("%s of %s %q", v.Attribute, v.BlockType, v.Name)
+		}
+		cli.BoldYellow(w, fmt.Sprintf("Warning: %s looks like %s, but it is not sensitive and is written in plain text. Consider moving it to a variable", where, v.Reason))
+	}
+}
+
+// removePlanFile removes the saved plan, which contains the sensitive values of the imported resources
+func removePlanFile(planFile string) {
+	if planFile == "" {
+		return
+	}
+	if err := os.Remove(planFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("[WARN] failed to remove %s: %s", planFile, err)
+	}
+}
+
+// checkNotManaged returns an error if the state already holds a resource at the address of any of the props.
+// The resources to go into a module are imported in the root module first, so both addresses must be free.
+func checkNotManaged(s *tfstate.TFState, c cli.Config, props ...prop.TFBlock) error {
+	for _, p := range props {
+		if err := s.CheckNotManaged("", p.GetType(), p.GetNormalizedName()); err != nil {
+			return err
+		}
+		if c.ModulePath == "" {
+			continue
+		}
+		if err := s.CheckNotManaged(c.ModulePath, p.GetType(), p.GetNormalizedName()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeConfig stages the TF file of the service and the variables for its sensitive attributes.
+// With a module path, the TF file goes into the module directory along with the variables and the required providers
+// of the module, and the module block calling it is written to the working directory.
+// With --as-module, the module also has the variables of the inputs lifted from the service and the outputs,
+// and the module block passing the inputs is written to main.tf.
+func writeConfig(tx *file.Transaction, c cli.Config, hcl *tfconf.TFConf, service prop.TFBlock, sensitiveAttrs []tfconf.SensitiveAttr, inputs []tfconf.ModuleInput) error {
+	conf, err := configContent(c, hcl.Bytes())
+	if err != nil {
+		return err
+	}
+	if err := tx.WriteTF(c.ResourceName, conf); err != nil {
+		return err
+	}
+
+	if err := tx.WriteGitIgnore(); err != nil {
+		return err
+	}
+
+	if len(sensitiveAttrs) > 0 {
+		variables, err := configContent(c, tfconf.BuildVariableDefinitions(sensitiveAttrs))
+		if err != nil {
+			return err
+		}
+		if err := tx.WriteVariablesTF(variables); err != nil {
+			return err
+		}
+
+		tfvars := tfconf.BuildTFVars(sensitiveAttrs)
+		if c.Format == cli.FormatJSON {
+			if tfvars, err = tfconf.TFVarsToJSON(tfvars); err != nil {
+				return err
+			}
+		}
+		if err := tx.WriteTFVars(tfvars); err != nil {
+			return err
+		}
+	}
+
+	if c.ModulePath == "" {
+		return nil
+	}
+	moduleName, err := naming.ModuleName(c.ModulePath)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] Writing the module block for %s", c.ModulePath)
+	moduleBlock, err := configContent(c, tfconf.BuildModuleBlock(moduleName, sensitiveAttrs, inputs))
+	if err != nil {
+		return err
+	}
+	if c.AsModule {
+		err = tx.WriteMainTF(moduleBlock)
+	} else {
+		err = tx.WriteModuleTF(moduleName, moduleBlock)
+	}
+	if err != nil {
+		return err
+	}
+	if err := tx.WriteModuleVersionsTF(); err != nil {
+		return err
+	}
+
+	if len(sensitiveAttrs) > 0 || len(inputs) > 0 {
+		var variables []byte
+		if len(sensitiveAttrs) > 0 {
+			variables = tfconf.BuildVariableDefinitions(sensitiveAttrs)
+		}
+		if len(sensitiveAttrs) > 0 && len(inputs) > 0 {
+			variables = append(variables, '\n')
+		}
+		variables = append(variables, tfconf.BuildInputVariables(inputs)...)
+		if variables, err = configContent(c, variables); err != nil {
+			return err
+		}
+		if err := tx.WriteModuleVariablesTF(variables); err != nil {
+			return err
+		}
+	}
+
+	if c.AsModule {
+		outputs, err := configContent(c, tfconf.BuildModuleOutputs(service))
+		if err != nil {
+			return err
+		}
+		if err := tx.WriteModuleOutputsTF(outputs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// configContent returns the content of a TF file built in the native syntax, converted into the JSON syntax with the json format
+func configContent(c cli.Config, content []byte) ([]byte, error) {
+	if c.Format != cli.FormatJSON {
+		return content, nil
+	}
+	return tfconf.ToJSON(content)
+}
+
+// moveIntoModule moves the imported resources into the module in the state, as they are imported in the root module
+func moveIntoModule(s *tfstate.TFState, c cli.Config, imported []prop.TFBlock) (*tfstate.TFState, error) {
+	params := make([]tfstate.SetModuleParams, 0, len(imported))
+	for _, p := range imported {
+		log.Printf("[INFO] Moving %s to %s.%s in terraform.tfstate", p.GetRef(), c.ModulePath, p.GetRef())
+		params = append(params, tfstate.SetModuleParams{
+			Module:       c.ModulePath,
+			ResourceType: p.GetType(),
+			ResourceName: p.GetNormalizedName(),
+		})
+	}
+	return s.SetModule(params...)
+}
+
+// saveSnapshot saves a copy of the state before the run modifies it, so that it can be put back with "state restore".
+// The version of the tool is recorded with it, so that the state pushed by the run can be traced back to the version.
+// It returns the ID of the snapshot, or an empty string if nothing is saved: in a dry run or with write-imports,
+// which leave the state untouched, or if the workspace has no state yet.
+func saveSnapshot(ctx context.Context, tf terraform.Runner, c cli.Config, s *tfstate.TFState) (string, error) {
+	if s == nil || c.DryRun || c.WriteImports || c.KeepSnapshots == 0 {
+		return "", nil
+	}
+	workspace, err := currentWorkspace(ctx, tf, c.Workspace)
+	if err != nil {
+		return "", err
+	}
+	id, err := file.SaveStateSnapshot(c.Directory, workspace, s, c.KeepSnapshots, getVersion())
+	if err != nil {
+		return "", err
+	}
+	log.Printf("[INFO] Saved the state as snapshot %s in %s", id, file.StateSnapshotDir(workspace))
+	return id, nil
+}
+
+// currentWorkspace returns the workspace, or the selected one if it is empty
+func currentWorkspace(ctx context.Context, tf terraform.Runner, workspace string) (string, error) {
+	if workspace != "" {
+		return workspace, nil
+	}
+	_, current, err := tf.WorkspaceList(ctx)
+	return current, err
+}
+
+// rollback undoes the changes made by an import that did not complete,
+// such as one interrupted by Ctrl-C or stopped by the timeout
+type rollback struct {
+	tf    terraform.Runner
+	tx    *file.Transaction
+	tempf *os.File
+	// snapshot is the state before any resource was imported, which is nil if there was none
+	snapshot    *tfstate.TFState
+	snapshotted bool
+	// snapshotID is the ID the snapshot is saved as, which is empty if it is not saved
+	snapshotID string
+	// workspace is the switch to the workspace of the import, which is nil if it was already selected
+	workspace *terraform.WorkspaceSwitch
+}
+
+// run removes the temp file, restores the state and then the working directory. cause is the error that stopped the import.
+func (r *rollback) run(cause error) {
+	if errors.Is(cause, context.Canceled) {
+		fmt.Fprintln(os.Stderr)
+		cli.BoldYellow(os.Stderr, "Interrupted. Cleaning up")
+	}
+
+	if r.tempf != nil {
+		// The file may have been closed and removed already
+		_ = r.tempf.Close()
+		if err := os.Remove(r.tempf.Name()); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("[WARN] failed to remove %s: %s", r.tempf.Name(), err)
+		}
+	}
+
+	// The state is restored first as pushing it to a remote backend needs the files initialized in the working directory
+	if r.snapshotted {
+		// The context of the import may have been canceled, so the state is restored with a new one
+		if err := terraform.RestoreState(context.Background(), r.tf, r.snapshot); err != nil {
+			log.Printf("[ERROR] failed to restore the state: %s", err)
+			if r.snapshotID != "" {
+				cli.BoldYellow(os.Stderr, fmt.Sprintf(`The state could not be restored. Restore it with "terraformify state restore %s" before running the import again`, r.snapshotID))
+			} else {
+				cli.BoldYellow(os.Stderr, `The state could not be restored. Check it with "terraform state list" before running the import again`)
+			}
+		}
+	}
+
+	// The workspace is switched back once its state is restored, as a workspace created by the import can only be deleted when it is empty
+	undoWorkspace(r.tf, r.workspace)
+
+	if err := r.tx.Rollback(); err != nil {
+		log.Printf("[ERROR] %s", err)
+		cli.BoldYellow(os.Stderr, "The working directory could not be restored. Check the files in it before running the import again")
+	}
+}
+
+// undoWorkspace selects the workspace selected before the switch again and deletes the workspace the switch created
+func undoWorkspace(tf terraform.Runner, sw *terraform.WorkspaceSwitch) {
+	// The context of the command may have been canceled, so the switch is undone with a new one
+	if err := sw.Undo(context.Background(), tf); err != nil {
+		log.Printf("[ERROR] failed to restore the workspace: %s", err)
+		cli.BoldYellow(os.Stderr, fmt.Sprintf(`The workspace could not be restored. Check it with "terraform workspace list" and select %q again`, sw.Previous))
+	}
+}
@@ -0,0 +1,560 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hrmsk66/terraformify/pkg/cli"
+	"github.com/hrmsk66/terraformify/pkg/file"
+	"github.com/hrmsk66/terraformify/pkg/naming"
+	"github.com/hrmsk66/terraformify/pkg/terraform"
+	"github.com/hrmsk66/terraformify/pkg/tfconf"
+	"github.com/spf13/cobra"
+)
+
+// removeCmd represents the service remove command
+var removeCmd = &cobra.Command{
+	Use:          "remove <resource-name>",
+	Short:        "Remove a service imported by terraformify from the working directory and the state",
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		filter := cli.CreateLogFilter()
+		log.Printf("[INFO] CLI version: %s", getVersion())
+		log.SetOutput(filter)
+
+		workingDir, err := cmd.Flags().GetString("working-dir")
+		if err != nil {
+			return err
+		}
+
+		autoYes, err := cmd.Flags().GetBool("yes")
+		if err != nil {
+			return err
+		}
+
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			return err
+		}
+
+		modulePath, err := cmd.Flags().GetString("module-path")
+		if err != nil {
+			return err
+		}
+
+		tfBinary, err := cmd.Flags().GetString("tf-binary")
+		if err != nil {
+			return err
+		}
+
+		workspace, err := cmd.Flags().GetString("workspace")
+		if err != nil {
+			return err
+		}
+
+		timeout, err := cmd.Flags().GetDuration("timeout")
+		if err != nil {
+			return err
+		}
+
+		keepSnapshots, err := cmd.Flags().GetInt("keep-snapshots")
+		if err != nil {
+			return err
+		}
+
+		c := cli.Config{
+			ResourceName:  args[0],
+			Directory:     workingDir,
+			TFBinary:      tfBinary,
+			Workspace:     workspace,
+			ModulePath:    modulePath,
+			Timeout:       timeout,
+			KeepSnapshots: keepSnapshots,
+			DryRun:        dryRun,
+		}
+
+		log.Printf("[INFO] Initializing Terraform")
+		tf, err := terraform.FindExec(c.Directory, c.TFBinary, c.Timeout)
+		if err != nil {
+			return err
+		}
+
+		return removeService(cmd.Context(), tf, c, autoYes, os.Stdout)
+	},
+}
+
+func init() {
+	serviceCmd.AddCommand(removeCmd)
+}
+
+// removal lists what the import of a service left in the working directory and the state
+type removal struct {
+	// addresses are the resources of the service in the state, such as "module.cdn.fastly_service_vcl.service"
+	addresses []string
+	// remove lists the files and the directories to remove, relative to the working directory
+	remove []string
+	// rewrite maps the files to edit, such as variables.tf, to their new content
+	rewrite map[string][]byte
+}
+
+// removeService removes the service named c.ResourceName, which was imported with the same module path.
+// It shows what is to be removed to w and asks for confirmation unless autoYes is set. A dry run stops after showing it.
+func removeService(ctx context.Context, tf terraform.Runner, c cli.Config, autoYes bool, w io.Writer) (err error) {
+	var moduleName string
+	if c.ModulePath != "" {
+		if moduleName, err = naming.ModuleName(c.ModulePath); err != nil {
+			return err
+		}
+	}
+
+	rm, err := planRemoval(c.Directory, c.ResourceName, moduleName)
+	if err != nil {
+		return err
+	}
+
+	// "terraform init" is run to read the state, and the transaction puts back the files it writes in a dry run
+	tx, err := file.Begin(c.Directory)
+	if err != nil {
+		return err
+	}
+	var sw *terraform.WorkspaceSwitch
+	defer func() {
+		if err != nil || c.DryRun {
+			undoWorkspace(tf, sw)
+			if err1 := tx.Rollback(); err1 != nil {
+				log.Printf("[ERROR] %s", err1)
+			}
+			return
+		}
+		err = tx.Close()
+	}()
+
+	log.Printf(`[INFO] Running "terraform init"`)
+	if err = terraform.Init(ctx, tf); err != nil {
+		return err
+	}
+	if c.Workspace != "" {
+		if sw, err = terraform.SelectWorkspace(ctx, tf, c.Workspace); err != nil {
+			return err
+		}
+	}
+
+	curState, err := terraform.SnapshotState(ctx, tf)
+	if err != nil {
+		return err
+	}
+	// Only the resources found in the state are removed from it
+	var addresses []string
+	if curState != nil {
+		managed := map[string]bool{}
+		for _, r := range curState.Resources {
+			managed[r.Address()] = true
+		}
+		for _, addr := range rm.addresses {
+			if managed[addr] {
+				addresses = append(addresses, addr)
+			}
+		}
+	}
+
+	if err = printRemoval(w, c.Directory, addresses, rm); err != nil {
+		return err
+	}
+	if c.DryRun {
+		return nil
+	}
+
+	if !autoYes {
+		yes, err := cli.YesNo(ctx, fmt.Sprintf("Remove %s?", c.ResourceName))
+		if err != nil {
+			return err
+		}
+		if !yes {
+			return errors.New("removal cancelled")
+		}
+	}
+
+	if len(addresses) > 0 {
+		if _, err = saveSnapshot(ctx, tf, c, curState); err != nil {
+			return err
+		}
+	}
+
+	for _, rel := range rm.remove {
+		tx.Remove(rel)
+	}
+	for _, rel := range sortedKeys(rm.rewrite) {
+		if err = tx.Rewrite(rel, rm.rewrite[rel]); err != nil {
+			return err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	if len(addresses) > 0 {
+		log.Print(`[INFO] Removing the resources from terraform.tfstate`)
+		newState, err := curState.RemoveResources(addresses)
+		if err != nil {
+			return err
+		}
+		if err = terraform.PushState(ctx, tf, newState); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintln(os.Stderr)
+	cli.BoldGreen(os.Stderr, "Completed!")
+	return nil
+}
+
+// planRemoval finds the files written by the import of the service and the resources in its TF file.
+// The variables of the sensitive attributes are removed unless another file in the configuration still refers to them.
+func planRemoval(workingDir, resourceName, moduleName string) (*removal, error) {
+	configDir := ""
+	if moduleName != "" {
+		configDir = file.ModuleDir(moduleName)
+	}
+
+	tfFile := filepath.Join(configDir, resourceName+".tf")
+	conf, err := loadConfigFile(workingDir, tfFile)
+	if errors.Is(err, os.ErrNotExist) {
+		if _, err := os.Stat(filepath.Join(workingDir, tfFile+".json")); err == nil {
+			return nil, fmt.Errorf("%s.json is written in the JSON syntax, which remove does not support. remove the service from the configuration and the state by hand", filepath.Join(workingDir, tfFile))
+		}
+		return nil, fmt.Errorf("%s is not found. specify the resource name the service was imported with", filepath.Join(workingDir, tfFile))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rm := &removal{rewrite: map[string][]byte{}}
+	for _, addr := range conf.ResourceAddresses() {
+		if moduleName != "" {
+			addr = "module." + moduleName + "." + addr
+		}
+		rm.addresses = append(rm.addresses, addr)
+	}
+
+	rm.remove = append(rm.remove, tfFile)
+	for _, dir := range []string{"vcl", "content", "logformat", "data"} {
+		rel := filepath.Join(configDir, dir, resourceName)
+		if _, err := os.Stat(filepath.Join(workingDir, rel)); err == nil {
+			rm.remove = append(rm.remove, rel)
+		}
+	}
+
+	vars, err := conf.VariableRefs()
+	if err != nil {
+		return nil, err
+	}
+
+	if moduleName != "" {
+		// The variables no longer used in the module are no longer passed on to it either
+		if vars, err = rm.removeVariables(workingDir, configDir, vars, nil); err != nil {
+			return nil, err
+		}
+
+		// The module block is in main.tf for a module written with --as-module
+		moduleFile := fmt.Sprintf("module_%s.tf", moduleName)
+		module, err := loadConfigFile(workingDir, moduleFile)
+		if errors.Is(err, os.ErrNotExist) {
+			main, err := loadConfigFile(workingDir, "main.tf")
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+			if main != nil && main.HasModuleBlock(moduleName) {
+				moduleFile, module = "main.tf", main
+			}
+		} else if err != nil {
+			return nil, err
+		}
+
+		empty, err := rm.isModuleEmpty(workingDir, configDir)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case empty && moduleFile == "main.tf":
+			rm.removeModuleDir(workingDir, configDir)
+			if vars, err = module.VariableRefs(); err != nil {
+				return nil, err
+			}
+			module.RemoveModuleBlock(moduleName)
+			rm.setContent(moduleFile, module)
+		case empty:
+			rm.removeModuleDir(workingDir, configDir)
+			if module != nil {
+				rm.remove = append(rm.remove, moduleFile)
+				if vars, err = module.VariableRefs(); err != nil {
+					return nil, err
+				}
+			}
+		case module != nil && module.RemoveModuleArguments(moduleName, vars):
+			rm.rewrite[moduleFile] = module.TidyBytes()
+		}
+
+		if !empty {
+			// The outputs of the service no longer have the resources to refer to
+			outputsFile := filepath.Join(configDir, "outputs.tf")
+			outputs, err := loadConfigFile(workingDir, outputsFile)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+			if outputs != nil && outputs.RemoveOutputBlocks(localAddresses(rm.addresses, moduleName)) {
+				rm.setContent(outputsFile, outputs)
+			}
+		}
+	}
+
+	if _, err = rm.removeVariables(workingDir, "", vars, []string{"terraform.tfvars"}); err != nil {
+		return nil, err
+	}
+
+	addresses := map[string]bool{}
+	for _, addr := range rm.addresses {
+		addresses[addr] = true
+	}
+	imports, err := loadConfigFile(workingDir, "imports.tf")
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if imports != nil && imports.RemoveImportBlocks(addresses) {
+		rm.setContent("imports.tf", imports)
+	}
+
+	return rm, nil
+}
+
+// removeModuleDir plans to remove the module directory as a whole, as nothing but the files written along with the module is left in it
+func (rm *removal) removeModuleDir(workingDir, configDir string) {
+	rm.remove = []string{configDir}
+	// Remove the modules directory as well if it was created for the module
+	if entries, err := os.ReadDir(filepath.Join(workingDir, filepath.Dir(configDir))); err == nil && len(entries) == 1 {
+		rm.remove = []string{filepath.Dir(configDir)}
+	}
+	for rel := range rm.rewrite {
+		if strings.HasPrefix(rel, configDir+string(filepath.Separator)) {
+			delete(rm.rewrite, rel)
+		}
+	}
+}
+
+// localAddresses returns the addresses of the resources as they are referred to in the module
+func localAddresses(addresses []string, moduleName string) map[string]bool {
+	local := map[string]bool{}
+	for _, addr := range addresses {
+		local[strings.TrimPrefix(addr, "module."+moduleName+".")] = true
+	}
+	return local
+}
+
+// removeVariables removes the variables no longer referred to by the other TF files in the directory
+// from variables.tf and the values files. It returns the names of the removed variables.
+func (rm *removal) removeVariables(workingDir, dir string, vars map[string]bool, valuesFiles []string) (map[string]bool, error) {
+	used, err := rm.variableRefs(workingDir, dir)
+	if err != nil {
+		return nil, err
+	}
+	unused := map[string]bool{}
+	for name := range vars {
+		if !used[name] {
+			unused[name] = true
+		}
+	}
+	if len(unused) == 0 {
+		return unused, nil
+	}
+
+	variablesFile := filepath.Join(dir, "variables.tf")
+	variables, err := rm.load(workingDir, variablesFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if variables != nil && variables.RemoveVariables(unused) {
+		rm.setContent(variablesFile, variables)
+	}
+
+	for _, rel := range valuesFiles {
+		values, err := rm.load(workingDir, filepath.Join(dir, rel))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		if values != nil && values.RemoveAttributes(unused) {
+			rm.setContent(filepath.Join(dir, rel), values)
+		}
+	}
+	return unused, nil
+}
+
+// variableRefs returns the variables referred to by the TF files in the directory that are kept, as they are after the removal.
+// variables.tf is not included, as the variables defined there are not referred to by it.
+func (rm *removal) variableRefs(workingDir, dir string) (map[string]bool, error) {
+	names, err := rm.keptTFFiles(workingDir, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	refs := map[string]bool{}
+	for _, rel := range names {
+		if filepath.Base(rel) == "variables.tf" {
+			continue
+		}
+		conf, err := rm.load(workingDir, rel)
+		if err != nil {
+			return nil, err
+		}
+		r, err := conf.VariableRefs()
+		if err != nil {
+			return nil, err
+		}
+		for name := range r {
+			refs[name] = true
+		}
+	}
+	return refs, nil
+}
+
+// isModuleEmpty reports whether the module directory has no TF file left other than those written along with the module
+func (rm *removal) isModuleEmpty(workingDir, dir string) (bool, error) {
+	names, err := rm.keptTFFiles(workingDir, dir)
+	if err != nil {
+		return false, err
+	}
+	for _, rel := range names {
+		if base := filepath.Base(rel); base != "variables.tf" && base != "versions.tf" && base != "outputs.tf" {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// keptTFFiles lists the TF files in the directory that are not to be removed, relative to the working directory
+func (rm *removal) keptTFFiles(workingDir, dir string) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(workingDir, dir, "*.tf"))
+	if err != nil {
+		return nil, err
+	}
+
+	removed := map[string]bool{}
+	for _, rel := range rm.remove {
+		removed[rel] = true
+	}
+	var names []string
+	for _, m := range matches {
+		rel, err := filepath.Rel(workingDir, m)
+		if err != nil {
+			return nil, err
+		}
+		if !removed[rel] {
+			names = append(names, rel)
+		}
+	}
+	return names, nil
+}
+
+// load parses the file as it is after the edits planned so far
+func (rm *removal) load(workingDir, rel string) (*tfconf.TFConf, error) {
+	if content, ok := rm.rewrite[rel]; ok {
+		return tfconf.LoadFile(content, rel)
+	}
+	return loadConfigFile(workingDir, rel)
+}
+
+// setContent plans to rewrite the file with the configuration, or to remove it if nothing is left in it
+func (rm *removal) setContent(rel string, conf *tfconf.TFConf) {
+	if conf.IsEmpty() {
+		delete(rm.rewrite, rel)
+		rm.remove = append(rm.remove, rel)
+		return
+	}
+	rm.rewrite[rel] = conf.TidyBytes()
+}
+
+func loadConfigFile(workingDir, rel string) (*tfconf.TFConf, error) {
+	b, err := os.ReadFile(filepath.Join(workingDir, rel))
+	if err != nil {
+		return nil, err
+	}
+	return tfconf.LoadFile(b, rel)
+}
+
+// printRemoval prints the resources to be removed from the state and the files to be removed or edited, as printDryRun does
+func printRemoval(w io.Writer, workingDir string, addresses []string, rm *removal) error {
+	fmt.Fprintln(w, cli.Bold("Resources to remove from the state:"))
+	if len(addresses) == 0 {
+		fmt.Fprintln(w, "  (none)")
+	}
+	for _, addr := range addresses {
+		fmt.Fprintf(w, "  %s\n", addr)
+	}
+
+	edited := sortedKeys(rm.rewrite)
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, cli.Bold("Files:"))
+	for _, rel := range rm.remove {
+		info, err := os.Stat(filepath.Join(workingDir, rel))
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if info.IsDir() {
+			name += "/"
+		}
+		fmt.Fprintf(w, "  %-9s %s\n", "remove", name)
+	}
+	for _, rel := range edited {
+		fmt.Fprintf(w, "  %-9s %s\n", "edit", filepath.ToSlash(rel))
+	}
+	// The values removed from terraform.tfvars are hidden, as the variables of the sensitive attributes are declared sensitive
+	configs, err := rootConfigFiles(workingDir)
+	if err != nil {
+		return err
+	}
+	sensitive := sensitiveVariables(configs, nil)
+	for _, rel := range edited {
+		before, err := os.ReadFile(filepath.Join(workingDir, rel))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w)
+		fmt.Fprint(w, file.UnifiedDiff(filepath.ToSlash(rel), redactTFVars(rel, string(before), sensitive), redactTFVars(rel, string(rm.rewrite[rel]), sensitive)))
+	}
+	return nil
+}
+
+// rootConfigFiles reads the TF files in the root module of the working directory, keyed by their names
+func rootConfigFiles(workingDir string) (map[string]string, error) {
+	files := map[string]string{}
+	for _, pattern := range []string{"*.tf", "*.tf.json"} {
+		paths, err := filepath.Glob(filepath.Join(workingDir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range paths {
+			b, err := os.ReadFile(p)
+			if err != nil {
+				return nil, err
+			}
+			files[filepath.Base(p)] = string(b)
+		}
+	}
+	return files, nil
+}
+
+func sortedKeys(m map[string][]byte) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,196 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hrmsk66/terraformify/pkg/cli"
+	"github.com/hrmsk66/terraformify/pkg/file"
+	"github.com/hrmsk66/terraformify/pkg/terraform/terraformtest"
+)
+
+// importLegacy imports the recorded VCL service into the directory
+func importLegacy(t *testing.T, dir, modulePath string) {
+	t.Helper()
+	importLegacyConfig(t, cli.Config{
+		ID:           "7ManTUgtlSytxeXRMPYY33",
+		ResourceName: "service",
+		Directory:    dir,
+		ModulePath:   modulePath,
+	})
+}
+
+// importLegacyConfig imports the recorded VCL service with the configuration
+func importLegacyConfig(t *testing.T, c cli.Config) {
+	t.Helper()
+	tf := &terraformtest.Runner{
+		Dir:       c.Directory,
+		TFVersion: "1.4.5",
+		States: []string{
+			readRecording(t, "vcl_legacy", "state_1.json"),
+			readRecording(t, "vcl_legacy", "state_2.json"),
+		},
+		ShowOutputs: []string{
+			readRecording(t, "vcl_legacy", "show_1.txt"),
+			readRecording(t, "vcl_legacy", "show_2.txt"),
+		},
+	}
+	if err := importVCL(context.Background(), tf, c, &report{}); err != nil {
+		t.Fatalf("importVCL failed: %v", err)
+	}
+}
+
+func TestRemoveService(t *testing.T) {
+	dir := t.TempDir()
+	// A variable used by another service is kept
+	writeFiles(t, dir, map[string]string{
+		"other.tf":         "resource \"fastly_service_vcl\" \"other\" {\n  name = var.shared\n}\n",
+		"variables.tf":     "variable \"shared\" {\n  type = string\n}\n",
+		"terraform.tfvars": "shared = \"value\"\n",
+	})
+	importLegacy(t, dir, "")
+
+	tf := &terraformtest.Runner{Dir: dir, TFVersion: "1.4.5"}
+	c := cli.Config{ResourceName: "service", Directory: dir}
+	var out bytes.Buffer
+	if err := removeService(context.Background(), tf, c, true, &out); err != nil {
+		t.Fatalf("removeService failed: %v", err)
+	}
+
+	for _, expected := range []string{"fastly_service_vcl.service", "fastly_service_acl_entries.allow_list", "remove    service.tf", "edit      variables.tf", "-httpbin_ssl_client_key = (sensitive value)"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("output does not contain %q:\n%s", expected, out.String())
+		}
+	}
+	// The secrets removed from terraform.tfvars are not printed
+	if strings.Contains(out.String(), "BEGIN PRIVATE KEY") {
+		t.Errorf("output contains the value of a sensitive variable:\n%s", out.String())
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "service.tf")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("service.tf is not removed")
+	}
+	variables := readOutput(t, dir, "variables.tf")
+	if !strings.Contains(variables, `variable "shared"`) || strings.Contains(variables, "httpbin_ssl_client_key") {
+		t.Errorf("variables.tf is not cleaned up:\n%s", variables)
+	}
+	if tfvars := readOutput(t, dir, "terraform.tfvars"); tfvars != "shared = \"value\"\n" {
+		t.Errorf("terraform.tfvars is not cleaned up:\n%s", tfvars)
+	}
+	if state := readOutput(t, dir, "terraform.tfstate"); strings.Contains(state, "fastly_service") {
+		t.Errorf("resources are left in the state:\n%s", state)
+	}
+}
+
+func TestRemoveServiceModule(t *testing.T) {
+	dir := t.TempDir()
+	importLegacy(t, dir, "module.cdn")
+
+	tf := &terraformtest.Runner{Dir: dir, TFVersion: "1.4.5"}
+	c := cli.Config{ResourceName: "service", Directory: dir, ModulePath: "module.cdn"}
+	if err := removeService(context.Background(), tf, c, true, &bytes.Buffer{}); err != nil {
+		t.Fatalf("removeService failed: %v", err)
+	}
+
+	// The module has nothing left in it, and so are the variables passed on to it
+	for _, name := range []string{"modules", "module_cdn.tf", "variables.tf", "terraform.tfvars"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s is not removed", name)
+		}
+	}
+	if state := readOutput(t, dir, "terraform.tfstate"); strings.Contains(state, "fastly_service") {
+		t.Errorf("resources are left in the state:\n%s", state)
+	}
+}
+
+func TestRemoveServiceAsModule(t *testing.T) {
+	dir := t.TempDir()
+	// main.tf has a module block for another module, which is kept
+	writeFiles(t, dir, map[string]string{
+		"main.tf": "module \"other\" {\n  source = \"./modules/other\"\n}\n",
+	})
+	importLegacyConfig(t, cli.Config{
+		ID:           "7ManTUgtlSytxeXRMPYY33",
+		ResourceName: "service",
+		Directory:    dir,
+		ModulePath:   "module.service",
+		AsModule:     true,
+	})
+
+	tf := &terraformtest.Runner{Dir: dir, TFVersion: "1.4.5"}
+	c := cli.Config{ResourceName: "service", Directory: dir, ModulePath: "module.service"}
+	if err := removeService(context.Background(), tf, c, true, &bytes.Buffer{}); err != nil {
+		t.Fatalf("removeService failed: %v", err)
+	}
+
+	// The outputs of the module are removed with it
+	for _, name := range []string{"modules", "variables.tf", "terraform.tfvars"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s is not removed", name)
+		}
+	}
+	if main := readOutput(t, dir, "main.tf"); !strings.Contains(main, `module "other"`) || strings.Contains(main, `module "service"`) {
+		t.Errorf("main.tf is not cleaned up:\n%s", main)
+	}
+	if state := readOutput(t, dir, "terraform.tfstate"); strings.Contains(state, "fastly_service") {
+		t.Errorf("resources are left in the state:\n%s", state)
+	}
+}
+
+func TestRemoveServiceDryRun(t *testing.T) {
+	dir := t.TempDir()
+	// terraform.tfvars is edited, not removed, so its diff is shown
+	writeFiles(t, dir, map[string]string{
+		"other.tf":         "resource \"fastly_service_vcl\" \"other\" {\n  name = var.shared\n}\n",
+		"variables.tf":     "variable \"shared\" {\n  type = string\n}\n",
+		"terraform.tfvars": "shared = \"value\"\n",
+	})
+	importLegacy(t, dir, "")
+	before, err := file.Snapshot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tf := &terraformtest.Runner{Dir: dir, TFVersion: "1.4.5"}
+	c := cli.Config{ResourceName: "service", Directory: dir, DryRun: true}
+	var out bytes.Buffer
+	if err := removeService(context.Background(), tf, c, false, &out); err != nil {
+		t.Fatalf("removeService failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "-httpbin_ssl_client_key = (sensitive value)") || strings.Contains(out.String(), "BEGIN PRIVATE KEY") {
+		t.Errorf("output does not hide the value of the sensitive variable:\n%s", out.String())
+	}
+
+	after, err := file.Snapshot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(before, after) {
+		t.Errorf("working directory is changed by the dry run")
+	}
+	for _, call := range tf.Calls {
+		if call == "state push" {
+			t.Errorf("state push was run in the dry run")
+		}
+	}
+}
+
+func TestRemoveServiceNotFound(t *testing.T) {
+	dir := t.TempDir()
+	tf := &terraformtest.Runner{Dir: dir, TFVersion: "1.4.5"}
+	c := cli.Config{ResourceName: "service", Directory: dir}
+
+	err := removeService(context.Background(), tf, c, true, &bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "service.tf is not found") {
+		t.Fatalf("err = %v, want service.tf not to be found", err)
+	}
+	if len(tf.Calls) != 0 {
+		t.Errorf("Terraform was run: %q", tf.Calls)
+	}
+}
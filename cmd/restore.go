@@ -0,0 +1,181 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/hrmsk66/terraformify/pkg/cli"
+	"github.com/hrmsk66/terraformify/pkg/file"
+	"github.com/hrmsk66/terraformify/pkg/terraform"
+	"github.com/hrmsk66/terraformify/pkg/tfstate"
+	"github.com/spf13/cobra"
+)
+
+// restoreCmd represents the state restore command
+var restoreCmd = &cobra.Command{
+	Use:          "restore <snapshot>",
+	Short:        `Restore the state to a snapshot listed by "state history"`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		filter := cli.CreateLogFilter()
+		log.Printf("[INFO] CLI version: %s", getVersion())
+		log.SetOutput(filter)
+
+		workingDir, err := cmd.Flags().GetString("working-dir")
+		if err != nil {
+			return err
+		}
+
+		autoYes, err := cmd.Flags().GetBool("yes")
+		if err != nil {
+			return err
+		}
+
+		tfBinary, err := cmd.Flags().GetString("tf-binary")
+		if err != nil {
+			return err
+		}
+
+		workspace, err := cmd.Flags().GetString("workspace")
+		if err != nil {
+			return err
+		}
+
+		timeout, err := cmd.Flags().GetDuration("timeout")
+		if err != nil {
+			return err
+		}
+
+		keepSnapshots, err := cmd.Flags().GetInt("keep-snapshots")
+		if err != nil {
+			return err
+		}
+
+		c := cli.Config{
+			Directory:     workingDir,
+			TFBinary:      tfBinary,
+			Workspace:     workspace,
+			Timeout:       timeout,
+			KeepSnapshots: keepSnapshots,
+		}
+
+		log.Printf("[INFO] Initializing Terraform")
+		tf, err := terraform.FindExec(c.Directory, c.TFBinary, c.Timeout)
+		if err != nil {
+			return err
+		}
+
+		return restoreState(cmd.Context(), tf, c, args[0], autoYes, os.Stdout)
+	},
+}
+
+func init() {
+	stateCmd.AddCommand(restoreCmd)
+}
+
+// restoreState writes the snapshot of the ID over the state of the workspace. The current state is saved
+// as a snapshot first, so that the restore can be undone in turn. It shows what is to change to w and asks
+// for confirmation unless autoYes is set.
+func restoreState(ctx context.Context, tf terraform.Runner, c cli.Config, id string, autoYes bool, w io.Writer) (err error) {
+	log.Printf(`[INFO] Running "terraform init"`)
+	if err = terraform.Init(ctx, tf); err != nil {
+		return err
+	}
+	if c.Workspace != "" {
+		var sw *terraform.WorkspaceSwitch
+		if sw, err = terraform.SelectWorkspace(ctx, tf, c.Workspace); err != nil {
+			return err
+		}
+		defer func() {
+			if err != nil {
+				undoWorkspace(tf, sw)
+			}
+		}()
+	}
+	if c.Workspace, err = currentWorkspace(ctx, tf, c.Workspace); err != nil {
+		return err
+	}
+
+	snapshot, err := file.LoadStateSnapshot(c.Directory, c.Workspace, id)
+	if err != nil {
+		return err
+	}
+	curState, err := terraform.SnapshotState(ctx, tf)
+	if err != nil {
+		return err
+	}
+	if curState != nil && curState.Lineage != snapshot.Lineage {
+		return fmt.Errorf("snapshot %s has lineage %q, but the state of workspace %q has %q. it is a snapshot of another state", id, snapshot.Lineage, c.Workspace, curState.Lineage)
+	}
+
+	printRestore(w, c.Workspace, id, curState, snapshot)
+
+	if !autoYes {
+		yes, err := cli.YesNo(ctx, fmt.Sprintf("Restore the state to snapshot %s?", id))
+		if err != nil {
+			return err
+		}
+		if !yes {
+			return errors.New("restore cancelled")
+		}
+	}
+
+	if _, err = saveSnapshot(ctx, tf, c, curState); err != nil {
+		return err
+	}
+	log.Printf("[INFO] Restoring the state to snapshot %s", id)
+	if err = terraform.ReplaceState(ctx, tf, curState, snapshot); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(os.Stderr)
+	cli.BoldGreen(os.Stderr, "Completed!")
+	return nil
+}
+
+// printRestore prints the resources the restore removes from the state and puts back into it
+func printRestore(w io.Writer, workspace, id string, cur, snapshot *tfstate.TFState) {
+	current := map[string]bool{}
+	var serial int64
+	if cur != nil {
+		serial = cur.Serial
+		for _, r := range cur.Resources {
+			current[r.Address()] = true
+		}
+	}
+	restored := map[string]bool{}
+	for _, r := range snapshot.Resources {
+		restored[r.Address()] = true
+	}
+
+	fmt.Fprintf(w, "Restoring the state of workspace %q (serial %d) to snapshot %s (serial %d)\n", workspace, serial, id, snapshot.Serial)
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, cli.Bold("Resources to remove from the state:"))
+	printAddresses(w, cur, restored)
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, cli.Bold("Resources to put back into the state:"))
+	printAddresses(w, snapshot, current)
+}
+
+// printAddresses prints the addresses of the resources in the state that are not in exclude
+func printAddresses(w io.Writer, s *tfstate.TFState, exclude map[string]bool) {
+	n := 0
+	if s != nil {
+		for _, r := range s.Resources {
+			if addr := r.Address(); !exclude[addr] {
+				fmt.Fprintf(w, "  %s\n", addr)
+				n++
+			}
+		}
+	}
+	if n == 0 {
+		fmt.Fprintln(w, "  (none)")
+	}
+}
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hrmsk66/terraformify/pkg/cli"
+	"github.com/hrmsk66/terraformify/pkg/file"
+	"github.com/hrmsk66/terraformify/pkg/terraform/terraformtest"
+)
+
+func TestRestoreState(t *testing.T) {
+	dir := t.TempDir()
+	importLegacy(t, dir, "")
+
+	defer func(v string) { version = v }(version)
+	version = "v1.2.3"
+
+	// The state is saved before the service is removed from it, along with the version that removes it
+	tf := &terraformtest.Runner{Dir: dir, TFVersion: "1.4.5"}
+	c := cli.Config{ResourceName: "service", Directory: dir, KeepSnapshots: 10}
+	if err := removeService(context.Background(), tf, c, true, &bytes.Buffer{}); err != nil {
+		t.Fatalf("removeService failed: %v", err)
+	}
+	snapshots, err := file.StateSnapshots(dir, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 1 {
+		t.Fatalf("got %d snapshots, want 1", len(snapshots))
+	}
+	if snapshots[0].Version != "v1.2.3" {
+		t.Errorf("version = %q, want v1.2.3", snapshots[0].Version)
+	}
+	id := snapshots[0].ID
+
+	var out bytes.Buffer
+	if err := printHistory(&out, dir, ""); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), `Workspace "default"`) || !strings.Contains(out.String(), id) || !strings.Contains(out.String(), "v1.2.3") {
+		t.Errorf("history does not list the snapshot %s:\n%s", id, out.String())
+	}
+
+	tf = &terraformtest.Runner{Dir: dir, TFVersion: "1.4.5"}
+	c = cli.Config{Directory: dir, KeepSnapshots: 10}
+	out.Reset()
+	if err := restoreState(context.Background(), tf, c, id, true, &out); err != nil {
+		t.Fatalf("restoreState failed: %v", err)
+	}
+
+	put := out.String()[strings.Index(out.String(), "Resources to put back"):]
+	for _, addr := range []string{"fastly_service_vcl.service", "fastly_service_acl_entries.allow_list"} {
+		if !strings.Contains(put, addr) {
+			t.Errorf("output does not list %s to put back:\n%s", addr, out.String())
+		}
+	}
+	if state := readOutput(t, dir, "terraform.tfstate"); !strings.Contains(state, `"fastly_service_vcl"`) {
+		t.Errorf("the resources are not put back into the state:\n%s", state)
+	}
+
+	// The state before the restore is saved too, so that the restore can be undone
+	if snapshots, err = file.StateSnapshots(dir, "default"); err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 {
+		t.Errorf("got %d snapshots, want 2", len(snapshots))
+	}
+
+	if err := restoreState(context.Background(), tf, c, "20000101T000000Z-1", true, &bytes.Buffer{}); err == nil {
+		t.Error("restoreState succeeded for a missing snapshot")
+	}
+}
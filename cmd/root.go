@@ -1,11 +1,14 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"runtime/debug"
 	"strings"
+	"syscall"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -35,8 +38,18 @@ var rootCmd = &cobra.Command{
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
-	err := rootCmd.Execute()
+	// Ctrl-C cancels the context so that the import can clean up after itself
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		// A second Ctrl-C terminates the process immediately
+		stop()
+	}()
+
+	err := rootCmd.ExecuteContext(ctx)
 	if err != nil {
+		stop()
 		os.Exit(1)
 	}
 }
@@ -47,6 +60,10 @@ func init() {
 	// Persistent flags
 	// rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.terraformify.yaml)")
 	rootCmd.PersistentFlags().StringP("working-dir", "d", ".", "Terraform working directory")
+	rootCmd.PersistentFlags().String("tf-binary", "", "Path to the terraform or tofu executable (default: terraform or tofu found in PATH)")
+	rootCmd.PersistentFlags().String("workspace", "", "Terraform workspace to import the service into. It is created if it does not exist (default: the currently selected workspace)")
+	rootCmd.PersistentFlags().Duration("timeout", 0, "Maximum time each Terraform command may take, such as 10m (default: no limit)")
+	rootCmd.PersistentFlags().Int("keep-snapshots", 10, "Number of snapshots of the state to keep in .terraformify/snapshots for each workspace. A snapshot is saved before every run that modifies the state. 0 saves none")
 	rootCmd.PersistentFlags().StringP("api-key", "k", "", "Fastly API token (or via FASTLY_API_KEY)")
 	rootCmd.PersistentFlags().BoolP("skip-edit-state", "s", false, "Skip editing terraform.tfstate and leave it untouched (Note: Diffs will be detected on terraform plan/apply)")
 	rootCmd.PersistentFlags().BoolP("yes", "y", false, "Answer yes automatically to all Yes/No confirmations")
@@ -1,6 +1,9 @@
 package cmd
 
 import (
+	"fmt"
+
+	"github.com/hrmsk66/terraformify/pkg/cli"
 	"github.com/spf13/cobra"
 )
 
@@ -17,4 +20,40 @@ func init() {
 	serviceCmd.PersistentFlags().IntP("version", "v", 0, "Version of the service to be imported")
 	serviceCmd.PersistentFlags().BoolP("manage-all", "m", false, "Manage all associated resources")
 	serviceCmd.PersistentFlags().BoolP("force-destroy", "f", false, "Set force-destroy to true for the service and associated resources")
+	serviceCmd.PersistentFlags().Bool("write-imports", false, "Write import blocks to imports.tf and leave terraform.tfstate untouched. The resources are imported on the next terraform apply (Requires Terraform 1.5.0 or later)")
+	serviceCmd.PersistentFlags().String("module-path", "", "Module to import the service into, such as module.cdn. The configuration is written to modules/<name> and called from the root module (default: the root module)")
+	serviceCmd.PersistentFlags().Bool("as-module", false, "Write the service to a reusable module in modules/<name>, with the values that differ between environments, such as the domains and the backend addresses, lifted into its inputs. main.tf calls it with the current values. The module is named after the resource name unless --module-path is given")
+	serviceCmd.PersistentFlags().String("format", cli.FormatHCL, "Syntax of the generated configuration: hcl, or json to write .tf.json files such as service.tf.json and terraform.tfvars.json")
+	serviceCmd.PersistentFlags().Bool("dynamic-blocks", false, "Collapse the repeated backend, condition, header and cache_setting blocks of the service into dynamic blocks iterating over locals")
+	serviceCmd.PersistentFlags().String("dynamic-blocks-data", "", "Write the values of the dynamic blocks to data files in data/<name>, in json or yaml, instead of locals. Implies --dynamic-blocks")
+	serviceCmd.PersistentFlags().Bool("strict", false, "Exit with an error if \"terraform plan\" shows any change right after the import. The import is kept")
+	serviceCmd.PersistentFlags().Bool("dry-run", false, "Run the import in a copy of the working directory and show the files and the state edits it would make, leaving the directory and the backend untouched")
+}
+
+// formatFlag reads the format of the generated configuration
+func formatFlag(cmd *cobra.Command) (string, error) {
+	format, err := cmd.Flags().GetString("format")
+	if err != nil {
+		return "", err
+	}
+	if format != cli.FormatHCL && format != cli.FormatJSON {
+		return "", fmt.Errorf("unknown format %q. specify %s or %s", format, cli.FormatHCL, cli.FormatJSON)
+	}
+	return format, nil
+}
+
+// dynamicBlocksFlags reads whether the repeated blocks are collapsed into dynamic blocks, and the format of their data files
+func dynamicBlocksFlags(cmd *cobra.Command) (bool, string, error) {
+	dynamicBlocks, err := cmd.Flags().GetBool("dynamic-blocks")
+	if err != nil {
+		return false, "", err
+	}
+	data, err := cmd.Flags().GetString("dynamic-blocks-data")
+	if err != nil {
+		return false, "", err
+	}
+	if data != "" && data != cli.DataJSON && data != cli.DataYAML {
+		return false, "", fmt.Errorf("unknown data format %q. specify %s or %s", data, cli.DataJSON, cli.DataYAML)
+	}
+	return dynamicBlocks || data != "", data, nil
 }
@@ -0,0 +1,15 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// stateCmd represents the state command
+var stateCmd = &cobra.Command{
+	Use:   "state",
+	Short: "List and restore the snapshots of the state saved before each run",
+}
+
+func init() {
+	rootCmd.AddCommand(stateCmd)
+}
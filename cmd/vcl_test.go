@@ -0,0 +1,772 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	hcljson "github.com/hashicorp/hcl/v2/json"
+	"github.com/hrmsk66/terraformify/pkg/cli"
+	"github.com/hrmsk66/terraformify/pkg/file"
+	"github.com/hrmsk66/terraformify/pkg/terraform/terraformtest"
+)
+
+func readRecording(t *testing.T, name ...string) string {
+	t.Helper()
+	p := filepath.Join(append([]string{"..", "testdata", "recorded"}, name...)...)
+	b, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatalf("Failed to read recording %s: %v", p, err)
+	}
+	return string(b)
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readOutput(t *testing.T, dir, name string) string {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", name, err)
+	}
+	return string(b)
+}
+
+func TestImportVCLLegacy(t *testing.T) {
+	dir := t.TempDir()
+	tf := &terraformtest.Runner{
+		Dir:       dir,
+		TFVersion: "1.4.5",
+		States: []string{
+			readRecording(t, "vcl_legacy", "state_1.json"),
+			readRecording(t, "vcl_legacy", "state_2.json"),
+		},
+		ShowOutputs: []string{
+			readRecording(t, "vcl_legacy", "show_1.txt"),
+			readRecording(t, "vcl_legacy", "show_2.txt"),
+		},
+	}
+	c := cli.Config{
+		ID:           "7ManTUgtlSytxeXRMPYY33",
+		ResourceName: "service",
+		Directory:    dir,
+	}
+
+	if err := importVCL(context.Background(), tf, c, &report{}); err != nil {
+		t.Fatalf("importVCL failed: %v", err)
+	}
+
+	expectedCalls := []string{
+		"version",
+		"init",
+		"state pull",
+		"import fastly_service_vcl.service 7ManTUgtlSytxeXRMPYY33",
+		"show", "state pull",
+		"import fastly_service_acl_entries.allow_list 7ManTUgtlSytxeXRMPYY33/2Csd4ocnhkhD3J5KIP4OeK",
+		"show", "state pull",
+		"providers schema",
+		"state pull", "state pull", "state push",
+		"refresh",
+		"plan -json",
+	}
+	if !reflect.DeepEqual(tf.Calls, expectedCalls) {
+		t.Errorf("Calls = %q, want %q", tf.Calls, expectedCalls)
+	}
+
+	conf := readOutput(t, dir, "service.tf")
+	for _, expected := range []string{
+		`resource "fastly_service_vcl" "service"`,
+		`resource "fastly_service_acl_entries" "allow_list"`,
+		`for_each`,
+		`var.httpbin_ssl_client_key`,
+	} {
+		if !strings.Contains(conf, expected) {
+			t.Errorf("service.tf does not contain %q:\n%s", expected, conf)
+		}
+	}
+	for _, unexpected := range []string{"7ManTUgtlSytxeXRMPYY33", "active_version", "(sensitive value)"} {
+		if strings.Contains(conf, unexpected) {
+			t.Errorf("service.tf contains %q:\n%s", unexpected, conf)
+		}
+	}
+
+	state := readOutput(t, dir, "terraform.tfstate")
+	for _, expected := range []string{`"activate":true`, `"index_key":"allow list"`} {
+		if !strings.Contains(state, expected) {
+			t.Errorf("terraform.tfstate does not contain %q:\n%s", expected, state)
+		}
+	}
+
+	tfvars := readOutput(t, dir, "terraform.tfvars")
+	if !strings.Contains(tfvars, "httpbin_ssl_client_key") {
+		t.Errorf("terraform.tfvars does not contain httpbin_ssl_client_key:\n%s", tfvars)
+	}
+}
+
+func TestImportVCLConfigDriven(t *testing.T) {
+	dir := t.TempDir()
+	tf := &terraformtest.Runner{
+		Dir:       dir,
+		TFVersion: "1.6.0",
+		Plans: []terraformtest.Plan{
+			{
+				GeneratedConfig: readRecording(t, "vcl_config_driven", "generated_1.tf"),
+				JSON:            readRecording(t, "vcl_config_driven", "plan_1.json"),
+			},
+			{
+				GeneratedConfig: readRecording(t, "vcl_config_driven", "generated_2.tf"),
+				JSON:            readRecording(t, "vcl_config_driven", "plan_2.json"),
+			},
+		},
+		States: []string{
+			readRecording(t, "vcl_config_driven", "state_applied.json"),
+		},
+	}
+	c := cli.Config{
+		ID:           "7ManTUgtlSytxeXRMPYY33",
+		ResourceName: "service",
+		Directory:    dir,
+	}
+
+	if err := importVCL(context.Background(), tf, c, &report{}); err != nil {
+		t.Fatalf("importVCL failed: %v", err)
+	}
+
+	// The ACLs and the dictionary are imported by a single plan
+	expectedCalls := []string{
+		"version",
+		"init",
+		"state pull",
+		"plan", "show -json",
+		"plan", "show -json",
+		"providers schema",
+		"show -json", "apply",
+		"state pull", "state pull", "state push",
+		"refresh",
+		"plan -json",
+	}
+	if !reflect.DeepEqual(tf.Calls, expectedCalls) {
+		t.Errorf("Calls = %q, want %q", tf.Calls, expectedCalls)
+	}
+
+	conf := readOutput(t, dir, "service.tf")
+	for _, expected := range []string{
+		`resource "fastly_service_acl_entries" "allow_list"`,
+		`resource "fastly_service_acl_entries" "deny_list"`,
+		`resource "fastly_service_dictionary_items" "redirects"`,
+	} {
+		if !strings.Contains(conf, expected) {
+			t.Errorf("service.tf does not contain %q:\n%s", expected, conf)
+		}
+	}
+
+	state := readOutput(t, dir, "terraform.tfstate")
+	for _, expected := range []string{
+		`"activate":true`,
+		`"index_key":"allow list"`,
+		`"index_key":"deny list"`,
+		`"index_key":"redirects"`,
+	} {
+		if !strings.Contains(state, expected) {
+			t.Errorf("terraform.tfstate does not contain %q:\n%s", expected, state)
+		}
+	}
+
+	for _, name := range []string{"terraformify.tfplan", "terraformify_generated.tf"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s is left in the working directory", name)
+		}
+	}
+}
+
+func TestImportVCLConfigDrivenRefusesChanges(t *testing.T) {
+	dir := t.TempDir()
+
+	// The ACL entries would be updated by applying the plan
+	plan := strings.Replace(readRecording(t, "vcl_config_driven", "plan_2.json"), `"no-op"`, `"update"`, 2)
+	tf := &terraformtest.Runner{
+		Dir:       dir,
+		TFVersion: "1.6.0",
+		Plans: []terraformtest.Plan{
+			{
+				GeneratedConfig: readRecording(t, "vcl_config_driven", "generated_1.tf"),
+				JSON:            readRecording(t, "vcl_config_driven", "plan_1.json"),
+			},
+			{
+				GeneratedConfig: readRecording(t, "vcl_config_driven", "generated_2.tf"),
+				JSON:            plan,
+			},
+		},
+		States: []string{
+			readRecording(t, "vcl_config_driven", "state_applied.json"),
+		},
+	}
+	c := cli.Config{
+		ID:           "7ManTUgtlSytxeXRMPYY33",
+		ResourceName: "service",
+		Directory:    dir,
+	}
+
+	err := importVCL(context.Background(), tf, c, &report{})
+	if err == nil || !strings.Contains(err.Error(), "refused to apply the plan") {
+		t.Fatalf("importVCL error = %v, want the plan refused", err)
+	}
+	for _, call := range tf.Calls {
+		if call == "apply" {
+			t.Errorf("the plan is applied: %q", tf.Calls)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "service.tf")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("service.tf is left in the working directory")
+	}
+}
+
+func TestImportVCLRemoteBackend(t *testing.T) {
+	backend := terraformtest.NewHTTPBackend("")
+	defer backend.Close()
+
+	dir := t.TempDir()
+	tf := &terraformtest.Runner{
+		Dir:       dir,
+		Backend:   backend.URL,
+		TFVersion: "1.4.5",
+		States: []string{
+			readRecording(t, "vcl_legacy", "state_1.json"),
+			readRecording(t, "vcl_legacy", "state_2.json"),
+		},
+		ShowOutputs: []string{
+			readRecording(t, "vcl_legacy", "show_1.txt"),
+			readRecording(t, "vcl_legacy", "show_2.txt"),
+		},
+	}
+	c := cli.Config{
+		ID:           "7ManTUgtlSytxeXRMPYY33",
+		ResourceName: "service",
+		Directory:    dir,
+	}
+
+	if err := importVCL(context.Background(), tf, c, &report{}); err != nil {
+		t.Fatalf("importVCL failed: %v", err)
+	}
+
+	state := backend.State()
+	for _, expected := range []string{
+		`"activate":true`,
+		`"index_key":"allow list"`,
+		`"lineage":"9b1c6f0e-5d43-4c3a-8e0b-1f2a3b4c5d6e"`,
+		`"serial":3`,
+	} {
+		if !strings.Contains(state, expected) {
+			t.Errorf("remote state does not contain %q:\n%s", expected, state)
+		}
+	}
+
+	// Nothing is left in the working directory
+	matches, err := filepath.Glob(filepath.Join(dir, "*.tfstate"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) > 0 {
+		t.Errorf("state files are left in the working directory: %v", matches)
+	}
+}
+
+func TestImportVCLWorkspace(t *testing.T) {
+	dir := t.TempDir()
+	tf := &terraformtest.Runner{
+		Dir:       dir,
+		TFVersion: "1.4.5",
+		States: []string{
+			readRecording(t, "vcl_legacy", "state_1.json"),
+			readRecording(t, "vcl_legacy", "state_2.json"),
+		},
+		ShowOutputs: []string{
+			readRecording(t, "vcl_legacy", "show_1.txt"),
+			readRecording(t, "vcl_legacy", "show_2.txt"),
+		},
+	}
+	c := cli.Config{
+		ID:           "7ManTUgtlSytxeXRMPYY33",
+		ResourceName: "service",
+		Directory:    dir,
+		Workspace:    "staging",
+	}
+
+	if err := importVCL(context.Background(), tf, c, &report{}); err != nil {
+		t.Fatalf("importVCL failed: %v", err)
+	}
+
+	if tf.Calls[2] != "workspace list" || tf.Calls[3] != "workspace new staging" {
+		t.Errorf("workspace is not created after init: %q", tf.Calls)
+	}
+
+	state := readOutput(t, dir, filepath.Join("terraform.tfstate.d", "staging", "terraform.tfstate"))
+	if !strings.Contains(state, `"activate":true`) {
+		t.Errorf("state of the workspace is not edited:\n%s", state)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "terraform.tfstate")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("state of the default workspace is written")
+	}
+}
+
+func TestImportVCLModulePath(t *testing.T) {
+	dir := t.TempDir()
+	tf := &terraformtest.Runner{
+		Dir:       dir,
+		TFVersion: "1.4.5",
+		States: []string{
+			readRecording(t, "vcl_legacy", "state_1.json"),
+			readRecording(t, "vcl_legacy", "state_2.json"),
+		},
+		ShowOutputs: []string{
+			readRecording(t, "vcl_legacy", "show_1.txt"),
+			readRecording(t, "vcl_legacy", "show_2.txt"),
+		},
+	}
+	c := cli.Config{
+		ID:           "7ManTUgtlSytxeXRMPYY33",
+		ResourceName: "service",
+		Directory:    dir,
+		ModulePath:   "module.cdn",
+	}
+
+	if err := importVCL(context.Background(), tf, c, &report{}); err != nil {
+		t.Fatalf("importVCL failed: %v", err)
+	}
+
+	// The module is installed once the module block is in place
+	expectedCalls := []string{
+		"version",
+		"init",
+		"state pull",
+		"import fastly_service_vcl.service 7ManTUgtlSytxeXRMPYY33",
+		"show", "state pull",
+		"import fastly_service_acl_entries.allow_list 7ManTUgtlSytxeXRMPYY33/2Csd4ocnhkhD3J5KIP4OeK",
+		"show", "state pull",
+		"providers schema",
+		"init",
+		"state pull", "state pull", "state push",
+		"refresh",
+		"plan -json",
+	}
+	if !reflect.DeepEqual(tf.Calls, expectedCalls) {
+		t.Errorf("Calls = %q, want %q", tf.Calls, expectedCalls)
+	}
+
+	conf := readOutput(t, dir, filepath.Join("modules", "cdn", "service.tf"))
+	for _, expected := range []string{
+		`resource "fastly_service_vcl" "service"`,
+		`for a in fastly_service_vcl.service.acl`,
+		`var.httpbin_ssl_client_key`,
+	} {
+		if !strings.Contains(conf, expected) {
+			t.Errorf("modules/cdn/service.tf does not contain %q:\n%s", expected, conf)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "service.tf")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("service.tf is written to the root module")
+	}
+
+	module := readOutput(t, dir, "module_cdn.tf")
+	for _, expected := range []string{
+		`module "cdn"`,
+		`source = "./modules/cdn"`,
+		`httpbin_ssl_client_key = var.httpbin_ssl_client_key`,
+	} {
+		if !strings.Contains(module, expected) {
+			t.Errorf("module_cdn.tf does not contain %q:\n%s", expected, module)
+		}
+	}
+	for _, name := range []string{"variables.tf", filepath.Join("modules", "cdn", "variables.tf"), filepath.Join("modules", "cdn", "versions.tf")} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s is not written: %v", name, err)
+		}
+	}
+
+	state := readOutput(t, dir, "terraform.tfstate")
+	for _, expected := range []string{
+		`"module":"module.cdn","mode":"managed","type":"fastly_service_vcl"`,
+		`"module":"module.cdn","mode":"managed","type":"fastly_service_acl_entries"`,
+	} {
+		if !strings.Contains(state, expected) {
+			t.Errorf("terraform.tfstate does not contain %q:\n%s", expected, state)
+		}
+	}
+}
+
+func TestImportVCLAsModule(t *testing.T) {
+	dir := t.TempDir()
+	tf := &terraformtest.Runner{
+		Dir:       dir,
+		TFVersion: "1.4.5",
+		States: []string{
+			readRecording(t, "vcl_legacy", "state_1.json"),
+			readRecording(t, "vcl_legacy", "state_2.json"),
+		},
+		ShowOutputs: []string{
+			readRecording(t, "vcl_legacy", "show_1.txt"),
+			readRecording(t, "vcl_legacy", "show_2.txt"),
+		},
+	}
+	c := cli.Config{
+		ID:           "7ManTUgtlSytxeXRMPYY33",
+		ResourceName: "service",
+		Directory:    dir,
+		ModulePath:   "module.service",
+		AsModule:     true,
+	}
+
+	if err := importVCL(context.Background(), tf, c, &report{}); err != nil {
+		t.Fatalf("importVCL failed: %v", err)
+	}
+
+	conf := readOutput(t, dir, filepath.Join("modules", "service", "service.tf"))
+	for _, expected := range []string{
+		`name = var.service_name`,
+		`name = var.domain`,
+		`address = var.httpbin_address`,
+		`port = var.httpbin_port`,
+		`ssl_client_key = var.httpbin_ssl_client_key`,
+	} {
+		if !strings.Contains(strings.Join(strings.Fields(conf), " "), expected) {
+			t.Errorf("modules/service/service.tf does not contain %q:\n%s", expected, conf)
+		}
+	}
+	for _, unexpected := range []string{`"terraformify test"`, `"test.terraformify.me"`, `"httpbin.org"`} {
+		if strings.Contains(conf, unexpected) {
+			t.Errorf("modules/service/service.tf contains %q:\n%s", unexpected, conf)
+		}
+	}
+
+	variables := readOutput(t, dir, filepath.Join("modules", "service", "variables.tf"))
+	for _, expected := range []string{`variable "service_name"`, `variable "domain"`, `variable "httpbin_port"`, "type = number", `variable "httpbin_ssl_client_key"`} {
+		if !strings.Contains(strings.Join(strings.Fields(variables), " "), expected) {
+			t.Errorf("modules/service/variables.tf does not contain %q:\n%s", expected, variables)
+		}
+	}
+
+	outputs := readOutput(t, dir, filepath.Join("modules", "service", "outputs.tf"))
+	for _, expected := range []string{`output "service_id"`, "fastly_service_vcl.service.id", `output "service_active_version"`} {
+		if !strings.Contains(outputs, expected) {
+			t.Errorf("modules/service/outputs.tf does not contain %q:\n%s", expected, outputs)
+		}
+	}
+
+	// The module block passes the current values of the service, so the plan has nothing to change
+	main := readOutput(t, dir, "main.tf")
+	for _, expected := range []string{
+		`module "service"`,
+		`source = "./modules/service"`,
+		`service_name = "terraformify test"`,
+		`domain = "test.terraformify.me"`,
+		`httpbin_port = 443`,
+		`httpbin_ssl_client_key = var.httpbin_ssl_client_key`,
+	} {
+		if !strings.Contains(strings.Join(strings.Fields(main), " "), expected) {
+			t.Errorf("main.tf does not contain %q:\n%s", expected, main)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "module_service.tf")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("module_service.tf is written along with main.tf")
+	}
+
+	state := readOutput(t, dir, "terraform.tfstate")
+	if !strings.Contains(state, `"module":"module.service","mode":"managed","type":"fastly_service_vcl"`) {
+		t.Errorf("terraform.tfstate does not have the service in the module:\n%s", state)
+	}
+}
+
+func TestImportVCLFormatJSON(t *testing.T) {
+	dir := t.TempDir()
+	tf := &terraformtest.Runner{
+		Dir:       dir,
+		TFVersion: "1.4.5",
+		States: []string{
+			readRecording(t, "vcl_legacy", "state_1.json"),
+			readRecording(t, "vcl_legacy", "state_2.json"),
+		},
+		ShowOutputs: []string{
+			readRecording(t, "vcl_legacy", "show_1.txt"),
+			readRecording(t, "vcl_legacy", "show_2.txt"),
+		},
+	}
+	c := cli.Config{
+		ID:           "7ManTUgtlSytxeXRMPYY33",
+		ResourceName: "service",
+		Directory:    dir,
+		Format:       cli.FormatJSON,
+	}
+
+	if err := importVCL(context.Background(), tf, c, &report{}); err != nil {
+		t.Fatalf("importVCL failed: %v", err)
+	}
+
+	for _, name := range []string{"service.tf.json", "provider.tf.json", "variables.tf.json"} {
+		conf := readOutput(t, dir, name)
+		if _, diags := hcljson.Parse([]byte(conf), name); diags.HasErrors() {
+			t.Errorf("%s is not valid in the JSON syntax: %v\n%s", name, diags, conf)
+		}
+	}
+
+	conf := readOutput(t, dir, "service.tf.json")
+	for _, expected := range []string{
+		`"fastly_service_vcl": {`,
+		`"allow_list": {`,
+		`"for_each": "${{`,
+		`"${var.httpbin_ssl_client_key}"`,
+		`"${file(`,
+	} {
+		if !strings.Contains(conf, expected) {
+			t.Errorf("service.tf.json does not contain %q:\n%s", expected, conf)
+		}
+	}
+
+	tfvars := readOutput(t, dir, "terraform.tfvars.json")
+	if !strings.Contains(tfvars, `"httpbin_ssl_client_key": "`) {
+		t.Errorf("terraform.tfvars.json does not contain httpbin_ssl_client_key:\n%s", tfvars)
+	}
+
+	for _, name := range []string{"service.tf", "provider.tf", "variables.tf", "terraform.tfvars"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s is written in the native syntax", name)
+		}
+	}
+}
+
+func TestImportVCLWorkspaceConflict(t *testing.T) {
+	dir := t.TempDir()
+	state := readRecording(t, "vcl_legacy", "state_1.json")
+	if err := os.MkdirAll(filepath.Join(dir, "terraform.tfstate.d", "production"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "terraform.tfstate.d", "production", "terraform.tfstate"), []byte(state), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tf := &terraformtest.Runner{
+		Dir:        dir,
+		TFVersion:  "1.4.5",
+		Workspaces: []string{"production"},
+	}
+	c := cli.Config{
+		ID:           "7ManTUgtlSytxeXRMPYY33",
+		ResourceName: "service",
+		Directory:    dir,
+		Workspace:    "production",
+	}
+
+	err := importVCL(context.Background(), tf, c, &report{})
+	if err == nil || !strings.Contains(err.Error(), "fastly_service_vcl.service already exists") {
+		t.Fatalf("err = %v, want the resource to be reported as already managed", err)
+	}
+	for _, call := range tf.Calls {
+		if strings.HasPrefix(call, "import") {
+			t.Errorf("import was run in a workspace already managing the service: %q", tf.Calls)
+		}
+	}
+}
+
+func TestImportVCLInterrupted(t *testing.T) {
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tf := &terraformtest.Runner{
+		Dir:       dir,
+		TFVersion: "1.4.5",
+		States: []string{
+			readRecording(t, "vcl_legacy", "state_1.json"),
+			readRecording(t, "vcl_legacy", "state_2.json"),
+		},
+		ShowOutputs: []string{
+			readRecording(t, "vcl_legacy", "show_1.txt"),
+			readRecording(t, "vcl_legacy", "show_2.txt"),
+		},
+		// Ctrl-C while the ACL entries are being imported
+		Hook: func(call string) error {
+			if strings.HasPrefix(call, "import fastly_service_acl_entries") {
+				cancel()
+			}
+			return nil
+		},
+	}
+	c := cli.Config{
+		ID:           "7ManTUgtlSytxeXRMPYY33",
+		ResourceName: "service",
+		Directory:    dir,
+	}
+
+	err := importVCL(ctx, tf, c, &report{})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want %v", err, context.Canceled)
+	}
+
+	// The working directory was empty, so everything created by the run is removed, including the state
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		t.Errorf("%s is left in the working directory", e.Name())
+	}
+}
+
+func TestImportVCLRollback(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"main.tf":          "# managed by hand\n",
+		"variables.tf":     "variable \"region\" {}\n",
+		"terraform.tfvars": "region = \"us\"\n",
+		".gitignore":       "*.tfplan\n",
+	})
+	before, err := file.Snapshot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tf := &terraformtest.Runner{
+		Dir:       dir,
+		TFVersion: "1.4.5",
+		States: []string{
+			readRecording(t, "vcl_legacy", "state_1.json"),
+			readRecording(t, "vcl_legacy", "state_2.json"),
+		},
+		ShowOutputs: []string{
+			readRecording(t, "vcl_legacy", "show_1.txt"),
+			readRecording(t, "vcl_legacy", "show_2.txt"),
+		},
+		// Fail after the generated files have been committed and the state has been edited
+		Hook: func(call string) error {
+			if call == "refresh" {
+				return errors.New("Error: refresh failed")
+			}
+			return nil
+		},
+	}
+	c := cli.Config{
+		ID:           "7ManTUgtlSytxeXRMPYY33",
+		ResourceName: "service",
+		Directory:    dir,
+	}
+
+	if err := importVCL(context.Background(), tf, c, &report{}); err == nil {
+		t.Fatal("importVCL succeeded, want the refresh error")
+	}
+
+	after, err := file.Snapshot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(after, before) {
+		t.Errorf("working directory is not restored:\ngot  %q\nwant %q", after, before)
+	}
+}
+
+func TestImportVCLWorkspaceRollback(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		workspaces []string
+		expected   []string
+	}{
+		{"created", nil, []string{}},
+		{"existing", []string{"staging"}, []string{"staging"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			tf := &terraformtest.Runner{
+				Dir:        dir,
+				TFVersion:  "1.4.5",
+				Workspaces: tc.workspaces,
+				States: []string{
+					readRecording(t, "vcl_legacy", "state_1.json"),
+					readRecording(t, "vcl_legacy", "state_2.json"),
+				},
+				ShowOutputs: []string{
+					readRecording(t, "vcl_legacy", "show_1.txt"),
+					readRecording(t, "vcl_legacy", "show_2.txt"),
+				},
+				Hook: func(call string) error {
+					if call == "refresh" {
+						return errors.New("Error: refresh failed")
+					}
+					return nil
+				},
+			}
+			c := cli.Config{
+				ID:           "7ManTUgtlSytxeXRMPYY33",
+				ResourceName: "service",
+				Directory:    dir,
+				Workspace:    "staging",
+			}
+
+			if err := importVCL(context.Background(), tf, c, &report{}); err == nil {
+				t.Fatal("importVCL succeeded, want the refresh error")
+			}
+
+			// The workspace selected before is selected again, and the one created by the import is deleted
+			if tf.Workspace != "default" {
+				t.Errorf("workspace %q is left selected", tf.Workspace)
+			}
+			if !reflect.DeepEqual(tf.Workspaces, tc.expected) {
+				t.Errorf("workspaces = %q, want %q", tf.Workspaces, tc.expected)
+			}
+		})
+	}
+}
+
+func TestImportVCLStrict(t *testing.T) {
+	dir := t.TempDir()
+	tf := &terraformtest.Runner{
+		Dir:       dir,
+		TFVersion: "1.4.5",
+		States: []string{
+			readRecording(t, "vcl_legacy", "state_1.json"),
+			readRecording(t, "vcl_legacy", "state_2.json"),
+		},
+		ShowOutputs: []string{
+			readRecording(t, "vcl_legacy", "show_1.txt"),
+			readRecording(t, "vcl_legacy", "show_2.txt"),
+		},
+		VerifyPlans: []string{`{"format_version":"1.2","resource_changes":[{
+			"address":"fastly_service_vcl.service","mode":"managed","type":"fastly_service_vcl","name":"service",
+			"change":{"actions":["update"],"before":{"comment":""},"after":{"comment":"Managed by Terraform"}}}]}`},
+	}
+	c := cli.Config{
+		ID:           "7ManTUgtlSytxeXRMPYY33",
+		ResourceName: "service",
+		Directory:    dir,
+		Strict:       true,
+	}
+
+	rep := &report{}
+	if err := importVCL(context.Background(), tf, c, rep); err != nil {
+		t.Fatalf("importVCL failed: %v", err)
+	}
+	if len(rep.drift) != 1 || rep.drift[0].Attributes[0].Path != "comment" {
+		t.Errorf("drift = %+v, want the comment of the service", rep.drift)
+	}
+	if err := checkStrict(c, rep); err == nil {
+		t.Error("checkStrict succeeded despite the drift")
+	}
+
+	// The import is kept
+	if conf := readOutput(t, dir, "service.tf"); !strings.Contains(conf, `resource "fastly_service_vcl" "service"`) {
+		t.Errorf("service.tf is not kept:\n%s", conf)
+	}
+
+	c.Strict = false
+	if err := checkStrict(c, rep); err != nil {
+		t.Errorf("checkStrict failed without the strict flag: %v", err)
+	}
+}
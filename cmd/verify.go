@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/hrmsk66/terraformify/pkg/cli"
+	"github.com/hrmsk66/terraformify/pkg/terraform"
+)
+
+// verifyImport checks that "terraform plan" shows no changes right after the import, and prints the changes if it does
+func verifyImport(ctx context.Context, tf terraform.Runner) ([]terraform.ResourceDrift, error) {
+	drifts, err := terraform.CheckDrift(ctx, tf)
+	if err != nil {
+		return nil, err
+	}
+	if len(drifts) == 0 {
+		log.Print("[INFO] terraform plan shows no changes. The configuration matches the service")
+		return nil, nil
+	}
+
+	printDrift(os.Stderr, drifts)
+	return drifts, nil
+}
+
+// printDrift prints the changes to each resource with the markers used by "terraform plan"
+func printDrift(w io.Writer, drifts []terraform.ResourceDrift) {
+	fmt.Fprintln(w)
+	cli.BoldYellow(w, fmt.Sprintf(`Drift detected: "terraform plan" would change %d resource(s) right after the import`, len(drifts)))
+	fmt.Fprintln(w, "The generated configuration does not match the service in the following:")
+	for _, d := range drifts {
+		marker := "~"
+		switch d.Action {
+		case "create":
+			marker = "+"
+		case "delete":
+			marker = "-"
+		case "replace":
+			marker = "-/+"
+		}
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "  %s %s (%s)\n", marker, d.Address, d.Action)
+		for _, attr := range d.Attributes {
+			fmt.Fprintf(w, "      %s: %s -> %s\n", attr.Path, attr.Before, attr.After)
+		}
+	}
+}
+
+// checkStrict fails a run with the strict flag if "terraform plan" showed changes after the import.
+// The import itself is kept, so that the changes can be looked into.
+func checkStrict(c cli.Config, rep *report) error {
+	if !c.Strict || len(rep.drift) == 0 {
+		return nil
+	}
+	return fmt.Errorf("strict: terraform plan shows changes to %d resource(s) after the import", len(rep.drift))
+}
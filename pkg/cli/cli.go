@@ -2,12 +2,13 @@ package cli
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io"
-	"log"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/hashicorp/logutils"
@@ -19,15 +20,39 @@ type Config struct {
 	WafID             string
 	Package           string
 	Directory         string
+	TFBinary          string
+	Workspace         string
+	ModulePath        string
+	AsModule          bool
+	Format            string
+	DynamicBlocks     bool
+	DynamicBlocksData string
+	Timeout           time.Duration
+	KeepSnapshots     int
 	Version           int
 	Interactive       bool
 	ManageAll         bool
 	ForceDestroy      bool
 	SkipEditState     bool
+	WriteImports      bool
+	DryRun            bool
+	Strict            bool
 	TestMode          bool
 	ReplaceDictionary bool
 }
 
+// Formats of the generated configuration
+const (
+	FormatHCL  = "hcl"
+	FormatJSON = "json"
+)
+
+// Formats of the data files of the dynamic blocks
+const (
+	DataJSON = "json"
+	DataYAML = "yaml"
+)
+
 var Bold = color.New(color.Bold).SprintFunc()
 var BoldGreen = color.New(color.Bold, color.FgGreen).FprintlnFunc()
 var BoldGreenf = color.New(color.Bold, color.FgGreen).FprintfFunc()
@@ -47,32 +72,30 @@ func CreateLogFilter() io.Writer {
 	return filter
 }
 
-func YesNo(message string) bool {
-	reader := bufio.NewReader(os.Stdin)
-
+// YesNo asks the question until it is answered with yes or no.
+// It returns an error if stdin is closed or the context is canceled, such as by Ctrl-C.
+func YesNo(ctx context.Context, message string) (bool, error) {
 	for {
 		BoldYellowf(os.Stderr, "%s [y/n]: ", message)
 
-		response, err := reader.ReadString('\n')
+		response, err := readLine(ctx)
 		if err != nil {
-			log.Fatal(err)
+			return false, err
 		}
 
 		response = strings.ToLower(strings.TrimSpace(response))
 
 		if response == "y" || response == "yes" {
-			return true
+			return true, nil
 		} else if response == "n" || response == "no" {
-			return false
+			return false, nil
 		}
 	}
 }
 
 // DataStoreType prompts the user to select a number for a Data Store type and returns the corresponding TF resource name.
 // 1 for Config Store, 2 for Secret Store, and 3 for KV Store. It keeps prompting if the input is invalid.
-func AskDataStoreType(resource string) string {
-	reader := bufio.NewReader(os.Stdin)
-
+func AskDataStoreType(ctx context.Context, resource string) (string, error) {
 	for {
 		BoldYellowf(os.Stderr, `"%s" - Select Data Store Type:
 	1: Config Store
@@ -80,9 +103,9 @@ func AskDataStoreType(resource string) string {
 	3: KV Store
 	Enter number: `, resource)
 
-		response, err := reader.ReadString('\n')
+		response, err := readLine(ctx)
 		if err != nil {
-			log.Fatal(err)
+			return "", err
 		}
 
 		response = strings.TrimSpace(response)
@@ -90,13 +113,41 @@ func AskDataStoreType(resource string) string {
 
 		switch choice {
 		case 1:
-			return "fastly_configstore"
+			return "fastly_configstore", nil
 		case 2:
-			return "fastly_secretstore"
+			return "fastly_secretstore", nil
 		case 3:
-			return "fastly_kvstore"
+			return "fastly_kvstore", nil
 		default:
 			fmt.Fprintf(os.Stderr, "\n")
 		}
 	}
 }
+
+// stdin is shared by the prompts so that input buffered by one prompt is not lost to the next
+var stdin = bufio.NewReader(os.Stdin)
+
+// readLine reads a line from stdin. A read cannot be interrupted, so it is left running
+// in the background if the context is canceled first.
+func readLine(ctx context.Context) (string, error) {
+	type result struct {
+		line string
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		line, err := stdin.ReadString('\n')
+		ch <- result{line, err}
+	}()
+
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			return "", fmt.Errorf("cli: failed to read the answer: %w", r.err)
+		}
+		return r.line, nil
+	case <-ctx.Done():
+		fmt.Fprintln(os.Stderr)
+		return "", ctx.Err()
+	}
+}
@@ -0,0 +1,55 @@
+package file_test
+
+import (
+	"testing"
+
+	"github.com/hrmsk66/terraformify/pkg/file"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	cases := []struct {
+		name          string
+		before, after string
+		want          string
+	}{
+		{
+			name:   "same",
+			after:  "a\n",
+			before: "a\n",
+			want:   "",
+		},
+		{
+			name:  "create",
+			after: "a\nb\n",
+			want:  "--- /dev/null\n+++ b/f\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name:   "append",
+			before: "a\n",
+			after:  "a\nb\n",
+			want:   "--- a/f\n+++ b/f\n@@ -1 +1,2 @@\n a\n+b\n",
+		},
+		{
+			name:   "separate hunks",
+			before: "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			after:  "0\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n13\n",
+			want: "--- a/f\n+++ b/f\n" +
+				"@@ -1,4 +1,4 @@\n-1\n+0\n 2\n 3\n 4\n" +
+				"@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+13\n",
+		},
+		{
+			name:   "merged hunk",
+			before: "1\n2\n3\n4\n5\n6\n7\n8\n",
+			after:  "0\n2\n3\n4\n5\n6\n7\n9\n",
+			want:   "--- a/f\n+++ b/f\n@@ -1,8 +1,8 @@\n-1\n+0\n 2\n 3\n 4\n 5\n 6\n 7\n-8\n+9\n",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := file.UnifiedDiff("f", tc.before, tc.after); got != tc.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tc.want)
+			}
+		})
+	}
+}
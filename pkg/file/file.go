@@ -1,6 +1,7 @@
 package file
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -17,17 +18,22 @@ import (
 //go:embed static/provider.tf
 var requiredProvider []byte
 
+//go:embed static/provider.tf.json
+var requiredProviderJSON []byte
+
 //go:embed static/.gitignore
 var gitignore []byte
 
-func CreateInitTerraformFiles(workingDir string) (*os.File, error) {
+// CreateInitTerraformFiles creates provider.tf and temp*.tf, which Terraform needs to find in the working directory
+// before the import. Unlike the other files, they are written in place.
+func (tx *Transaction) CreateInitTerraformFiles() (*os.File, error) {
 	// Create provider.tf
-	if err := writeProviderTF(workingDir); err != nil {
+	if err := tx.writeProviderTF(); err != nil {
 		return nil, err
 	}
 
 	// Create temp*.tf with empty service resource blocks
-	tempf, err := os.CreateTemp(workingDir, "temp*.tf")
+	tempf, err := os.CreateTemp(tx.workingDir, "temp*.tf")
 	if err != nil {
 		return nil, err
 	}
@@ -35,92 +41,230 @@ func CreateInitTerraformFiles(workingDir string) (*os.File, error) {
 	return tempf, nil
 }
 
-func WriteTF(workingDir, resourceName string, content []byte) error {
+// ModuleDir returns the directory of the module, relative to the working directory
+func ModuleDir(moduleName string) string {
+	return filepath.Join("modules", moduleName)
+}
+
+// SetModuleDir makes the configuration of the service, such as the TF file and the VCL files, written to the module directory.
+// The files shared by the whole configuration, such as terraform.tfvars, are still written to the working directory.
+func (tx *Transaction) SetModuleDir(dir string) {
+	tx.moduleDir = dir
+}
+
+// UseJSONSyntax makes the configuration written in the JSON syntax. The files are named with ".json" appended,
+// such as service.tf.json and terraform.tfvars.json, and the content given to the writers must be in the JSON syntax.
+// temp*.tf, which is removed after the import, is still written in the native syntax.
+func (tx *Transaction) UseJSONSyntax() {
+	tx.jsonSyntax = true
+}
+
+// fileName returns the name of the file in the syntax the configuration is written in
+func (tx *Transaction) fileName(name string) string {
+	if tx.jsonSyntax {
+		return name + ".json"
+	}
+	return name
+}
+
+func (tx *Transaction) WriteTF(resourceName string, content []byte) error {
 	filename := fmt.Sprintf("%s.tf", resourceName)
-	return writeFile(workingDir, filename, content)
+	return tx.writeFile(tx.fileName(filename), content, tx.moduleDir)
+}
+
+// WriteModuleTF writes the module block that calls the module to the working directory
+func (tx *Transaction) WriteModuleTF(moduleName string, content []byte) error {
+	filename := fmt.Sprintf("module_%s.tf", moduleName)
+	return tx.writeFile(tx.fileName(filename), content)
+}
+
+// WriteMainTF writes the module block that calls the module written with --as-module to main.tf in the working directory
+func (tx *Transaction) WriteMainTF(content []byte) error {
+	return tx.writeFile(tx.fileName("main.tf"), content)
+}
+
+// WriteModuleOutputsTF writes the outputs of the module
+func (tx *Transaction) WriteModuleOutputsTF(content []byte) error {
+	return tx.writeFile(tx.fileName("outputs.tf"), content, tx.moduleDir)
+}
+
+// WriteModuleVariablesTF writes the variables of the module, which the module block passes on to it
+func (tx *Transaction) WriteModuleVariablesTF(content []byte) error {
+	return tx.writeFile(tx.fileName("variables.tf"), content, tx.moduleDir)
 }
 
-func WriteTFState(workingDir string, content []byte) error {
-	return writeFile(workingDir, "terraform.tfstate", content)
+// WriteModuleVersionsTF writes the required providers of the module, as the providers outside the hashicorp namespace
+// have to be declared in each module that uses them
+func (tx *Transaction) WriteModuleVersionsTF() error {
+	return tx.writeFile(tx.fileName("versions.tf"), tx.requiredProvider(), tx.moduleDir)
 }
 
-func writeProviderTF(workingDir string) error {
-	lockFile := filepath.Join(workingDir, ".terraform.lock.hcl")
+func (tx *Transaction) requiredProvider() []byte {
+	if tx.jsonSyntax {
+		return requiredProviderJSON
+	}
+	return requiredProvider
+}
+
+func (tx *Transaction) writeProviderTF() error {
+	lockFile := filepath.Join(tx.workingDir, ".terraform.lock.hcl")
 	_, err := os.Stat(lockFile)
-	if errors.Is(err, os.ErrNotExist) {
-		return writeFile(workingDir, "provider.tf", requiredProvider)
+	if err == nil {
+		log.Printf("[INFO] file: %s exists. skip creating provider.tf", lockFile)
+		return nil
 	}
-	if err != nil {
+	if !errors.Is(err, os.ErrNotExist) {
 		return err
 	}
 
-	log.Printf("[INFO] file: %s exists. skip creating provider.tf", lockFile)
-	return nil
+	// The required providers may have been declared in either syntax
+	for _, name := range []string{"provider.tf", "provider.tf.json"} {
+		file := filepath.Join(tx.workingDir, name)
+		_, err = os.Stat(file)
+		if err == nil {
+			log.Printf("[INFO] file: %s exists. skip creating it", file)
+			return nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	file := filepath.Join(tx.workingDir, tx.fileName("provider.tf"))
+	log.Printf("[INFO] file: creating %s", file)
+	return write(file, tx.requiredProvider(), os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
 }
 
-func WriteVariablesTF(workingDir string, content []byte) error {
-	return writeFile(workingDir, "variables.tf", content)
+func (tx *Transaction) WriteVariablesTF(content []byte) error {
+	return tx.writeFile(tx.fileName("variables.tf"), content)
 }
 
-func WriteTFVars(workingDir string, content []byte) error {
-	return writeFile(workingDir, "terraform.tfvars", content)
+func (tx *Transaction) WriteTFVars(content []byte) error {
+	return tx.writeFile(tx.fileName("terraform.tfvars"), content)
 }
 
-func WriteGitIgnore(workingDir string) error {
-	return writeFile(workingDir, ".gitignore", gitignore)
+// WriteEnvironmentTFVars writes the values of the variables for the environment to <environment>.tfvars,
+// which is given to Terraform with -var-file
+func (tx *Transaction) WriteEnvironmentTFVars(environment string, content []byte) error {
+	return tx.writeFile(tx.fileName(environment+".tfvars"), content)
 }
 
-func WriteContent(workingDir, resourceName, fileName string, content []byte) error {
-	return writeFile(workingDir, fileName, content, "content", resourceName)
+// WriteProviderTF writes provider.tf unless the required providers are declared already. Unlike CreateInitTerraformFiles,
+// it stages the file, for a configuration written without running the import in the working directory.
+func (tx *Transaction) WriteProviderTF() error {
+	lockFile := filepath.Join(tx.workingDir, ".terraform.lock.hcl")
+	if _, err := os.Stat(lockFile); err == nil {
+		log.Printf("[INFO] file: %s exists. skip creating provider.tf", lockFile)
+		return nil
+	}
+	return tx.writeFile(tx.fileName("provider.tf"), tx.requiredProvider())
 }
 
-func WriteVCL(workingDir, resourceName, fileName string, content []byte) error {
-	return writeFile(workingDir, fileName, content, "vcl", resourceName)
+func (tx *Transaction) WriteImportsTF(content []byte) error {
+	return tx.writeFile(tx.fileName("imports.tf"), content)
 }
 
-func WriteLogFormat(workingDir, resourceName, fileName string, content []byte) error {
-	return writeFile(workingDir, fileName, content, "logformat", resourceName)
+func (tx *Transaction) WriteGitIgnore() error {
+	return tx.writeFile(".gitignore", gitignore)
 }
 
-func writeFile(workingDir, name string, content []byte, dirs ...string) error {
-	for _, dir := range dirs {
-		d := filepath.Join(workingDir, dir)
-		if _, err := os.Stat(d); errors.Is(err, os.ErrNotExist) {
-			err := os.Mkdir(d, 0755)
-			if err != nil {
-				return err
-			}
-		}
+func (tx *Transaction) WriteContent(resourceName, fileName string, content []byte) error {
+	return tx.writeFile(fileName, content, tx.moduleDir, "content", resourceName)
+}
+
+func (tx *Transaction) WriteVCL(resourceName, fileName string, content []byte) error {
+	return tx.writeFile(fileName, content, tx.moduleDir, "vcl", resourceName)
+}
 
-		workingDir = filepath.Join(workingDir, dir)
+func (tx *Transaction) WriteLogFormat(resourceName, fileName string, content []byte) error {
+	return tx.writeFile(fileName, content, tx.moduleDir, "logformat", resourceName)
+}
+
+func (tx *Transaction) WriteData(resourceName, fileName string, content []byte) error {
+	return tx.writeFile(fileName, content, tx.moduleDir, "data", resourceName)
+}
+
+// writeFile stages the file. Whether it is created, appended to or skipped is decided by the file in the working directory.
+func (tx *Transaction) writeFile(name string, content []byte, dirs ...string) error {
+	rel := filepath.Join(append(dirs, name)...)
+	file := filepath.Join(tx.workingDir, rel)
+	staged := filepath.Join(tx.scratch, stagedDir, rel)
+
+	if err := os.MkdirAll(filepath.Dir(staged), 0755); err != nil {
+		return err
 	}
 
-	file := filepath.Join(workingDir, name)
 	_, err := os.Stat(file)
+	if errors.Is(err, os.ErrNotExist) && isSkippable(name) {
+		// The required providers may have been declared in the other syntax
+		_, err = os.Stat(otherSyntax(file))
+	}
 	if errors.Is(err, os.ErrNotExist) {
+		if tx.isStaged(rel) {
+			if isAppendable(name) {
+				return appendContent(staged, content)
+			}
+			return fmt.Errorf("aborted creating %s as it already exists", file)
+		}
 		log.Printf("[INFO] file: creating %s", file)
-		return write(file, content, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+		tx.staged = append(tx.staged, rel)
+		return write(staged, content, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
 	}
 	if err != nil {
 		return err
 	}
 	// Skip
-	if name == "provider.tf" || name == ".gitignore" {
+	if isSkippable(name) {
 		log.Printf("[INFO] file: %s exists. skip creating it", file)
 		return nil
 	}
 	// Append
-	if name == "variables.tf" || name == "terraform.tfvars" {
-		log.Printf("[INFO] file: %s exists. appending content", file)
-		return write(file, content, os.O_WRONLY|os.O_APPEND)
+	if isAppendable(name) {
+		if !tx.isStaged(rel) {
+			log.Printf("[INFO] file: %s exists. appending content", file)
+			if err := copyFile(file, staged); err != nil {
+				return err
+			}
+			tx.staged = append(tx.staged, rel)
+		}
+		return appendContent(staged, content)
 	}
-	// Overwrite
-	if name == "terraform.tfstate" {
-		log.Print("[INFO] file: writing terraform.tfstate")
-		return write(file, content, os.O_WRONLY|os.O_TRUNC)
+	return fmt.Errorf("aborted creating %s as it already exists", file)
+}
+
+func isSkippable(name string) bool {
+	name = strings.TrimSuffix(name, ".json")
+	return name == "provider.tf" || name == "versions.tf" || name == ".gitignore"
+}
+
+func isAppendable(name string) bool {
+	name = strings.TrimSuffix(name, ".json")
+	return name == "variables.tf" || name == "terraform.tfvars" || name == "imports.tf" || name == "main.tf" || name == "outputs.tf"
+}
+
+// otherSyntax returns the path to the file in the other syntax, such as versions.tf.json for versions.tf
+func otherSyntax(file string) string {
+	if strings.HasSuffix(file, ".json") {
+		return strings.TrimSuffix(file, ".json")
 	}
+	return file + ".json"
+}
 
-	return fmt.Errorf("aborted creating %s as it already exists", file)
+// appendContent appends the content to the staged file. A file in the JSON syntax holds a single object,
+// so the content is merged into it instead.
+func appendContent(staged string, content []byte) error {
+	if !strings.HasSuffix(staged, ".json") {
+		return write(staged, content, os.O_WRONLY|os.O_APPEND)
+	}
+
+	existing, err := os.ReadFile(staged)
+	if err != nil {
+		return err
+	}
+	merged, err := mergeJSON(existing, content)
+	if err != nil {
+		return fmt.Errorf("failed to append to %s: %w", staged, err)
+	}
+	return write(staged, merged, os.O_WRONLY|os.O_TRUNC)
 }
 
 func write(file string, content []byte, flag int) error {
@@ -152,7 +296,12 @@ func CheckFile(workingDir, resourceName string) error {
 	fileName := fmt.Sprintf("%s.tf", resourceName)
 	file := filepath.Join(workingDir, fileName)
 
+	// The resources in the file conflict with the service whichever syntax the file is written in
 	_, err := os.Stat(file)
+	if errors.Is(err, os.ErrNotExist) {
+		file = otherSyntax(file)
+		_, err = os.Stat(file)
+	}
 	if errors.Is(err, os.ErrNotExist) {
 		return nil
 	}
@@ -163,7 +312,7 @@ func CheckFile(workingDir, resourceName string) error {
 	return fmt.Errorf("aborted creating a TF config file named %#v as it already exists. try another TF resource name using the -n option (the file is named after the TF resource name and it defaults to service.tf)", file)
 }
 
-func CheckDir(workingDir string, autoYes bool) (err error) {
+func CheckDir(ctx context.Context, workingDir string, autoYes bool) (err error) {
 	info, err := os.Stat(workingDir)
 	if err != nil {
 		return err
@@ -190,10 +339,17 @@ func CheckDir(workingDir string, autoYes bool) (err error) {
 
 	msg := `WARNING: Working Directory Not Empty
    The working directory is not empty.
-   If the import fails, the files in the directory may be left in an inconsistent state.
-   Please ensure that you back up the directory before proceeding.
+   The generated files are added to it, and variables.tf, terraform.tfvars and the state are modified.
+   If the import fails, the directory is restored to how it was before the run.
    Do you want to continue?`
-	if autoYes || cli.YesNo(msg) {
+	if autoYes {
+		return nil
+	}
+	yes, err := cli.YesNo(ctx, msg)
+	if err != nil {
+		return err
+	}
+	if yes {
 		return nil
 	}
 	return errors.New("working directory is not empty")
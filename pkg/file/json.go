@@ -0,0 +1,149 @@
+package file
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// mergeJSON merges the JSON object in content into the one in existing, which is how the files in the JSON syntax,
+// such as variables.tf.json, are appended to. The objects are merged by key, keeping the order of the keys,
+// and the arrays, such as the import blocks, are concatenated. The other values in content replace the existing ones.
+func mergeJSON(existing, content []byte) ([]byte, error) {
+	dst, err := decodeJSONObject(existing)
+	if err != nil {
+		return nil, err
+	}
+	src, err := decodeJSONObject(content)
+	if err != nil {
+		return nil, err
+	}
+	dst.merge(src)
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(dst); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// jsonObject is a JSON object that keeps the order of its keys
+type jsonObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (o *jsonObject) merge(src *jsonObject) {
+	for _, key := range src.keys {
+		v, ok := o.values[key]
+		if !ok {
+			o.keys = append(o.keys, key)
+			o.values[key] = src.values[key]
+			continue
+		}
+
+		switch sv := src.values[key].(type) {
+		case *jsonObject:
+			if dv, ok := v.(*jsonObject); ok {
+				dv.merge(sv)
+				continue
+			}
+		case []interface{}:
+			if dv, ok := v.([]interface{}); ok {
+				o.values[key] = append(dv, sv...)
+				continue
+			}
+		}
+		o.values[key] = src.values[key]
+	}
+}
+
+func (o *jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err := encodeJSON(&buf, key); err != nil {
+			return nil, err
+		}
+		buf.WriteByte(':')
+		if err := encodeJSON(&buf, o.values[key]); err != nil {
+			return nil, err
+		}
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func encodeJSON(buf *bytes.Buffer, v interface{}) error {
+	enc := json.NewEncoder(buf)
+	// json.Marshal would escape <, > and & in the strings, such as VCL conditions
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	// Encode ends the value with a newline
+	buf.Truncate(buf.Len() - 1)
+	return nil
+}
+
+func decodeJSONObject(data []byte) (*jsonObject, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	v, err := decodeJSONValue(dec)
+	if err != nil {
+		return nil, fmt.Errorf("file: failed to read JSON: %w", err)
+	}
+	obj, ok := v.(*jsonObject)
+	if !ok {
+		return nil, fmt.Errorf("file: failed to read JSON: not an object")
+	}
+	return obj, nil
+}
+
+func decodeJSONValue(dec *json.Decoder) (interface{}, error) {
+	t, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t {
+	case json.Delim('{'):
+		obj := &jsonObject{values: map[string]interface{}{}}
+		for dec.More() {
+			t, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			key := t.(string)
+			v, err := decodeJSONValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := obj.values[key]; !ok {
+				obj.keys = append(obj.keys, key)
+			}
+			obj.values[key] = v
+		}
+		// Read the closing brace
+		_, err := dec.Token()
+		return obj, err
+	case json.Delim('['):
+		list := []interface{}{}
+		for dec.More() {
+			v, err := decodeJSONValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		_, err := dec.Token()
+		return list, err
+	}
+	return t, nil
+}
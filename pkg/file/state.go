@@ -0,0 +1,237 @@
+package file
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hrmsk66/terraformify/pkg/tfstate"
+)
+
+// stateDir holds the files kept by the tool across runs. Rollback leaves it in place,
+// so that the snapshot taken before a failed run is still there to restore.
+const stateDir = ".terraformify"
+
+// snapshotIDFormat is the layout of the time the snapshot IDs start with. The time is written down to the microsecond,
+// so that the runs in the same second do not name their snapshots the same. time.Parse reads the fraction of the second
+// with or without it in the layout, so the IDs written down to the second are still read.
+const snapshotIDFormat = "20060102T150405.000000Z"
+
+// StateSnapshot is a copy of the state saved before a run that modifies it
+type StateSnapshot struct {
+	// ID names the snapshot, such as 20261018T120000.123456Z-12 for the state of serial 12 saved at that time
+	ID        string
+	Saved     time.Time
+	Serial    int64
+	Lineage   string
+	Resources int
+	// Version is the version of terraformify that saved the snapshot and then edited the state,
+	// or empty for the snapshots saved before the version was recorded
+	Version string
+}
+
+// snapshotMeta is saved next to each snapshot as <ID>.json. The state itself is saved as it was, so that it can be
+// pushed back as it is, and what the tool records about the run goes here instead.
+type snapshotMeta struct {
+	Version string `json:"version"`
+}
+
+// StateSnapshotDir returns the directory the snapshots of the workspace are saved to, relative to the working directory
+func StateSnapshotDir(workspace string) string {
+	return filepath.Join(stateDir, "snapshots", workspace)
+}
+
+// SaveStateSnapshot saves a copy of the state of the workspace, along with the version of the tool about to edit it,
+// and removes the oldest snapshots so that no more than keep are left. It returns the ID of the snapshot.
+func SaveStateSnapshot(workingDir, workspace string, s *tfstate.TFState, keep int, version string) (string, error) {
+	if keep < 1 {
+		return "", fmt.Errorf("file: the number of snapshots to keep must be at least 1, got %d", keep)
+	}
+
+	dir := filepath.Join(workingDir, StateSnapshotDir(workspace))
+	// The snapshots hold sensitive values, so they are readable only by the owner and ignored by git
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	if err := write(filepath.Join(workingDir, stateDir, ".gitignore"), []byte("*\n"), os.O_WRONLY|os.O_CREATE|os.O_TRUNC); err != nil {
+		return "", err
+	}
+
+	// A snapshot is never overwritten. The time is taken again if another run has just saved one of the same ID.
+	var id string
+	var f *os.File
+	var err error
+	for {
+		id = fmt.Sprintf("%s-%d", time.Now().UTC().Format(snapshotIDFormat), s.Serial)
+		f, err = os.OpenFile(filepath.Join(dir, id+".tfstate"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if !errors.Is(err, os.ErrExist) {
+			break
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(s.Bytes())
+	if err1 := f.Close(); err1 != nil && err == nil {
+		err = err1
+	}
+	if err != nil {
+		return "", err
+	}
+
+	meta, err := json.Marshal(snapshotMeta{Version: version})
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(dir, id+".json"), meta, 0600); err != nil {
+		return "", err
+	}
+
+	ids, err := snapshotIDs(dir)
+	if err != nil {
+		return "", err
+	}
+	for len(ids) > keep {
+		log.Printf("[INFO] file: removing the state snapshot %s", ids[0])
+		if err := os.Remove(filepath.Join(dir, ids[0]+".tfstate")); err != nil {
+			return "", err
+		}
+		if err := os.Remove(filepath.Join(dir, ids[0]+".json")); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+		ids = ids[1:]
+	}
+	return id, nil
+}
+
+// StateSnapshotWorkspaces lists the workspaces that have snapshots saved
+func StateSnapshotWorkspaces(workingDir string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(workingDir, stateDir, "snapshots"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var workspaces []string
+	for _, e := range entries {
+		if e.IsDir() {
+			workspaces = append(workspaces, e.Name())
+		}
+	}
+	return workspaces, nil
+}
+
+// StateSnapshots lists the snapshots of the workspace, the newest first
+func StateSnapshots(workingDir, workspace string) ([]StateSnapshot, error) {
+	ids, err := snapshotIDs(filepath.Join(workingDir, StateSnapshotDir(workspace)))
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := make([]StateSnapshot, 0, len(ids))
+	for i := len(ids) - 1; i >= 0; i-- {
+		s, err := LoadStateSnapshot(workingDir, workspace, ids[i])
+		if err != nil {
+			return nil, err
+		}
+		t, _ := splitSnapshotID(ids[i])
+		saved, err := time.Parse(snapshotIDFormat, t)
+		if err != nil {
+			return nil, fmt.Errorf("file: invalid snapshot ID %s: %w", ids[i], err)
+		}
+		meta, err := loadSnapshotMeta(workingDir, workspace, ids[i])
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, StateSnapshot{
+			ID:        ids[i],
+			Saved:     saved,
+			Serial:    s.Serial,
+			Lineage:   s.Lineage,
+			Resources: len(s.Resources),
+			Version:   meta.Version,
+		})
+	}
+	return snapshots, nil
+}
+
+// LoadStateSnapshot reads the snapshot of the ID from the snapshots of the workspace
+func LoadStateSnapshot(workingDir, workspace, id string) (*tfstate.TFState, error) {
+	if id == "" || strings.ContainsAny(id, `/\`) {
+		return nil, fmt.Errorf("file: invalid snapshot ID %q", id)
+	}
+	b, err := os.ReadFile(filepath.Join(workingDir, StateSnapshotDir(workspace), id+".tfstate"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf(`file: no snapshot %s found for workspace %q. run "terraformify state history" to list the snapshots`, id, workspace)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s, err := tfstate.Parse(b)
+	if err != nil {
+		return nil, fmt.Errorf("file: failed to read the snapshot %s: %w", id, err)
+	}
+	return s, nil
+}
+
+// loadSnapshotMeta reads what is recorded about the snapshot. The snapshots saved before it was recorded have none.
+func loadSnapshotMeta(workingDir, workspace, id string) (snapshotMeta, error) {
+	var meta snapshotMeta
+	b, err := os.ReadFile(filepath.Join(workingDir, StateSnapshotDir(workspace), id+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return meta, nil
+	}
+	if err != nil {
+		return meta, err
+	}
+	if err := json.Unmarshal(b, &meta); err != nil {
+		return meta, fmt.Errorf("file: failed to read the metadata of the snapshot %s: %w", id, err)
+	}
+	return meta, nil
+}
+
+// snapshotIDs returns the IDs of the snapshots in the directory, the oldest first
+func snapshotIDs(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".tfstate") {
+			ids = append(ids, strings.TrimSuffix(e.Name(), ".tfstate"))
+		}
+	}
+	// The times are compared as parsed, as the IDs saved before are written down to the second.
+	// Snapshots taken at the same time are ordered by their serial.
+	sort.Slice(ids, func(i, j int) bool {
+		ti, si := splitSnapshotID(ids[i])
+		tj, sj := splitSnapshotID(ids[j])
+		pi, _ := time.Parse(snapshotIDFormat, ti)
+		pj, _ := time.Parse(snapshotIDFormat, tj)
+		if !pi.Equal(pj) {
+			return pi.Before(pj)
+		}
+		return si < sj
+	})
+	return ids, nil
+}
+
+func splitSnapshotID(id string) (string, int64) {
+	t, serial, _ := strings.Cut(id, "-")
+	n, _ := strconv.ParseInt(serial, 10, 64)
+	return t, n
+}
@@ -0,0 +1,101 @@
+package file_test
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/hrmsk66/terraformify/pkg/file"
+	"github.com/hrmsk66/terraformify/pkg/tfstate"
+)
+
+func TestStateSnapshots(t *testing.T) {
+	b, err := os.ReadFile(filepath.Join("..", "..", "testdata", "tfstate", "state_v4.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := tfstate.Parse(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	tx, err := file.Begin(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the two latest are kept. The snapshots of the same serial saved in a row are not saved over each other.
+	var ids []string
+	for _, serial := range []int64{10, 10, 11} {
+		s.Serial = serial
+		id, err := file.SaveStateSnapshot(dir, "default", s, 2, "v1.2."+strconv.FormatInt(serial, 10))
+		if err != nil {
+			t.Fatalf("SaveStateSnapshot failed: %v", err)
+		}
+		ids = append(ids, id)
+	}
+
+	if ids[0] == ids[1] {
+		t.Fatalf("snapshots saved in a row have the same ID %s", ids[0])
+	}
+
+	// The snapshots are left in place when the run they were taken for fails
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshots, err := file.StateSnapshots(dir, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 || snapshots[0].ID != ids[2] || snapshots[1].ID != ids[1] {
+		t.Fatalf("snapshots = %+v, want %s and %s", snapshots, ids[2], ids[1])
+	}
+	if snapshots[0].Serial != 11 || snapshots[0].Lineage != s.Lineage || snapshots[0].Resources != 4 || snapshots[0].Version != "v1.2.11" {
+		t.Errorf("snapshot = %+v", snapshots[0])
+	}
+
+	// The metadata is removed along with the snapshot
+	snapshotDir := filepath.Join(dir, file.StateSnapshotDir("default"))
+	if _, err := os.Stat(filepath.Join(snapshotDir, ids[0]+".json")); !os.IsNotExist(err) {
+		t.Errorf("the metadata of the removed snapshot %s is left: %v", ids[0], err)
+	}
+
+	// The snapshots saved before the version was recorded have none
+	if err := os.Remove(filepath.Join(snapshotDir, ids[1]+".json")); err != nil {
+		t.Fatal(err)
+	}
+	if snapshots, err = file.StateSnapshots(dir, "default"); err != nil {
+		t.Fatal(err)
+	}
+	if snapshots[1].Version != "" {
+		t.Errorf("version = %q, want none", snapshots[1].Version)
+	}
+
+	loaded, err := file.LoadStateSnapshot(dir, "default", ids[1])
+	if err != nil {
+		t.Fatalf("LoadStateSnapshot failed: %v", err)
+	}
+	if loaded.Serial != 10 {
+		t.Errorf("serial = %d, want 10", loaded.Serial)
+	}
+
+	for _, id := range []string{ids[0], "../default/" + ids[1], ""} {
+		if _, err := file.LoadStateSnapshot(dir, "default", id); err == nil {
+			t.Errorf("LoadStateSnapshot(%q) succeeded", id)
+		}
+	}
+	if _, err := file.LoadStateSnapshot(dir, "staging", ids[1]); err == nil {
+		t.Error("LoadStateSnapshot succeeded for a snapshot of another workspace")
+	}
+
+	info, err := os.Stat(filepath.Join(dir, file.StateSnapshotDir("default"), ids[1]+".tfstate"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("permission = %o, want 600", perm)
+	}
+}
@@ -0,0 +1,372 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+const (
+	stagedDir = "staged"
+	backupDir = "backup"
+)
+
+// terraformFiles are written by Terraform itself during the import, rather than through the transaction.
+// They are backed up when the transaction begins so that Rollback can put them back. Only the selected workspace,
+// the backend configuration and the modules are backed up from .terraform: the providers installed in it can be
+// large, and "terraform init" installs the versions in the restored lock file again.
+var terraformFiles = []string{
+	filepath.Join(".terraform", "environment"),
+	filepath.Join(".terraform", "terraform.tfstate"),
+	filepath.Join(".terraform", "modules"),
+	".terraform.lock.hcl",
+	"terraform.tfstate",
+	"terraform.tfstate.backup",
+	"terraform.tfstate.d",
+}
+
+// Transaction stages the files generated by the import in a scratch directory and moves them into the working
+// directory at once with Commit. Until Close is called, Rollback restores the working directory to how it was
+// when the transaction began, putting back every file overwritten or appended to and removing everything created.
+type Transaction struct {
+	workingDir string
+	// scratch holds the staged files and the backups. It is created in the working directory
+	// so that the files can be moved into place by renaming them.
+	scratch string
+	// existed records the entries at the top of the working directory when the transaction began
+	existed map[string]bool
+	// backedUp records the terraformFiles that existed when the transaction began
+	backedUp map[string]bool
+	// staged lists the staged files in the order they were written, relative to the working directory
+	staged []string
+	// removed lists the files and directories to be removed by Commit, relative to the working directory
+	removed []string
+	// committed lists the files moved into the working directory by Commit
+	committed []committedFile
+	// createdDirs lists the directories created by Commit, parents first
+	createdDirs []string
+	// moduleDir is the directory the configuration of the service is written to, relative to the working directory.
+	// Empty means the working directory itself.
+	moduleDir string
+	// jsonSyntax makes the configuration written in the JSON syntax
+	jsonSyntax bool
+}
+
+type committedFile struct {
+	rel string
+	// backup is the path to the overwritten file, or empty if the file did not exist
+	backup string
+}
+
+// Begin starts a transaction in the working directory
+func Begin(workingDir string) (*Transaction, error) {
+	entries, err := os.ReadDir(workingDir)
+	if err != nil {
+		return nil, err
+	}
+
+	scratch, err := os.MkdirTemp(workingDir, ".terraformify-tx-*")
+	if err != nil {
+		return nil, err
+	}
+
+	tx := &Transaction{
+		workingDir: workingDir,
+		scratch:    scratch,
+		existed:    map[string]bool{},
+		backedUp:   map[string]bool{},
+	}
+	for _, e := range entries {
+		tx.existed[e.Name()] = true
+	}
+
+	for _, name := range terraformFiles {
+		if _, err := os.Lstat(filepath.Join(workingDir, name)); errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			_ = os.RemoveAll(scratch)
+			return nil, err
+		}
+		tx.backedUp[name] = true
+		if err := copyTree(filepath.Join(workingDir, name), filepath.Join(scratch, backupDir, "terraform", name)); err != nil {
+			_ = os.RemoveAll(scratch)
+			return nil, err
+		}
+	}
+
+	return tx, nil
+}
+
+func (tx *Transaction) isStaged(rel string) bool {
+	for _, s := range tx.staged {
+		if s == rel {
+			return true
+		}
+	}
+	return false
+}
+
+// Rewrite stages the new content of the file, replacing the file in the working directory if there is one
+func (tx *Transaction) Rewrite(rel string, content []byte) error {
+	staged := filepath.Join(tx.scratch, stagedDir, rel)
+	if err := os.MkdirAll(filepath.Dir(staged), 0755); err != nil {
+		return err
+	}
+	log.Printf("[INFO] file: rewriting %s", filepath.Join(tx.workingDir, rel))
+	if !tx.isStaged(rel) {
+		tx.staged = append(tx.staged, rel)
+	}
+	return write(staged, content, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+}
+
+// Remove stages the removal of the file or the directory and everything under it
+func (tx *Transaction) Remove(rel string) {
+	log.Printf("[INFO] file: removing %s", filepath.Join(tx.workingDir, rel))
+	tx.removed = append(tx.removed, rel)
+}
+
+// Commit moves the staged files into the working directory and removes the files staged for removal.
+// The files they replace or remove are kept until Close.
+func (tx *Transaction) Commit() error {
+	for _, rel := range tx.staged {
+		file := filepath.Join(tx.workingDir, rel)
+		if err := tx.mkdirAll(filepath.Dir(rel)); err != nil {
+			return err
+		}
+
+		c := committedFile{rel: rel}
+		if _, err := os.Stat(file); err == nil {
+			c.backup = filepath.Join(tx.scratch, backupDir, "committed", rel)
+			if err := os.MkdirAll(filepath.Dir(c.backup), 0755); err != nil {
+				return err
+			}
+			if err := os.Rename(file, c.backup); err != nil {
+				return err
+			}
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		if err := os.Rename(filepath.Join(tx.scratch, stagedDir, rel), file); err != nil {
+			// Put back the file moved aside above
+			if c.backup != "" {
+				if err1 := os.Rename(c.backup, file); err1 != nil {
+					log.Printf("[ERROR] file: failed to restore %s: %s", file, err1)
+				}
+			}
+			return err
+		}
+		tx.committed = append(tx.committed, c)
+	}
+	tx.staged = nil
+
+	for _, rel := range tx.removed {
+		file := filepath.Join(tx.workingDir, rel)
+		if _, err := os.Lstat(file); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		// Rollback puts the removed file back as it puts back an overwritten one
+		c := committedFile{rel: rel, backup: filepath.Join(tx.scratch, backupDir, "committed", rel)}
+		if err := os.MkdirAll(filepath.Dir(c.backup), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(file, c.backup); err != nil {
+			return err
+		}
+		tx.committed = append(tx.committed, c)
+	}
+	tx.removed = nil
+	return nil
+}
+
+// mkdirAll creates the directory and its missing parents, recording each of them
+func (tx *Transaction) mkdirAll(rel string) error {
+	if rel == "." {
+		return nil
+	}
+	if err := tx.mkdirAll(filepath.Dir(rel)); err != nil {
+		return err
+	}
+
+	dir := filepath.Join(tx.workingDir, rel)
+	if _, err := os.Stat(dir); !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return err
+	}
+	tx.createdDirs = append(tx.createdDirs, dir)
+	return nil
+}
+
+// Rollback restores the working directory to how it was when the transaction began and ends the transaction.
+// It carries on when a step fails so that as much as possible is restored, and returns the first error.
+func (tx *Transaction) Rollback() error {
+	var errs []error
+
+	// Undo Commit in reverse order
+	for i := len(tx.committed) - 1; i >= 0; i-- {
+		c := tx.committed[i]
+		file := filepath.Join(tx.workingDir, c.rel)
+		if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+			continue
+		}
+		if c.backup != "" {
+			if err := os.Rename(c.backup, file); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	for i := len(tx.createdDirs) - 1; i >= 0; i-- {
+		if err := os.Remove(tx.createdDirs[i]); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+
+	// Put back the files written by Terraform, removing the ones created since, such as .terraform/modules
+	// in an existing .terraform
+	for _, name := range terraformFiles {
+		file := filepath.Join(tx.workingDir, name)
+		if err := os.RemoveAll(file); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !tx.backedUp[name] {
+			continue
+		}
+		if err := os.Rename(filepath.Join(tx.scratch, backupDir, "terraform", name), file); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// Remove everything created since the transaction began, such as provider.tf, .terraform and terraform.tfstate.
+	// The state snapshots are kept.
+	entries, err := os.ReadDir(tx.workingDir)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, e := range entries {
+		file := filepath.Join(tx.workingDir, e.Name())
+		if tx.existed[e.Name()] || file == tx.scratch || e.Name() == stateDir {
+			continue
+		}
+		log.Printf("[INFO] file: removing %s", file)
+		if err := os.RemoveAll(file); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := tx.Close(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("file: failed to restore %s: %w", tx.workingDir, errs[0])
+	}
+	return nil
+}
+
+// Close ends the transaction, removing the staged files that have not been committed and the backups
+func (tx *Transaction) Close() error {
+	if err := os.RemoveAll(tx.scratch); err != nil {
+		return err
+	}
+	tx.staged = nil
+	tx.removed = nil
+	tx.committed = nil
+	tx.createdDirs = nil
+	return nil
+}
+
+func copyFile(src, dst string) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err1 := out.Close(); err1 != nil && err == nil {
+			err = err1
+		}
+	}()
+
+	_, err = io.Copy(out, in)
+	return err
+}
+
+// copyTree copies the file, or the directory and everything under it. Symbolic links are copied as they are,
+// as the modules installed in .terraform may link to the local module directories.
+func copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, 0755)
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		default:
+			return copyFile(path, target)
+		}
+	})
+}
+
+// CopyDir copies everything in the directory into dst, which is created if it does not exist
+func CopyDir(src, dst string) error {
+	return copyTree(src, dst)
+}
+
+// Snapshot returns the content of every file under the directory keyed by its relative path.
+// It is used to compare the directory before and after a run.
+func Snapshot(dir string) (map[string]string, error) {
+	files := map[string]string{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if rel != "." {
+				files[rel+string(filepath.Separator)] = ""
+			}
+			return nil
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		files[rel] = string(b)
+		return nil
+	})
+	return files, err
+}
@@ -0,0 +1,285 @@
+package file_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hrmsk66/terraformify/pkg/file"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func snapshot(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files, err := file.Snapshot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// stage writes the files of an import of a VCL service named "service"
+func stage(t *testing.T, tx *file.Transaction) {
+	t.Helper()
+	for _, err := range []error{
+		tx.WriteTF("service", []byte("resource \"fastly_service_vcl\" \"service\" {}\n")),
+		tx.WriteGitIgnore(),
+		tx.WriteVariablesTF([]byte("variable \"key\" {}\n")),
+		tx.WriteTFVars([]byte("key = \"secret\"\n")),
+		tx.WriteVCL("service", "main.vcl", []byte("sub vcl_recv {}\n")),
+		tx.WriteContent("service", "blob.txt", []byte("blob\n")),
+		tx.WriteLogFormat("service", "s3.txt", []byte("%h\n")),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTransactionCommit(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"variables.tf": "variable \"region\" {}\n",
+		".gitignore":   "*.tfplan\n",
+	})
+
+	tx, err := file.Begin(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stage(t, tx)
+
+	// Nothing is written to the working directory until the commit
+	if _, err := os.Stat(filepath.Join(dir, "service.tf")); !os.IsNotExist(err) {
+		t.Errorf("service.tf is written before the commit")
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if err := tx.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	want := map[string]string{
+		"service.tf":               "resource \"fastly_service_vcl\" \"service\" {}\n",
+		".gitignore":               "*.tfplan\n",
+		"variables.tf":             "variable \"region\" {}\nvariable \"key\" {}\n",
+		"terraform.tfvars":         "key = \"secret\"\n",
+		"vcl/":                     "",
+		"vcl/service/":             "",
+		"vcl/service/main.vcl":     "sub vcl_recv {}\n",
+		"content/":                 "",
+		"content/service/":         "",
+		"content/service/blob.txt": "blob\n",
+		"logformat/":               "",
+		"logformat/service/":       "",
+		"logformat/service/s3.txt": "%h\n",
+	}
+	if got := snapshot(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("working directory = %q, want %q", got, want)
+	}
+}
+
+func TestTransactionRollback(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"variables.tf":                      "variable \"region\" {}\n",
+		"terraform.tfstate":                 "{\"serial\":1}\n",
+		"vcl/other/main.vcl":                "sub vcl_recv {}\n",
+		".terraform.lock.hcl":               "# lock\n",
+		".terraform/environment":            "staging",
+		".terraform/modules/modules.json":   "{\"Modules\":[]}\n",
+		".terraform/providers/fastly/5.0.0": "v5.0.0",
+		"terraform.tfstate.d/staging/.keep": "",
+	})
+	before := snapshot(t, dir)
+
+	tx, err := file.Begin(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The providers are not backed up
+	for name := range snapshot(t, dir) {
+		if strings.HasPrefix(name, ".terraformify-tx-") && strings.Contains(name, "providers") {
+			t.Errorf("%s is backed up", name)
+		}
+	}
+	tempf, err := tx.CreateInitTerraformFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tempf.Close(); err != nil {
+		t.Fatal(err)
+	}
+	stage(t, tx)
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	// Terraform rewrites the state and the lock file, selects another workspace, upgrades the providers and the modules
+	// and creates its own files during the run
+	writeFiles(t, dir, map[string]string{
+		"terraform.tfstate":                 "{\"serial\":2}\n",
+		"terraform.tfstate.backup":          "{\"serial\":1}\n",
+		".terraform.lock.hcl":               "# upgraded\n",
+		".terraform/environment":            "default",
+		".terraform/terraform.tfstate":      "{\"backend\":{}}\n",
+		".terraform/modules/modules.json":   "{\"Modules\":[{}]}\n",
+		".terraform/modules/cdn/main.tf":    "",
+		".terraform/providers/fastly/5.1.0": "v5.1.0",
+		"terraform.tfstate.d/default/.keep": "",
+		"terraformify.tfplan":               "plan",
+	})
+
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+	// Everything but the upgraded provider is put back
+	before[filepath.Join(".terraform", "providers", "fastly", "5.1.0")] = "v5.1.0"
+	if got := snapshot(t, dir); !reflect.DeepEqual(got, before) {
+		t.Errorf("working directory = %q, want %q", got, before)
+	}
+}
+
+func TestTransactionExistingFile(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"service.tf": "# existing\n"})
+
+	tx, err := file.Begin(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Close()
+
+	err = tx.WriteTF("service", []byte("resource \"fastly_service_vcl\" \"service\" {}\n"))
+	if err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("err = %v, want an error for the existing file", err)
+	}
+}
+
+func TestTransactionJSONSyntax(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"provider.tf":             "terraform {}\n",
+		"variables.tf.json":       "{\"variable\": {\"region\": {}}}\n",
+		"terraform.tfvars.json":   "{\"region\": \"asia\"}\n",
+		"imports.tf.json":         "{\"import\": [{\"to\": \"a.b\", \"id\": \"1\"}]}\n",
+		"modules/cdn/versions.tf": "terraform {}\n",
+	})
+
+	tx, err := file.Begin(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.UseJSONSyntax()
+	tempf, err := tx.CreateInitTerraformFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tempf.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(tempf.Name()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, err := range []error{
+		tx.WriteTF("service", []byte("{\"resource\": {}}\n")),
+		tx.WriteVariablesTF([]byte("{\"variable\": {\"key\": {\"sensitive\": true}}}\n")),
+		tx.WriteTFVars([]byte("{\"key\": \"<secret>\"}\n")),
+		tx.WriteImportsTF([]byte("{\"import\": [{\"to\": \"c.d\", \"id\": \"2\"}]}\n")),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The required providers of the module are already declared in the native syntax
+	tx.SetModuleDir(filepath.Join("modules", "cdn"))
+	if err := tx.WriteModuleVersionsTF(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if err := tx.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// The JSON files are merged into, rather than appended to, and provider.tf is not declared again in provider.tf.json
+	want := map[string]string{
+		"provider.tf":             "terraform {}\n",
+		"service.tf.json":         "{\"resource\": {}}\n",
+		"variables.tf.json":       "{\n  \"variable\": {\n    \"region\": {},\n    \"key\": {\n      \"sensitive\": true\n    }\n  }\n}\n",
+		"terraform.tfvars.json":   "{\n  \"region\": \"asia\",\n  \"key\": \"<secret>\"\n}\n",
+		"imports.tf.json":         "{\n  \"import\": [\n    {\n      \"to\": \"a.b\",\n      \"id\": \"1\"\n    },\n    {\n      \"to\": \"c.d\",\n      \"id\": \"2\"\n    }\n  ]\n}\n",
+		"modules/":                "",
+		"modules/cdn/":            "",
+		"modules/cdn/versions.tf": "terraform {}\n",
+	}
+	if got := snapshot(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("working directory = %q, want %q", got, want)
+	}
+}
+
+func TestTransactionRemove(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"service.tf":           "resource \"fastly_service_vcl\" \"service\" {}\n",
+		"variables.tf":         "variable \"region\" {}\nvariable \"key\" {}\n",
+		"vcl/service/main.vcl": "sub vcl_recv {}\n",
+		"vcl/other/main.vcl":   "sub vcl_recv {}\n",
+	})
+	before := snapshot(t, dir)
+
+	tx, err := file.Begin(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Remove("service.tf")
+	tx.Remove(filepath.Join("vcl", "service"))
+	if err := tx.Rewrite("variables.tf", []byte("variable \"region\" {}\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	want := map[string]string{
+		"variables.tf":                     "variable \"region\" {}\n",
+		"vcl" + string(filepath.Separator): "",
+		filepath.Join("vcl", "other") + string(filepath.Separator): "",
+		filepath.Join("vcl", "other", "main.vcl"):                  "sub vcl_recv {}\n",
+	}
+	got := snapshot(t, dir)
+	for name := range got {
+		if strings.HasPrefix(name, ".terraformify-tx-") {
+			delete(got, name)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("working directory after the commit = %q, want %q", got, want)
+	}
+
+	// The removed files are put back
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+	if got := snapshot(t, dir); !reflect.DeepEqual(got, before) {
+		t.Errorf("working directory after the rollback = %q, want %q", got, before)
+	}
+}
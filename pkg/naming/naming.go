@@ -1,6 +1,7 @@
 package naming
 
 import (
+	"fmt"
 	"regexp"
 	"strings"
 )
@@ -26,3 +27,13 @@ func IsValid(name string) bool {
 	// Spaces and dots are allowed here since they are replaced with underscores in TFBlockProp.GetNormalizedName()
 	return regexp.MustCompile(`^[A-Za-z_][0-9A-Za-z_.\-\\s]*$`).MatchString(name)
 }
+
+// ModuleName returns the name of the module in the module path, such as "cdn" in "module.cdn".
+// Only a module called from the root module is supported.
+func ModuleName(modulePath string) (string, error) {
+	name := strings.TrimPrefix(modulePath, "module.")
+	if name == modulePath || !regexp.MustCompile(`^[A-Za-z_][0-9A-Za-z_\-]*$`).MatchString(name) {
+		return "", fmt.Errorf("invalid module path %q. specify a module called from the root module, such as module.cdn", modulePath)
+	}
+	return name, nil
+}
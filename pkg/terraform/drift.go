@@ -0,0 +1,236 @@
+package terraform
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// verifyPlanFile is the plan saved by CheckDrift. It holds the sensitive values of the resources, so it is removed once read.
+const verifyPlanFile = "terraformify_verify.tfplan"
+
+// ResourceDrift is a change that "terraform plan" would make to a resource right after the import
+type ResourceDrift struct {
+	Address string
+	// Action is what the plan would do to the resource, such as "update" or "replace"
+	Action string
+	// Attributes lists the values that would change. It is empty when the resource would be created or deleted.
+	Attributes []AttributeDrift
+}
+
+// AttributeDrift is a value that would change, rendered in JSON
+type AttributeDrift struct {
+	// Path is the path to the value, such as backend[0].comment
+	Path   string
+	Before string
+	After  string
+}
+
+// plannedChange is a "planned_change" message of the machine-readable output of "terraform plan -json"
+type plannedChange struct {
+	Type   string `json:"type"`
+	Change struct {
+		Resource struct {
+			Addr string `json:"addr"`
+		} `json:"resource"`
+		Action string `json:"action"`
+	} `json:"change"`
+}
+
+// CheckDrift runs "terraform plan -detailed-exitcode -json" and returns the changes it would make to the resources.
+// Right after an import, there are none if the configuration matches the imported resources.
+func CheckDrift(ctx context.Context, tf Runner) ([]ResourceDrift, error) {
+	workingDir, err := filepath.Abs(tf.WorkingDir())
+	if err != nil {
+		return nil, err
+	}
+	planPath := filepath.Join(workingDir, verifyPlanFile)
+	defer func() {
+		if err := os.Remove(planPath); err != nil && !os.IsNotExist(err) {
+			log.Printf("[WARN] failed to remove %s: %s", planPath, err)
+		}
+	}()
+
+	log.Print(`[INFO] Running "terraform plan -detailed-exitcode -json" to check that the configuration matches the service`)
+	out, changes, err := tf.PlanJSON(ctx, verifyPlanFile)
+	if err != nil {
+		return nil, err
+	}
+	if !changes {
+		return nil, nil
+	}
+
+	actions, err := plannedActions(out)
+	if err != nil {
+		return nil, err
+	}
+
+	// The machine-readable output only tells which resources change, so the values are read from the saved plan
+	plan, err := tf.ShowPlanFile(ctx, planPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var drifts []ResourceDrift
+	for _, rc := range plan.ResourceChanges {
+		action, ok := actions[rc.Address]
+		if !ok || rc.Change == nil {
+			continue
+		}
+		d := ResourceDrift{Address: rc.Address, Action: action}
+		if rc.Change.Actions.Update() || rc.Change.Actions.Replace() {
+			sensitive := []interface{}{rc.Change.BeforeSensitive, rc.Change.AfterSensitive}
+			diffValues(&d.Attributes, "", rc.Change.Before, rc.Change.After, rc.Change.AfterUnknown, sensitive)
+		}
+		drifts = append(drifts, d)
+	}
+	return drifts, nil
+}
+
+// plannedActions maps the address of each resource to change to the action in the "planned_change" messages
+func plannedActions(out string) (map[string]string, error) {
+	actions := map[string]string{}
+	sc := bufio.NewScanner(strings.NewReader(out))
+	sc.Buffer(nil, 16*1024*1024)
+	for sc.Scan() {
+		var msg plannedChange
+		if err := json.Unmarshal(sc.Bytes(), &msg); err != nil {
+			return nil, fmt.Errorf("terraform: unexpected output of terraform plan -json: %w", err)
+		}
+		if msg.Type != "planned_change" {
+			continue
+		}
+		// Resources read during the plan, such as data sources, do not change anything
+		if msg.Change.Action != "noop" && msg.Change.Action != "read" {
+			actions[msg.Change.Resource.Addr] = msg.Change.Action
+		}
+	}
+	return actions, sc.Err()
+}
+
+// diffValues appends the values that differ between before and after, walking into the objects and the lists.
+// unknown marks the values known only after apply, and sensitive holds the markers of the values not to be shown,
+// each mirroring the structure of the value as in the plan.
+func diffValues(drifts *[]AttributeDrift, path string, before, after, unknown interface{}, sensitive []interface{}) {
+	if unknown == true {
+		*drifts = append(*drifts, AttributeDrift{Path: path, Before: render(before, sensitive), After: "(known after apply)"})
+		return
+	}
+
+	bm, bIsMap := before.(map[string]interface{})
+	am, aIsMap := after.(map[string]interface{})
+	if (bIsMap || before == nil) && (aIsMap || after == nil) && (bIsMap || aIsMap) {
+		keys := map[string]bool{}
+		for k := range bm {
+			keys[k] = true
+		}
+		for k := range am {
+			keys[k] = true
+		}
+		// The values known only after apply are left out of after
+		if um, ok := unknown.(map[string]interface{}); ok {
+			for k, u := range um {
+				if u == true {
+					keys[k] = true
+				}
+			}
+		}
+		for _, k := range sortedNames(keys) {
+			diffValues(drifts, joinPath(path, k), bm[k], am[k], child(unknown, k), children(sensitive, k))
+		}
+		return
+	}
+
+	bl, bIsList := before.([]interface{})
+	al, aIsList := after.([]interface{})
+	if (bIsList || before == nil) && (aIsList || after == nil) && (bIsList || aIsList) {
+		n := len(bl)
+		if len(al) > n {
+			n = len(al)
+		}
+		for i := 0; i < n; i++ {
+			var b, a interface{}
+			if i < len(bl) {
+				b = bl[i]
+			}
+			if i < len(al) {
+				a = al[i]
+			}
+			diffValues(drifts, fmt.Sprintf("%s[%d]", path, i), b, a, child(unknown, i), children(sensitive, i))
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(before, after) {
+		*drifts = append(*drifts, AttributeDrift{Path: path, Before: render(before, sensitive), After: render(after, sensitive)})
+	}
+}
+
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+func joinPath(path, key string) string {
+	if !identifier.MatchString(key) {
+		return fmt.Sprintf("%s[%q]", path, key)
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// child returns the marker of the element under the key, which is a string for an object and an int for a list.
+// A marker of true applies to everything under it.
+func child(marker interface{}, key interface{}) interface{} {
+	switch m := marker.(type) {
+	case bool:
+		return m
+	case map[string]interface{}:
+		if k, ok := key.(string); ok {
+			return m[k]
+		}
+	case []interface{}:
+		if i, ok := key.(int); ok && i < len(m) {
+			return m[i]
+		}
+	}
+	return nil
+}
+
+func children(markers []interface{}, key interface{}) []interface{} {
+	c := make([]interface{}, len(markers))
+	for i, m := range markers {
+		c[i] = child(m, key)
+	}
+	return c
+}
+
+// render returns the value in JSON, or a placeholder if it is marked sensitive
+func render(v interface{}, sensitive []interface{}) string {
+	for _, m := range sensitive {
+		if m == true {
+			return "(sensitive value)"
+		}
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+func sortedNames(m map[string]bool) []string {
+	names := make([]string, 0, len(m))
+	for k := range m {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,96 @@
+package terraform_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hrmsk66/terraformify/pkg/terraform"
+	"github.com/hrmsk66/terraformify/pkg/terraform/terraformtest"
+)
+
+// driftPlan updates the service in the ways the generated configuration is known to differ from it
+const driftPlan = `{
+  "format_version": "1.2",
+  "resource_changes": [
+    {
+      "address": "fastly_service_vcl.service",
+      "mode": "managed",
+      "type": "fastly_service_vcl",
+      "name": "service",
+      "change": {
+        "actions": ["update"],
+        "before": {
+          "comment": "",
+          "version_comment": null,
+          "request_setting": [{"name": "rs", "xff": "append"}],
+          "backend": [{"name": "httpbin", "ssl_client_key": "old"}]
+        },
+        "after": {
+          "comment": "Managed by Terraform",
+          "request_setting": [{"name": "rs", "xff": ""}],
+          "backend": [{"name": "httpbin", "ssl_client_key": "new"}]
+        },
+        "after_unknown": {"active_version": true},
+        "before_sensitive": {"backend": [{"ssl_client_key": true}]},
+        "after_sensitive": {"backend": [{"ssl_client_key": true}]}
+      }
+    },
+    {
+      "address": "fastly_service_acl_entries.allow_list",
+      "mode": "managed",
+      "type": "fastly_service_acl_entries",
+      "name": "allow_list",
+      "change": {"actions": ["no-op"], "before": {}, "after": {}}
+    },
+    {
+      "address": "fastly_service_dictionary_items.redirects",
+      "mode": "managed",
+      "type": "fastly_service_dictionary_items",
+      "name": "redirects",
+      "change": {"actions": ["create"], "before": null, "after": {"items": {}}}
+    }
+  ]
+}`
+
+func TestCheckDrift(t *testing.T) {
+	dir := t.TempDir()
+	tf := &terraformtest.Runner{Dir: dir, VerifyPlans: []string{driftPlan}}
+
+	drifts, err := terraform.CheckDrift(context.Background(), tf)
+	if err != nil {
+		t.Fatalf("CheckDrift failed: %v", err)
+	}
+
+	want := []terraform.ResourceDrift{
+		{
+			Address: "fastly_service_vcl.service",
+			Action:  "update",
+			Attributes: []terraform.AttributeDrift{
+				{Path: "active_version", Before: "null", After: "(known after apply)"},
+				{Path: "backend[0].ssl_client_key", Before: "(sensitive value)", After: "(sensitive value)"},
+				{Path: "comment", Before: `""`, After: `"Managed by Terraform"`},
+				{Path: "request_setting[0].xff", Before: `"append"`, After: `""`},
+			},
+		},
+		{Address: "fastly_service_dictionary_items.redirects", Action: "create"},
+	}
+	if !reflect.DeepEqual(drifts, want) {
+		t.Errorf("drifts = %+v, want %+v", drifts, want)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "terraformify_verify.tfplan")); !os.IsNotExist(err) {
+		t.Error("the saved plan is left in the working directory")
+	}
+
+	// A plan without changes is not read
+	tf = &terraformtest.Runner{Dir: dir}
+	if drifts, err = terraform.CheckDrift(context.Background(), tf); err != nil || drifts != nil {
+		t.Errorf("CheckDrift = %+v, %v, want no drift", drifts, err)
+	}
+	if want := []string{"plan -json"}; !reflect.DeepEqual(tf.Calls, want) {
+		t.Errorf("Calls = %q, want %q", tf.Calls, want)
+	}
+}
@@ -0,0 +1,97 @@
+package terraform
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// LocalBackendOverrideFile is written by Isolate to switch the configuration to the local backend.
+// An override file replaces the backend or cloud block of the configuration without editing it.
+const LocalBackendOverrideFile = "terraformify_dry_run_override.tf"
+
+const localBackendOverride = `terraform {
+  backend "local" {}
+}
+`
+
+// Isolate switches a copy of a working directory to the local backend, seeded with the state of the workspace
+// read from the configured backend, so that the import can be run in the copy without writing to the backend.
+// It returns the workspace the state was read from, which is the selected one if workspace is empty.
+func Isolate(ctx context.Context, tf Runner, workspace string) (string, error) {
+	dir := tf.WorkingDir()
+	configs, err := filepath.Glob(filepath.Join(dir, "*.tf"))
+	if err != nil {
+		return "", err
+	}
+
+	// Nothing to read the state from until the directory has a configuration
+	if len(configs) > 0 {
+		log.Print(`[INFO] Running "terraform init" to read the state from the configured backend`)
+		if err := tf.Init(ctx); err != nil {
+			return "", err
+		}
+
+		workspaces, current, err := tf.WorkspaceList(ctx)
+		if err != nil {
+			return "", err
+		}
+		if workspace == "" {
+			workspace = current
+		}
+
+		exists := false
+		for _, w := range workspaces {
+			if w == workspace {
+				exists = true
+			}
+		}
+		if exists {
+			if workspace != current {
+				if err := tf.WorkspaceSelect(ctx, workspace); err != nil {
+					return "", err
+				}
+			}
+			s, err := SnapshotState(ctx, tf)
+			if err != nil {
+				return "", err
+			}
+			if s != nil {
+				if err := writeLocalState(dir, workspace, s.Bytes()); err != nil {
+					return "", err
+				}
+			}
+		}
+	}
+
+	// Forget the backend recorded by "terraform init" so that the next init starts afresh with the local one
+	for _, name := range []string{"terraform.tfstate", "environment"} {
+		if err := os.Remove(filepath.Join(dir, ".terraform", name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+	}
+
+	log.Printf("[INFO] Switching %s to the local backend", dir)
+	if err := os.WriteFile(filepath.Join(dir, LocalBackendOverrideFile), []byte(localBackendOverride), 0644); err != nil {
+		return "", err
+	}
+	return workspace, nil
+}
+
+// writeLocalState writes the state where the local backend reads it for the workspace
+func writeLocalState(dir, workspace string, state []byte) error {
+	p := filepath.Join(dir, "terraform.tfstate")
+	if workspace != "" && workspace != "default" {
+		p = filepath.Join(dir, "terraform.tfstate.d", workspace, "terraform.tfstate")
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return fmt.Errorf("terraform: failed to write the state to %s: %w", p, err)
+	}
+	if err := os.WriteFile(p, state, 0600); err != nil {
+		return fmt.Errorf("terraform: failed to write the state to %s: %w", p, err)
+	}
+	return nil
+}
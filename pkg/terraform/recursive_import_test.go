@@ -0,0 +1,107 @@
+package terraform_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hrmsk66/terraformify/pkg/prop"
+	"github.com/hrmsk66/terraformify/pkg/terraform"
+	"github.com/hrmsk66/terraformify/pkg/terraform/terraformtest"
+)
+
+const storeID = "4t1ATv0LyQ6GPNXxZHpcAz"
+
+const emptyPlan = `{"format_version":"1.2","terraform_version":"1.6.0"}`
+
+func newLinkedResource() *prop.LinkedResource {
+	serviceProp := prop.NewComputeServiceResource("7ManTUgtlSytxeXRMPYY33", "service", 0)
+	return prop.NewLinkedResource(storeID, "my store", serviceProp)
+}
+
+func TestRecursiveImport(t *testing.T) {
+	tf := &terraformtest.Runner{
+		Dir:       t.TempDir(),
+		TFVersion: "1.4.5",
+		ImportErrors: map[string]error{
+			"fastly_configstore.my_store": errors.New("Error: Cannot import non-existent remote object"),
+			"fastly_secretstore.my_store": errors.New("Error: 404 - Not Found"),
+		},
+	}
+	p := newLinkedResource()
+
+	var buf bytes.Buffer
+	if err := terraform.RecursiveImport(context.Background(), tf, p, &buf); err != nil {
+		t.Fatalf("RecursiveImport failed: %v", err)
+	}
+
+	if p.GetType() != "fastly_kvstore" {
+		t.Errorf("type = %s, want fastly_kvstore", p.GetType())
+	}
+
+	expectedCalls := []string{
+		"import fastly_configstore.my_store " + storeID,
+		"import fastly_secretstore.my_store " + storeID,
+		"import fastly_kvstore.my_store " + storeID,
+	}
+	if !reflect.DeepEqual(tf.Calls, expectedCalls) {
+		t.Errorf("Calls = %q, want %q", tf.Calls, expectedCalls)
+	}
+}
+
+func TestRecursiveImportNoMoreType(t *testing.T) {
+	notFound := errors.New("Error: Cannot import non-existent remote object")
+	tf := &terraformtest.Runner{
+		Dir:       t.TempDir(),
+		TFVersion: "1.4.5",
+		ImportErrors: map[string]error{
+			"fastly_configstore.my_store": notFound,
+			"fastly_secretstore.my_store": notFound,
+			"fastly_kvstore.my_store":     notFound,
+		},
+	}
+
+	var buf bytes.Buffer
+	err := terraform.RecursiveImport(context.Background(), tf, newLinkedResource(), &buf)
+	if !errors.Is(err, prop.ErrNoMoreResourceType) {
+		t.Errorf("err = %v, want %v", err, prop.ErrNoMoreResourceType)
+	}
+}
+
+func TestRecursiveImportBlock(t *testing.T) {
+	tf := &terraformtest.Runner{
+		Dir:       t.TempDir(),
+		TFVersion: "1.6.0",
+		Plans: []terraformtest.Plan{
+			{Err: errors.New("Error: Cannot import non-existent remote object")},
+			{JSON: emptyPlan},
+		},
+	}
+	p := newLinkedResource()
+
+	var buf bytes.Buffer
+	if err := terraform.RecursiveImportBlock(context.Background(), tf, p, &buf); err != nil {
+		t.Fatalf("RecursiveImportBlock failed: %v", err)
+	}
+
+	if p.GetType() != "fastly_secretstore" {
+		t.Errorf("type = %s, want fastly_secretstore", p.GetType())
+	}
+
+	expectedCalls := []string{"plan", "plan", "show -json"}
+	if !reflect.DeepEqual(tf.Calls, expectedCalls) {
+		t.Errorf("Calls = %q, want %q", tf.Calls, expectedCalls)
+	}
+
+	// Only the import block of the type that was found is added
+	out := buf.String()
+	if n := strings.Count(out, "import {"); n != 1 {
+		t.Errorf("got %d import blocks, want 1:\n%s", n, out)
+	}
+	if !strings.Contains(out, "to = fastly_secretstore.my_store") {
+		t.Errorf("import block does not target fastly_secretstore.my_store:\n%s", out)
+	}
+}
@@ -0,0 +1,231 @@
+package terraform
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-version"
+	"github.com/hashicorp/terraform-exec/tfexec"
+	tfjson "github.com/hashicorp/terraform-json"
+)
+
+// Runner runs the Terraform commands used in the import flows.
+// It abstracts terraform-exec so that the flows can be driven by a scripted fake in tests (see the terraformtest package).
+// The commands are stopped when the context is canceled.
+type Runner interface {
+	// WorkingDir returns the directory the commands are run in
+	WorkingDir() string
+	// Engine tells whether the executable is Terraform or OpenTofu
+	Engine(ctx context.Context) (Engine, error)
+	Version(ctx context.Context) (*version.Version, map[string]*version.Version, error)
+	Init(ctx context.Context) error
+	// WorkspaceList returns the workspaces and the one currently selected
+	WorkspaceList(ctx context.Context) ([]string, string, error)
+	WorkspaceSelect(ctx context.Context, workspace string) error
+	WorkspaceNew(ctx context.Context, workspace string) error
+	// WorkspaceDelete deletes the workspace, which must not be selected. It fails if the state of the workspace has any resources.
+	WorkspaceDelete(ctx context.Context, workspace string) error
+	Import(ctx context.Context, address, id string) error
+	// ShowState returns the human-readable "terraform show" output of the current state
+	ShowState(ctx context.Context) (string, error)
+	// StatePull returns the current state in JSON, read from the configured backend
+	StatePull(ctx context.Context) (string, error)
+	// StatePush writes the state file at the path to the configured backend
+	StatePush(ctx context.Context, path string) error
+	// PlanGenerateConfig runs "terraform plan" with -generate-config-out and -out. The paths are relative to the working directory.
+	PlanGenerateConfig(ctx context.Context, generatedConfigFile, planFile string) error
+	ShowPlanFile(ctx context.Context, planFile string) (*tfjson.Plan, error)
+	// PlanJSON runs "terraform plan -detailed-exitcode -json" with -out, which is relative to the working directory.
+	// It returns the machine-readable output and whether the plan has any changes.
+	PlanJSON(ctx context.Context, planFile string) (string, bool, error)
+	// ProvidersSchema returns the schemas of the installed providers, as printed by "terraform providers schema -json"
+	ProvidersSchema(ctx context.Context) (*tfjson.ProviderSchemas, error)
+	Apply(ctx context.Context, planFile string) error
+	Refresh(ctx context.Context) error
+}
+
+// execRunner runs the commands with terraform-exec
+type execRunner struct {
+	tf *tfexec.Terraform
+	// timeout bounds each command. Zero means no limit.
+	timeout time.Duration
+}
+
+func (r *execRunner) WorkingDir() string {
+	return r.tf.WorkingDir()
+}
+
+// Engine tells Terraform and OpenTofu apart from the first line of the "version" output,
+// since the executable may have any name
+func (r *execRunner) Engine(ctx context.Context) (Engine, error) {
+	ctx, cancel := r.step(ctx)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, r.tf.ExecPath(), "version").Output()
+	if err != nil {
+		return "", r.wrap(ctx, "version", fmt.Errorf("failed to run %s version: %w", r.tf.ExecPath(), err))
+	}
+
+	if strings.HasPrefix(string(out), "OpenTofu") {
+		return OpenTofu, nil
+	}
+	return Terraform, nil
+}
+
+func (r *execRunner) Version(ctx context.Context) (*version.Version, map[string]*version.Version, error) {
+	ctx, cancel := r.step(ctx)
+	defer cancel()
+
+	v, providerVers, err := r.tf.Version(ctx, true)
+	return v, providerVers, r.wrap(ctx, "version", err)
+}
+
+func (r *execRunner) Init(ctx context.Context) error {
+	ctx, cancel := r.step(ctx)
+	defer cancel()
+
+	return r.wrap(ctx, "init", r.tf.Init(ctx, tfexec.Upgrade(true)))
+}
+
+func (r *execRunner) WorkspaceList(ctx context.Context) ([]string, string, error) {
+	ctx, cancel := r.step(ctx)
+	defer cancel()
+
+	workspaces, current, err := r.tf.WorkspaceList(ctx)
+	return workspaces, current, r.wrap(ctx, "workspace list", err)
+}
+
+func (r *execRunner) WorkspaceSelect(ctx context.Context, workspace string) error {
+	ctx, cancel := r.step(ctx)
+	defer cancel()
+
+	return r.wrap(ctx, "workspace select", r.tf.WorkspaceSelect(ctx, workspace))
+}
+
+func (r *execRunner) WorkspaceNew(ctx context.Context, workspace string) error {
+	ctx, cancel := r.step(ctx)
+	defer cancel()
+
+	return r.wrap(ctx, "workspace new", r.tf.WorkspaceNew(ctx, workspace))
+}
+
+func (r *execRunner) WorkspaceDelete(ctx context.Context, workspace string) error {
+	ctx, cancel := r.step(ctx)
+	defer cancel()
+
+	return r.wrap(ctx, "workspace delete", r.tf.WorkspaceDelete(ctx, workspace))
+}
+
+func (r *execRunner) Import(ctx context.Context, address, id string) error {
+	ctx, cancel := r.step(ctx)
+	defer cancel()
+
+	return r.wrap(ctx, "import", r.tf.Import(ctx, address, id))
+}
+
+// ShowState runs "terraform show" without a path so that the state is read from the configured backend.
+// tfexec only supports the human-readable output for a given file, so the command is run directly.
+func (r *execRunner) ShowState(ctx context.Context) (string, error) {
+	return r.run(ctx, "show", "-no-color")
+}
+
+func (r *execRunner) StatePull(ctx context.Context) (string, error) {
+	ctx, cancel := r.step(ctx)
+	defer cancel()
+
+	out, err := r.tf.StatePull(ctx)
+	return out, r.wrap(ctx, "state pull", err)
+}
+
+func (r *execRunner) StatePush(ctx context.Context, path string) error {
+	ctx, cancel := r.step(ctx)
+	defer cancel()
+
+	return r.wrap(ctx, "state push", r.tf.StatePush(ctx, path))
+}
+
+func (r *execRunner) PlanGenerateConfig(ctx context.Context, generatedConfigFile, planFile string) error {
+	// tfexec does not support -generate-config-out, so the command is run directly
+	_, err := r.run(ctx, "plan", "-input=false", "-no-color", "-generate-config-out="+generatedConfigFile, "-out="+planFile)
+	return err
+}
+
+func (r *execRunner) ShowPlanFile(ctx context.Context, planFile string) (*tfjson.Plan, error) {
+	ctx, cancel := r.step(ctx)
+	defer cancel()
+
+	plan, err := r.tf.ShowPlanFile(ctx, planFile)
+	return plan, r.wrap(ctx, "show", err)
+}
+
+func (r *execRunner) PlanJSON(ctx context.Context, planFile string) (string, bool, error) {
+	ctx, cancel := r.step(ctx)
+	defer cancel()
+
+	var out bytes.Buffer
+	changes, err := r.tf.PlanJSON(ctx, &out, tfexec.Out(planFile))
+	return out.String(), changes, r.wrap(ctx, "plan", err)
+}
+
+func (r *execRunner) ProvidersSchema(ctx context.Context) (*tfjson.ProviderSchemas, error) {
+	ctx, cancel := r.step(ctx)
+	defer cancel()
+
+	schemas, err := r.tf.ProvidersSchema(ctx)
+	return schemas, r.wrap(ctx, "providers schema", err)
+}
+
+func (r *execRunner) Apply(ctx context.Context, planFile string) error {
+	ctx, cancel := r.step(ctx)
+	defer cancel()
+
+	return r.wrap(ctx, "apply", r.tf.Apply(ctx, tfexec.DirOrPlan(planFile)))
+}
+
+func (r *execRunner) Refresh(ctx context.Context) error {
+	ctx, cancel := r.step(ctx)
+	defer cancel()
+
+	return r.wrap(ctx, "refresh", r.tf.Refresh(ctx))
+}
+
+// run runs the executable in the working directory and returns the stdout
+func (r *execRunner) run(ctx context.Context, args ...string) (string, error) {
+	ctx, cancel := r.step(ctx)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, r.tf.ExecPath(), args...)
+	cmd.Dir = r.tf.WorkingDir()
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", r.wrap(ctx, args[0], fmt.Errorf("terraform %s: %w\n%s", args[0], err, stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// step returns the context for a single command, bounded by the timeout
+func (r *execRunner) step(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, r.timeout)
+}
+
+// wrap tells whether a failed command was stopped by the timeout or by the cancellation of the run,
+// so that the callers can check it with errors.Is
+func (r *execRunner) wrap(ctx context.Context, command string, err error) error {
+	if err == nil || ctx.Err() == nil {
+		return err
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("terraform %s did not finish within %s: %w", command, r.timeout, ctx.Err())
+	}
+	return fmt.Errorf("terraform %s: %w", command, ctx.Err())
+}
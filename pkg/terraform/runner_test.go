@@ -0,0 +1,59 @@
+package terraform
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// hangingExec writes an executable that never finishes, like a hung provider
+func hangingExec(t *testing.T) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("requires a shell script")
+	}
+	p := filepath.Join(t.TempDir(), "terraform")
+	if err := os.WriteFile(p, []byte("#!/bin/sh\nexec sleep 60\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestExecRunnerTimeout(t *testing.T) {
+	tf, err := FindExec(t.TempDir(), hangingExec(t), 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	_, err = tf.ShowState(context.Background())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if !strings.Contains(err.Error(), "did not finish within 100ms") {
+		t.Errorf("err = %v, want the timeout in the message", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("command was not stopped by the timeout: took %s", elapsed)
+	}
+}
+
+func TestExecRunnerCanceled(t *testing.T) {
+	tf, err := FindExec(t.TempDir(), hangingExec(t), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	_, err = tf.ShowState(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,30 @@
+package terraform
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	tfjson "github.com/hashicorp/terraform-json"
+)
+
+// ErrNoProviderSchema is returned by ProviderSchema when the fastly provider is not installed in the working directory
+var ErrNoProviderSchema = errors.New("terraform: no schema found for the fastly provider")
+
+// ProviderSchema reads the schema of the installed fastly provider with "terraform providers schema -json".
+// The provider is found by its source address, which is on the OpenTofu registry when run with OpenTofu.
+func ProviderSchema(ctx context.Context, tf Runner) (*tfjson.ProviderSchema, error) {
+	log.Print(`[INFO] Running "terraform providers schema -json" to read the schema of the fastly provider`)
+	schemas, err := tf.ProvidersSchema(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for source, schema := range schemas.Schemas {
+		if strings.HasSuffix(source, "/fastly/fastly") && schema != nil {
+			return schema, nil
+		}
+	}
+	return nil, ErrNoProviderSchema
+}
@@ -0,0 +1,36 @@
+package terraform_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/hrmsk66/terraformify/pkg/terraform"
+	"github.com/hrmsk66/terraformify/pkg/terraform/terraformtest"
+)
+
+func TestProviderSchema(t *testing.T) {
+	// OpenTofu installs the provider from its own registry
+	tf := &terraformtest.Runner{
+		Dir: t.TempDir(),
+		Schemas: `{
+  "format_version": "1.0",
+  "provider_schemas": {
+    "registry.terraform.io/hashicorp/null": {"resource_schemas": {"null_resource": {"block": {}}}},
+    "registry.opentofu.org/fastly/fastly": {"resource_schemas": {"fastly_service_vcl": {"block": {}}}}
+  }
+}`,
+	}
+	schema, err := terraform.ProviderSchema(context.Background(), tf)
+	if err != nil {
+		t.Fatalf("ProviderSchema failed: %v", err)
+	}
+	if _, ok := schema.ResourceSchemas["fastly_service_vcl"]; !ok {
+		t.Errorf("ProviderSchema returned the schema of another provider: %v", schema.ResourceSchemas)
+	}
+
+	tf = &terraformtest.Runner{Dir: t.TempDir()}
+	if _, err := terraform.ProviderSchema(context.Background(), tf); !errors.Is(err, terraform.ErrNoProviderSchema) {
+		t.Errorf("ProviderSchema without the fastly provider = %v, want %v", err, terraform.ErrNoProviderSchema)
+	}
+}
@@ -0,0 +1,192 @@
+package terraform
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/hrmsk66/terraformify/pkg/tfstate"
+)
+
+// PullState reads the current state with "terraform state pull".
+// It works the same way whether the state is stored locally or in a remote backend such as S3 or GCS.
+func PullState(ctx context.Context, tf Runner) (*tfstate.TFState, error) {
+	s, err := SnapshotState(ctx, tf)
+	if err != nil {
+		return nil, err
+	}
+	if s == nil {
+		return nil, fmt.Errorf("terraform: no state found in %s", tf.WorkingDir())
+	}
+	return s, nil
+}
+
+// SnapshotState is PullState for a workspace that may have no state yet, in which case it returns nil
+func SnapshotState(ctx context.Context, tf Runner) (*tfstate.TFState, error) {
+	log.Print(`[INFO] Running "terraform state pull" to read the current state`)
+	out, err := tf.StatePull(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if out == "" {
+		return nil, nil
+	}
+	return tfstate.Parse([]byte(out))
+}
+
+// PushState writes the edited state back with "terraform state push".
+// The state must have been read by PullState. Nothing is pushed if the lineage no longer matches
+// or another run has written to the state in the meantime. Otherwise the serial is bumped so that
+// the pushed state supersedes the current one, as Terraform does whenever it writes the state.
+// The state is validated first, so an invalid state is never written.
+func PushState(ctx context.Context, tf Runner, s *tfstate.TFState) error {
+	cur, err := PullState(ctx, tf)
+	if err != nil {
+		return err
+	}
+	if cur.Lineage != s.Lineage {
+		return fmt.Errorf("terraform: state lineage mismatch: the state was pulled with lineage %q, but the backend has %q", s.Lineage, cur.Lineage)
+	}
+	if cur.Serial > s.Serial {
+		return fmt.Errorf("terraform: the state has been updated since it was pulled (serial %d, pulled serial %d). run the import again", cur.Serial, s.Serial)
+	}
+	s.Serial = cur.Serial + 1
+
+	return push(ctx, tf, s)
+}
+
+// RestoreState puts back the state taken by SnapshotState, undoing the imports and the edits made since.
+// If the workspace had no state, the resources written since are removed by pushing an empty state.
+func RestoreState(ctx context.Context, tf Runner, snapshot *tfstate.TFState) error {
+	cur, err := SnapshotState(ctx, tf)
+	if err != nil {
+		return err
+	}
+	// Nothing has been written
+	if cur == nil || (snapshot != nil && cur.Lineage == snapshot.Lineage && cur.Serial == snapshot.Serial) {
+		return nil
+	}
+
+	s := snapshot
+	if s == nil {
+		if s, err = cur.Empty(); err != nil {
+			return err
+		}
+	}
+	s.Serial = cur.Serial + 1
+
+	log.Print("[INFO] Restoring the state to the one before the run")
+	return push(ctx, tf, s)
+}
+
+// ReplaceState writes s, such as a snapshot saved before an earlier run, over the current state.
+// base is the state the replacement was decided on, or nil if the workspace had no state. Nothing is pushed
+// if the state has been written since, or if s has another lineage, as "terraform state push" would refuse it.
+func ReplaceState(ctx context.Context, tf Runner, base, s *tfstate.TFState) error {
+	cur, err := SnapshotState(ctx, tf)
+	if err != nil {
+		return err
+	}
+	if cur == nil {
+		if base != nil {
+			return errors.New("terraform: the state has been removed since it was pulled. run the command again")
+		}
+		return push(ctx, tf, s)
+	}
+
+	if base == nil || cur.Lineage != base.Lineage || cur.Serial != base.Serial {
+		return fmt.Errorf("terraform: the state has been updated since it was pulled (serial %d). run the command again", cur.Serial)
+	}
+	if cur.Lineage != s.Lineage {
+		return fmt.Errorf("terraform: state lineage mismatch: the state to write has lineage %q, but the backend has %q", s.Lineage, cur.Lineage)
+	}
+	s.Serial = cur.Serial + 1
+
+	return push(ctx, tf, s)
+}
+
+func push(ctx context.Context, tf Runner, s *tfstate.TFState) (err error) {
+	if err := s.Validate(); err != nil {
+		return fmt.Errorf("terraform: refusing to push an invalid state: %w", err)
+	}
+
+	// The state holds sensitive values, so the file is removed as soon as it is pushed
+	f, err := os.CreateTemp(tf.WorkingDir(), "terraformify*.tfstate")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err1 := os.Remove(f.Name()); err1 != nil && err == nil {
+			err = err1
+		}
+	}()
+
+	_, err = f.Write(s.Bytes())
+	if err1 := f.Close(); err1 != nil && err == nil {
+		err = err1
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Printf(`[INFO] Running "terraform state push" to write the state (serial %d)`, s.Serial)
+	return tf.StatePush(ctx, f.Name())
+}
+
+// WorkspaceSwitch records a switch made by SelectWorkspace, so that it can be undone
+type WorkspaceSwitch struct {
+	// Previous is the workspace selected before the switch
+	Previous string
+	// Workspace is the workspace switched to, which Created tells whether the switch created
+	Workspace string
+	Created   bool
+}
+
+// SelectWorkspace switches to the workspace, creating it if it does not exist.
+// The state commands follow the selected workspace, so the state is read from and written to
+// terraform.tfstate.d/<workspace>/ with the local backend, or the matching key with a remote one.
+// It returns the switch made, which is nil if the workspace is already selected.
+func SelectWorkspace(ctx context.Context, tf Runner, workspace string) (*WorkspaceSwitch, error) {
+	workspaces, current, err := tf.WorkspaceList(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if workspace == current {
+		log.Printf(`[INFO] Workspace "%s" is already selected`, workspace)
+		return nil, nil
+	}
+
+	sw := &WorkspaceSwitch{Previous: current, Workspace: workspace}
+	for _, w := range workspaces {
+		if w == workspace {
+			log.Printf(`[INFO] Running "terraform workspace select %s"`, workspace)
+			return sw, tf.WorkspaceSelect(ctx, workspace)
+		}
+	}
+
+	log.Printf(`[INFO] Running "terraform workspace new %s"`, workspace)
+	if err := tf.WorkspaceNew(ctx, workspace); err != nil {
+		return nil, err
+	}
+	sw.Created = true
+	return sw, nil
+}
+
+// Undo selects the workspace selected before the switch again, and deletes the workspace the switch created.
+// A created workspace is deleted only if its state has no resources, such as after RestoreState has emptied it.
+func (sw *WorkspaceSwitch) Undo(ctx context.Context, tf Runner) error {
+	if sw == nil {
+		return nil
+	}
+	log.Printf(`[INFO] Running "terraform workspace select %s"`, sw.Previous)
+	if err := tf.WorkspaceSelect(ctx, sw.Previous); err != nil {
+		return err
+	}
+	if !sw.Created {
+		return nil
+	}
+	log.Printf(`[INFO] Running "terraform workspace delete %s"`, sw.Workspace)
+	return tf.WorkspaceDelete(ctx, sw.Workspace)
+}
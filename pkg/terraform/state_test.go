@@ -0,0 +1,135 @@
+package terraform_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hrmsk66/terraformify/pkg/terraform"
+	"github.com/hrmsk66/terraformify/pkg/terraform/terraformtest"
+)
+
+const remoteState = `{"version":4,"terraform_version":"1.6.0","serial":3,"lineage":"9b1c6f0e-5d43-4c3a-8e0b-1f2a3b4c5d6e","outputs":{},"resources":[]}`
+
+func TestPushState(t *testing.T) {
+	backend := terraformtest.NewHTTPBackend(remoteState)
+	defer backend.Close()
+	tf := &terraformtest.Runner{Dir: t.TempDir(), Backend: backend.URL}
+
+	s, err := terraform.PullState(context.Background(), tf)
+	if err != nil {
+		t.Fatalf("PullState failed: %v", err)
+	}
+	if err := terraform.PushState(context.Background(), tf, s); err != nil {
+		t.Fatalf("PushState failed: %v", err)
+	}
+
+	if got := backend.State(); !strings.Contains(got, `"serial":4`) || !strings.Contains(got, `"lineage":"9b1c6f0e-5d43-4c3a-8e0b-1f2a3b4c5d6e"`) {
+		t.Errorf("pushed state does not keep the lineage with the bumped serial:\n%s", got)
+	}
+}
+
+func TestPushStateConflict(t *testing.T) {
+	tests := []struct {
+		name     string
+		newState string
+		wantErr  string
+	}{
+		{
+			name:     "updated by another run",
+			newState: strings.Replace(remoteState, `"serial":3`, `"serial":4`, 1),
+			wantErr:  "has been updated since it was pulled",
+		},
+		{
+			name:     "replaced with an unrelated state",
+			newState: strings.Replace(remoteState, "9b1c6f0e", "0d7e3a52", 1),
+			wantErr:  "lineage mismatch",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := terraformtest.NewHTTPBackend(remoteState)
+			defer backend.Close()
+			tf := &terraformtest.Runner{Dir: t.TempDir(), Backend: backend.URL}
+
+			s, err := terraform.PullState(context.Background(), tf)
+			if err != nil {
+				t.Fatalf("PullState failed: %v", err)
+			}
+			backend.SetState(tt.newState)
+
+			err = terraform.PushState(context.Background(), tf, s)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+			if backend.State() != tt.newState {
+				t.Errorf("state was pushed despite the conflict:\n%s", backend.State())
+			}
+			for _, call := range tf.Calls {
+				if call == "state push" {
+					t.Errorf("state push was run despite the conflict")
+				}
+			}
+		})
+	}
+}
+
+func TestPushStateInvalid(t *testing.T) {
+	backend := terraformtest.NewHTTPBackend(remoteState)
+	defer backend.Close()
+	tf := &terraformtest.Runner{Dir: t.TempDir(), Backend: backend.URL}
+
+	s, err := terraform.PullState(context.Background(), tf)
+	if err != nil {
+		t.Fatalf("PullState failed: %v", err)
+	}
+	s.Version = 3
+
+	err = terraform.PushState(context.Background(), tf, s)
+	if err == nil || !strings.Contains(err.Error(), "invalid state") {
+		t.Fatalf("err = %v, want an invalid state error", err)
+	}
+	if backend.State() != remoteState {
+		t.Errorf("invalid state was pushed:\n%s", backend.State())
+	}
+	for _, call := range tf.Calls {
+		if call == "state push" {
+			t.Errorf("state push was run with an invalid state")
+		}
+	}
+}
+
+func TestReplaceState(t *testing.T) {
+	backend := terraformtest.NewHTTPBackend(remoteState)
+	defer backend.Close()
+	tf := &terraformtest.Runner{Dir: t.TempDir(), Backend: backend.URL}
+
+	base, err := terraform.PullState(context.Background(), tf)
+	if err != nil {
+		t.Fatalf("PullState failed: %v", err)
+	}
+	// An older state of the same lineage, such as a snapshot saved before an earlier run
+	old, err := terraform.PullState(context.Background(), tf)
+	if err != nil {
+		t.Fatalf("PullState failed: %v", err)
+	}
+	old.Serial = 1
+
+	// Nothing is pushed over a state written since it was pulled
+	updated := strings.Replace(remoteState, `"serial":3`, `"serial":4`, 1)
+	backend.SetState(updated)
+	if err := terraform.ReplaceState(context.Background(), tf, base, old); err == nil || !strings.Contains(err.Error(), "has been updated") {
+		t.Fatalf("err = %v, want a conflict", err)
+	}
+	if backend.State() != updated {
+		t.Errorf("state was pushed despite the conflict:\n%s", backend.State())
+	}
+
+	backend.SetState(remoteState)
+	if err := terraform.ReplaceState(context.Background(), tf, base, old); err != nil {
+		t.Fatalf("ReplaceState failed: %v", err)
+	}
+	if got := backend.State(); !strings.Contains(got, `"serial":4`) {
+		t.Errorf("replaced state does not supersede the current one:\n%s", got)
+	}
+}
@@ -1,61 +1,150 @@
 package terraform
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/go-version"
 	"github.com/hashicorp/terraform-exec/tfexec"
+	tfjson "github.com/hashicorp/terraform-json"
 	"github.com/hrmsk66/terraformify/pkg/prop"
 )
 
-func FindExec(workingDir string) (*tfexec.Terraform, error) {
-	execPath, err := exec.LookPath("terraform")
+// ImportMode represents how resources are brought under Terraform management
+type ImportMode int
+
+const (
+	// LegacyImport runs "terraform import" one resource at a time and reads the state back with "terraform show".
+	// Only Terraform 1.4.5 or earlier prints the state in a form that can be parsed as HCL.
+	LegacyImport ImportMode = iota
+	// ConfigDrivenImport writes import blocks and lets "terraform plan -generate-config-out" write the configuration.
+	// Requires Terraform 1.5.0 or later.
+	ConfigDrivenImport
+)
+
+// Engine is the CLI that runs Terraform configurations
+type Engine string
+
+const (
+	Terraform Engine = "Terraform"
+	OpenTofu  Engine = "OpenTofu"
+)
+
+type compatibleVersion struct {
+	constraint string
+	mode       ImportMode
+}
+
+// compatibility lists the supported versions of each engine and the import mode used with them.
+// OpenTofu was forked after "terraform show" stopped printing the state as parsable HCL, so only the config-driven import works with it.
+var compatibility = map[Engine][]compatibleVersion{
+	Terraform: {
+		{"<= 1.4.5", LegacyImport},
+		{">= 1.5.0", ConfigDrivenImport},
+	},
+	OpenTofu: {
+		{">= 1.6.0", ConfigDrivenImport},
+	},
+}
+
+// The file names are fixed so that leftovers from an aborted run are easy to spot
+const generatedConfigFile = "terraformify_generated.tf"
+const planFile = "terraformify.tfplan"
+
+// GeneratedConfig holds the outputs of "terraform plan -generate-config-out"
+type GeneratedConfig struct {
+	// HCL is the content of the generated configuration file
+	HCL string
+	// Plan is the saved plan rendered by "terraform show -json"
+	Plan *tfjson.Plan
+	// PlanFile is the path to the saved plan. Applying it imports the resources into the state.
+	PlanFile string
+}
+
+// FindExec returns a Runner for the executable at execPath, or looks up "terraform" and then "tofu" on PATH if execPath is empty.
+// Each command run by the Runner is stopped after the timeout. Zero means no limit.
+func FindExec(workingDir, execPath string, timeout time.Duration) (Runner, error) {
+	if execPath == "" {
+		var err error
+		for _, name := range []string{"terraform", "tofu"} {
+			execPath, err = exec.LookPath(name)
+			if err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return nil, errors.New("neither terraform nor tofu is found in PATH. specify the executable with --tf-binary")
+		}
+	}
+
+	tf, err := tfexec.NewTerraform(workingDir, execPath)
 	if err != nil {
 		return nil, err
 	}
-
-	return tfexec.NewTerraform(workingDir, execPath)
+	return &execRunner{tf: tf, timeout: timeout}, nil
 }
 
-func Init(tf *tfexec.Terraform) error {
-	return tf.Init(context.Background(), tfexec.Upgrade(true))
+func Init(ctx context.Context, tf Runner) error {
+	return tf.Init(ctx)
 }
 
-func Version(tf *tfexec.Terraform) error {
-	tfver, providerVers, err := tf.Version(context.Background(), true)
+// Version checks the engine and its version and returns the import mode that works with them
+func Version(ctx context.Context, tf Runner) (ImportMode, error) {
+	engine, err := tf.Engine(ctx)
 	if err != nil {
-		return err
+		return LegacyImport, err
 	}
 
-	// Check if the version is compatible with 1.4.5 or earlier
-	compatibleVersion, err := version.NewConstraint("<= 1.4.5")
+	// OpenTofu reports its own version as "terraform_version" in "tofu version -json"
+	tfver, providerVers, err := tf.Version(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to parse version constraint: %s", err)
+		return LegacyImport, err
 	}
 
 	currentVersion, err := version.NewVersion(tfver.String())
 	if err != nil {
-		return fmt.Errorf("failed to parse current Terraform version: %s", err)
+		return LegacyImport, fmt.Errorf("failed to parse current %s version: %s", engine, err)
 	}
 
-	if !compatibleVersion.Check(currentVersion) {
-		return fmt.Errorf("incompatible Terraform version: %s. Terraform version must be 1.4.5 or earlier", currentVersion)
+	mode, err := importModeFor(engine, currentVersion)
+	if err != nil {
+		return LegacyImport, err
 	}
 
-	log.Printf("[INFO] Terraform version: %s on %s_%s", currentVersion, runtime.GOOS, runtime.GOARCH)
+	log.Printf("[INFO] %s version: %s on %s_%s", engine, currentVersion, runtime.GOOS, runtime.GOARCH)
 	for k, v := range providerVers {
 		log.Printf("[INFO] Provider version: %s %s", k, v.String())
 	}
-	return nil
+	return mode, nil
+}
+
+func importModeFor(engine Engine, v *version.Version) (ImportMode, error) {
+	var supported []string
+	for _, cv := range compatibility[engine] {
+		c, err := version.NewConstraint(cv.constraint)
+		if err != nil {
+			return LegacyImport, fmt.Errorf("failed to parse version constraint: %s", err)
+		}
+		if c.Check(v) {
+			return cv.mode, nil
+		}
+		supported = append(supported, cv.constraint)
+	}
+
+	return LegacyImport, fmt.Errorf("incompatible %s version: %s. %s version must be %s", engine, v, engine, strings.Join(supported, " or "))
 }
 
-func Import(tf *tfexec.Terraform, p prop.TFBlock, f io.Writer) error {
+func Import(ctx context.Context, tf Runner, p prop.TFBlock, f io.Writer) error {
 	// Add the empty resource block to the file
 	_, err := fmt.Fprintf(f, "resource \"%s\" \"%s\" {}\n", p.GetType(), p.GetNormalizedName())
 	if err != nil {
@@ -64,18 +153,39 @@ func Import(tf *tfexec.Terraform, p prop.TFBlock, f io.Writer) error {
 
 	log.Printf(`[INFO] Running "terraform import" on %s`, p.GetRef())
 	// Run "terraform import"
-	if err := tf.Import(context.Background(), p.GetRef(), p.GetIDforTFImport()); err != nil {
+	if err := tf.Import(ctx, p.GetRef(), p.GetIDforTFImport()); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// ImportBlock adds an import block for the resource to the file.
+// Unlike Import, nothing is imported until the plan generated by GenerateConfig is applied.
+func ImportBlock(p prop.TFBlock, f io.Writer) error {
+	log.Printf(`[INFO] Adding an import block for %s`, p.GetRef())
+	_, err := fmt.Fprintf(f, "import {\n  to = %s\n  id = %q\n}\n", p.GetRef(), p.GetIDforTFImport())
+	return err
+}
+
+// ImportBlocks adds the import blocks for all the resources to the file at once,
+// so that they are imported by a single plan instead of one "terraform import" per resource.
+func ImportBlocks(props []prop.TFBlock, f io.Writer) error {
+	var buf bytes.Buffer
+	for _, p := range props {
+		if err := ImportBlock(p, &buf); err != nil {
+			return err
+		}
+	}
+	_, err := f.Write(buf.Bytes())
+	return err
+}
+
 // RecursiveImport attempts to import resources specified in the resource_link block of fastly_service_compute.
 // As the resource_link lacks resource type information, this function iteratively tries to import using different
 // resource types until it succeeds.
-func RecursiveImport(tf *tfexec.Terraform, p prop.MutatableTfBlock, f io.Writer) error {
-	err := Import(tf, p, f)
+func RecursiveImport(ctx context.Context, tf Runner, p prop.MutatableTfBlock, f io.Writer) error {
+	err := Import(ctx, tf, p, f)
 
 	if err != nil {
 		// Importing non-existent data stores leads to varying outcomes based on their type:
@@ -84,22 +194,153 @@ func RecursiveImport(tf *tfexec.Terraform, p prop.MutatableTfBlock, f io.Writer)
 		// - Surprisingly, a terraform import may succeed for a non-existent fastly_kvstore
 		// To prevent erroneous state entries from non-existent resources, TFBlockProp.LinkedResource sequentially tries to import as:
 		// "fastly_configstore" => "fastly_secretstore" => "fastly_kvstore".
-		if strings.Contains(err.Error(), "Cannot import non-existent remote object") || strings.Contains(err.Error(), "404 - Not Found") {
+		if isNotFound(err) {
 			if mutateErr := p.MutateType(); mutateErr != nil {
 				return mutateErr
 			}
 			log.Printf(`[INFO] - not found, retry with "%s"`, p.GetRef())
-			return RecursiveImport(tf, p, f)
+			return RecursiveImport(ctx, tf, p, f)
 		}
 		return err
 	}
 	return nil
 }
 
-func Show(tf *tfexec.Terraform) (string, error) {
-	return tf.ShowPlanFileRaw(context.Background(), "terraform.tfstate")
+// RecursiveImportBlock is the config-driven counterpart of RecursiveImport.
+// Each candidate type is checked by planning its import block on its own before the block is added to the file.
+func RecursiveImportBlock(ctx context.Context, tf Runner, p prop.MutatableTfBlock, f io.Writer) error {
+	err := probeImport(ctx, tf, p)
+
+	if err != nil {
+		// See RecursiveImport for the order in which the types are tried
+		if isNotFound(err) {
+			if mutateErr := p.MutateType(); mutateErr != nil {
+				return mutateErr
+			}
+			log.Printf(`[INFO] - not found, retry with "%s"`, p.GetRef())
+			return RecursiveImportBlock(ctx, tf, p, f)
+		}
+		return err
+	}
+	return ImportBlock(p, f)
+}
+
+func probeImport(ctx context.Context, tf Runner, p prop.TFBlock) (err error) {
+	probef, err := os.CreateTemp(tf.WorkingDir(), "probe*.tf")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err1 := os.Remove(probef.Name()); err1 != nil && err == nil {
+			err = err1
+		}
+	}()
+
+	err = ImportBlock(p, probef)
+	if err1 := probef.Close(); err1 != nil && err == nil {
+		err = err1
+	}
+	if err != nil {
+		return err
+	}
+
+	g, err := GenerateConfig(ctx, tf)
+	if err != nil {
+		return err
+	}
+	return os.Remove(g.PlanFile)
+}
+
+func isNotFound(err error) bool {
+	return strings.Contains(err.Error(), "Cannot import non-existent remote object") || strings.Contains(err.Error(), "404 - Not Found")
+}
+
+func Show(ctx context.Context, tf Runner) (string, error) {
+	return tf.ShowState(ctx)
+}
+
+// GenerateConfig runs "terraform plan -generate-config-out" against the import blocks in the working directory.
+// The generated configuration file is removed once it has been read. The saved plan is left for the caller to apply or remove.
+func GenerateConfig(ctx context.Context, tf Runner) (*GeneratedConfig, error) {
+	workingDir, err := filepath.Abs(tf.WorkingDir())
+	if err != nil {
+		return nil, err
+	}
+	genPath := filepath.Join(workingDir, generatedConfigFile)
+	planPath := filepath.Join(workingDir, planFile)
+
+	if _, err := os.Stat(genPath); !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("terraform: %s already exists. remove the file left by the previous run and try again", genPath)
+	}
+
+	log.Print(`[INFO] Running "terraform plan -generate-config-out" to generate the configuration`)
+	runErr := tf.PlanGenerateConfig(ctx, generatedConfigFile, planFile)
+
+	hcl, readErr := os.ReadFile(genPath)
+	if readErr == nil {
+		if err := os.Remove(genPath); err != nil {
+			return nil, err
+		}
+	}
+	if runErr != nil {
+		return nil, runErr
+	}
+	if readErr != nil {
+		return nil, readErr
+	}
+
+	plan, err := tf.ShowPlanFile(ctx, planPath)
+	if err != nil {
+		if err1 := os.Remove(planPath); err1 != nil {
+			log.Printf("[WARN] failed to remove %s: %s", planPath, err1)
+		}
+		return nil, err
+	}
+
+	return &GeneratedConfig{
+		HCL:      string(hcl),
+		Plan:     plan,
+		PlanFile: planPath,
+	}, nil
+}
+
+// ApplyPlan applies the saved plan created by GenerateConfig. The plan covers the whole configuration,
+// so it is refused unless the only thing it does is importing the resources: applying drift in the generated configuration
+// or a change pending in the working directory would modify the live service.
+func ApplyPlan(ctx context.Context, tf Runner, planFile string) error {
+	plan, err := tf.ShowPlanFile(ctx, planFile)
+	if err != nil {
+		return err
+	}
+	if err := checkImportOnly(plan); err != nil {
+		return err
+	}
+	return tf.Apply(ctx, planFile)
+}
+
+// checkImportOnly returns an error if the plan makes any change other than importing the resources with no changes
+func checkImportOnly(plan *tfjson.Plan) error {
+	var changes []string
+	for _, rc := range plan.ResourceChanges {
+		if rc.Change == nil {
+			continue
+		}
+		if rc.Change.Actions.NoOp() || (rc.Mode == tfjson.DataResourceMode && rc.Change.Actions.Read()) {
+			continue
+		}
+		action := "change"
+		if rc.Change.Importing != nil {
+			action = "import with changes"
+		}
+		changes = append(changes, fmt.Sprintf("%s (%s: %v)", rc.Address, action, rc.Change.Actions))
+	}
+	if len(changes) > 0 {
+		return fmt.Errorf("terraform: refused to apply the plan as it does more than importing the resources: %s. "+
+			"resolve the changes with \"terraform plan\" and try again", strings.Join(changes, ", "))
+	}
+	return nil
 }
 
-func Refresh(tf *tfexec.Terraform) error {
-	return tf.Refresh(context.Background())
+func Refresh(ctx context.Context, tf Runner) error {
+	return tf.Refresh(ctx)
 }
@@ -0,0 +1,71 @@
+package terraform
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-version"
+	tfjson "github.com/hashicorp/terraform-json"
+)
+
+func TestImportModeFor(t *testing.T) {
+	testCases := []struct {
+		engine  Engine
+		version string
+		mode    ImportMode
+		wantErr bool
+	}{
+		{Terraform, "1.3.9", LegacyImport, false},
+		{Terraform, "1.4.5", LegacyImport, false},
+		{Terraform, "1.4.6", LegacyImport, true},
+		{Terraform, "1.5.0", ConfigDrivenImport, false},
+		{Terraform, "1.9.8", ConfigDrivenImport, false},
+		{OpenTofu, "1.5.0", LegacyImport, true},
+		{OpenTofu, "1.6.0", ConfigDrivenImport, false},
+		{OpenTofu, "1.8.3", ConfigDrivenImport, false},
+	}
+
+	for _, tc := range testCases {
+		mode, err := importModeFor(tc.engine, version.Must(version.NewVersion(tc.version)))
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s %s: unexpected error: %v", tc.engine, tc.version, err)
+			continue
+		}
+		if err == nil && mode != tc.mode {
+			t.Errorf("%s %s: expected mode %d, got %d", tc.engine, tc.version, tc.mode, mode)
+		}
+	}
+}
+
+func TestCheckImportOnly(t *testing.T) {
+	change := func(address string, mode tfjson.ResourceMode, importing bool, actions ...tfjson.Action) *tfjson.ResourceChange {
+		rc := &tfjson.ResourceChange{Address: address, Mode: mode, Change: &tfjson.Change{Actions: actions}}
+		if importing {
+			rc.Change.Importing = &tfjson.Importing{ID: "id"}
+		}
+		return rc
+	}
+
+	plan := &tfjson.Plan{ResourceChanges: []*tfjson.ResourceChange{
+		change("fastly_service_vcl.service", tfjson.ManagedResourceMode, true, tfjson.ActionNoop),
+		change("fastly_service_vcl.other", tfjson.ManagedResourceMode, false, tfjson.ActionNoop),
+		change("data.fastly_ip_ranges.ranges", tfjson.DataResourceMode, false, tfjson.ActionRead),
+	}}
+	if err := checkImportOnly(plan); err != nil {
+		t.Errorf("checkImportOnly failed on a plan importing the resources alone: %v", err)
+	}
+
+	plan.ResourceChanges = append(plan.ResourceChanges,
+		change("fastly_service_acl_entries.allow_list", tfjson.ManagedResourceMode, true, tfjson.ActionUpdate),
+		change("fastly_service_vcl.other", tfjson.ManagedResourceMode, false, tfjson.ActionDelete, tfjson.ActionCreate),
+	)
+	err := checkImportOnly(plan)
+	if err == nil {
+		t.Fatal("checkImportOnly succeeded on a plan with changes")
+	}
+	for _, expected := range []string{"fastly_service_acl_entries.allow_list (import with changes: [update])", "fastly_service_vcl.other (change: [delete create])"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("error %q does not contain %q", err, expected)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package terraformtest
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+)
+
+// HTTPBackend is a stand-in for a remote backend.
+// It speaks the protocol of Terraform's "http" backend: GET returns the stored state and POST replaces it.
+type HTTPBackend struct {
+	*httptest.Server
+
+	mu    sync.Mutex
+	state []byte
+}
+
+// NewHTTPBackend starts a backend holding the state. Call Close when done.
+func NewHTTPBackend(state string) *HTTPBackend {
+	b := &HTTPBackend{state: []byte(state)}
+	b.Server = httptest.NewServer(http.HandlerFunc(b.serveHTTP))
+	return b
+}
+
+// State returns the stored state
+func (b *HTTPBackend) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.state)
+}
+
+// SetState replaces the stored state, as another run writing to the same backend would
+func (b *HTTPBackend) SetState(state string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = []byte(state)
+}
+
+func (b *HTTPBackend) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		state := b.State()
+		if state == "" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = io.WriteString(w, state)
+	case http.MethodPost:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		b.SetState(string(body))
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,425 @@
+// Package terraformtest provides a scripted terraform.Runner for testing the import flows without a Terraform binary.
+package terraformtest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/go-version"
+	tfjson "github.com/hashicorp/terraform-json"
+	"github.com/hrmsk66/terraformify/pkg/terraform"
+)
+
+var ErrNoRecording = errors.New("terraformtest: no more recordings")
+
+// Plan is a recorded "terraform plan -generate-config-out" run
+type Plan struct {
+	// GeneratedConfig is written to the generated configuration file
+	GeneratedConfig string
+	// JSON is the saved plan as rendered by "terraform show -json"
+	JSON string
+	// Err makes the plan fail without writing any file
+	Err error
+}
+
+// Runner replays recorded outputs instead of running Terraform.
+// Each recording is consumed in order by the command that produces it.
+type Runner struct {
+	Dir        string
+	EngineName terraform.Engine
+	TFVersion  string
+
+	// Backend is the address of an HTTPBackend. If empty, the state is stored in terraform.tfstate in Dir,
+	// or terraform.tfstate.d/<workspace>/terraform.tfstate for a workspace other than "default".
+	// Init switches to the local backend if it finds terraform.LocalBackendOverrideFile in Dir.
+	Backend string
+	// Workspaces lists the workspaces besides "default"
+	Workspaces []string
+	// Workspace is the selected workspace. Empty means "default".
+	Workspace string
+
+	// ImportErrors maps a resource address to the error "terraform import" fails with
+	ImportErrors map[string]error
+	// States are written to the backend by each successful Import and Apply
+	States []string
+	// ShowOutputs are returned by ShowState
+	ShowOutputs []string
+	// Plans are replayed by PlanGenerateConfig
+	Plans []Plan
+	// VerifyPlans are the saved plans written by each PlanJSON, as rendered by "terraform show -json".
+	// Without a recording, the plan has no changes.
+	VerifyPlans []string
+	// Schemas is the output of "terraform providers schema -json". Without a recording, no provider schema is found.
+	Schemas string
+
+	// Calls records the commands run, such as "import fastly_service_vcl.service 7ManTUgtlSytxeXRMPYY33"
+	Calls []string
+	// Hook is called with each command before it is run. If it returns an error, the command fails with it.
+	Hook func(call string) error
+}
+
+var _ terraform.Runner = (*Runner)(nil)
+
+func (r *Runner) WorkingDir() string {
+	return r.Dir
+}
+
+func (r *Runner) Engine(ctx context.Context) (terraform.Engine, error) {
+	if r.EngineName == "" {
+		return terraform.Terraform, nil
+	}
+	return r.EngineName, nil
+}
+
+func (r *Runner) Version(ctx context.Context) (*version.Version, map[string]*version.Version, error) {
+	if err := r.call(ctx, "version"); err != nil {
+		return nil, nil, err
+	}
+	v, err := version.NewVersion(r.TFVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+	return v, map[string]*version.Version{}, nil
+}
+
+func (r *Runner) Init(ctx context.Context) error {
+	if err := r.call(ctx, "init"); err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(r.Dir, terraform.LocalBackendOverrideFile)); err == nil {
+		r.Backend = ""
+	}
+	return nil
+}
+
+func (r *Runner) WorkspaceList(ctx context.Context) ([]string, string, error) {
+	if err := r.call(ctx, "workspace list"); err != nil {
+		return nil, "", err
+	}
+	current := r.Workspace
+	if current == "" {
+		current = "default"
+	}
+	return append([]string{"default"}, r.Workspaces...), current, nil
+}
+
+func (r *Runner) WorkspaceSelect(ctx context.Context, workspace string) error {
+	if err := r.call(ctx, "workspace select "+workspace); err != nil {
+		return err
+	}
+	for _, w := range append([]string{"default"}, r.Workspaces...) {
+		if w == workspace {
+			r.Workspace = workspace
+			return nil
+		}
+	}
+	return fmt.Errorf("workspace %q doesn't exist", workspace)
+}
+
+// WorkspaceNew fails with a Backend, as the "http" backend does not support workspaces
+func (r *Runner) WorkspaceNew(ctx context.Context, workspace string) error {
+	if err := r.call(ctx, "workspace new "+workspace); err != nil {
+		return err
+	}
+	if r.Backend != "" {
+		return errors.New("workspaces not supported")
+	}
+	for _, w := range r.Workspaces {
+		if w == workspace {
+			return fmt.Errorf("workspace %q already exists", workspace)
+		}
+	}
+	r.Workspaces = append(r.Workspaces, workspace)
+	r.Workspace = workspace
+	return nil
+}
+
+// WorkspaceDelete fails if the workspace is selected or its state has any resources, as Terraform does without -force
+func (r *Runner) WorkspaceDelete(ctx context.Context, workspace string) error {
+	if err := r.call(ctx, "workspace delete "+workspace); err != nil {
+		return err
+	}
+	if workspace == "default" || workspace == r.Workspace {
+		return fmt.Errorf("workspace %q cannot be deleted", workspace)
+	}
+	for i, w := range r.Workspaces {
+		if w != workspace {
+			continue
+		}
+		p := filepath.Join(r.Dir, "terraform.tfstate.d", workspace)
+		b, err := os.ReadFile(filepath.Join(p, "terraform.tfstate"))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if len(b) > 0 {
+			var s struct {
+				Resources []json.RawMessage `json:"resources"`
+			}
+			if err := json.Unmarshal(b, &s); err != nil {
+				return err
+			}
+			if len(s.Resources) > 0 {
+				return fmt.Errorf("workspace %q is not empty", workspace)
+			}
+		}
+		r.Workspaces = append(r.Workspaces[:i], r.Workspaces[i+1:]...)
+		return os.RemoveAll(p)
+	}
+	return fmt.Errorf("workspace %q doesn't exist", workspace)
+}
+
+func (r *Runner) Import(ctx context.Context, address, id string) error {
+	if err := r.call(ctx, fmt.Sprintf("import %s %s", address, id)); err != nil {
+		return err
+	}
+	if err, ok := r.ImportErrors[address]; ok {
+		return err
+	}
+	return r.writeState()
+}
+
+func (r *Runner) ShowState(ctx context.Context) (string, error) {
+	if err := r.call(ctx, "show"); err != nil {
+		return "", err
+	}
+	if len(r.ShowOutputs) == 0 {
+		return "", fmt.Errorf("%w: show", ErrNoRecording)
+	}
+	out := r.ShowOutputs[0]
+	r.ShowOutputs = r.ShowOutputs[1:]
+	return out, nil
+}
+
+func (r *Runner) PlanGenerateConfig(ctx context.Context, generatedConfigFile, planFile string) error {
+	if err := r.call(ctx, "plan"); err != nil {
+		return err
+	}
+	if len(r.Plans) == 0 {
+		return fmt.Errorf("%w: plan", ErrNoRecording)
+	}
+	p := r.Plans[0]
+	r.Plans = r.Plans[1:]
+	if p.Err != nil {
+		return p.Err
+	}
+
+	if err := os.WriteFile(filepath.Join(r.Dir, generatedConfigFile), []byte(p.GeneratedConfig), 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(r.Dir, planFile), []byte(p.JSON), 0644)
+}
+
+func (r *Runner) ShowPlanFile(ctx context.Context, planFile string) (*tfjson.Plan, error) {
+	if err := r.call(ctx, "show -json"); err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(planFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var plan tfjson.Plan
+	if err := json.Unmarshal(b, &plan); err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// PlanJSON writes the recorded plan and renders its resource changes as "planned_change" messages
+func (r *Runner) PlanJSON(ctx context.Context, planFile string) (string, bool, error) {
+	if err := r.call(ctx, "plan -json"); err != nil {
+		return "", false, err
+	}
+	p := `{"format_version":"1.2","resource_changes":[]}`
+	if len(r.VerifyPlans) > 0 {
+		p = r.VerifyPlans[0]
+		r.VerifyPlans = r.VerifyPlans[1:]
+	}
+	if err := os.WriteFile(filepath.Join(r.Dir, planFile), []byte(p), 0644); err != nil {
+		return "", false, err
+	}
+
+	var plan tfjson.Plan
+	if err := json.Unmarshal([]byte(p), &plan); err != nil {
+		return "", false, err
+	}
+	var out strings.Builder
+	changes := false
+	for _, rc := range plan.ResourceChanges {
+		if rc.Change == nil || rc.Change.Actions.NoOp() || rc.Change.Actions.Read() {
+			continue
+		}
+		changes = true
+		action := string(rc.Change.Actions[0])
+		if rc.Change.Actions.Replace() {
+			action = "replace"
+		}
+		msg := map[string]interface{}{
+			"@level":   "info",
+			"@message": fmt.Sprintf("%s: Plan to %s", rc.Address, action),
+			"type":     "planned_change",
+			"change": map[string]interface{}{
+				"resource": map[string]interface{}{"addr": rc.Address, "resource_type": rc.Type, "resource_name": rc.Name},
+				"action":   action,
+			},
+		}
+		b, err := json.Marshal(msg)
+		if err != nil {
+			return "", false, err
+		}
+		out.Write(b)
+		out.WriteByte('\n')
+	}
+	return out.String(), changes, nil
+}
+
+func (r *Runner) ProvidersSchema(ctx context.Context) (*tfjson.ProviderSchemas, error) {
+	if err := r.call(ctx, "providers schema"); err != nil {
+		return nil, err
+	}
+	s := r.Schemas
+	if s == "" {
+		s = `{"format_version":"1.0"}`
+	}
+
+	var schemas tfjson.ProviderSchemas
+	if err := json.Unmarshal([]byte(s), &schemas); err != nil {
+		return nil, err
+	}
+	return &schemas, nil
+}
+
+func (r *Runner) Apply(ctx context.Context, planFile string) error {
+	if err := r.call(ctx, "apply"); err != nil {
+		return err
+	}
+	if _, err := os.Stat(planFile); err != nil {
+		return err
+	}
+	return r.writeState()
+}
+
+func (r *Runner) Refresh(ctx context.Context) error {
+	if err := r.call(ctx, "refresh"); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Runner) StatePull(ctx context.Context) (string, error) {
+	if err := r.call(ctx, "state pull"); err != nil {
+		return "", err
+	}
+	return r.readState()
+}
+
+// StatePush refuses to overwrite a state with a different lineage or a newer serial, as "terraform state push" does
+func (r *Runner) StatePush(ctx context.Context, path string) error {
+	if err := r.call(ctx, "state push"); err != nil {
+		return err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	cur, err := r.readState()
+	if err != nil {
+		return err
+	}
+	if cur != "" {
+		var src, dst struct {
+			Lineage string `json:"lineage"`
+			Serial  int64  `json:"serial"`
+		}
+		if err := json.Unmarshal(b, &src); err != nil {
+			return err
+		}
+		if err := json.Unmarshal([]byte(cur), &dst); err != nil {
+			return err
+		}
+		if src.Lineage != dst.Lineage {
+			return fmt.Errorf("cannot import state with lineage %q over unrelated state with lineage %q", src.Lineage, dst.Lineage)
+		}
+		if src.Serial < dst.Serial {
+			return fmt.Errorf("cannot import state with serial %d over newer state with serial %d", src.Serial, dst.Serial)
+		}
+	}
+	return r.storeState(string(b))
+}
+
+func (r *Runner) writeState() error {
+	if len(r.States) == 0 {
+		return nil
+	}
+	s := r.States[0]
+	r.States = r.States[1:]
+	return r.storeState(s)
+}
+
+func (r *Runner) readState() (string, error) {
+	if r.Backend == "" {
+		b, err := os.ReadFile(r.statePath())
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+		return string(b), err
+	}
+
+	resp, err := http.Get(r.Backend)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", nil
+	}
+	b, err := io.ReadAll(resp.Body)
+	return string(b), err
+}
+
+func (r *Runner) storeState(s string) error {
+	if r.Backend == "" {
+		p := r.statePath()
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return err
+		}
+		return os.WriteFile(p, []byte(s), 0644)
+	}
+
+	resp, err := http.Post(r.Backend, "application/json", strings.NewReader(s))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("terraformtest: backend returned %s", resp.Status)
+	}
+	return nil
+}
+
+// statePath returns where the local backend stores the state of the selected workspace
+func (r *Runner) statePath() string {
+	if r.Workspace == "" || r.Workspace == "default" {
+		return filepath.Join(r.Dir, "terraform.tfstate")
+	}
+	return filepath.Join(r.Dir, "terraform.tfstate.d", r.Workspace, "terraform.tfstate")
+}
+
+// call records the command and fails it if the context is done, as a killed process would
+func (r *Runner) call(ctx context.Context, call string) error {
+	r.Calls = append(r.Calls, call)
+	if r.Hook != nil {
+		if err := r.Hook(call); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
@@ -1,189 +1,246 @@
 package tfconf
 
 import (
-	"bufio"
 	"bytes"
 	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
 )
 
+// cleanupResourceTypes are the resource blocks kept by cleanupHCL
+var cleanupResourceTypes = map[string]bool{
+	"fastly_service_acl_entries":             true,
+	"fastly_service_compute":                 true,
+	"fastly_service_dictionary_items":        true,
+	"fastly_service_dynamic_snippet_content": true,
+	"fastly_service_vcl":                     true,
+	"fastly_service_waf_configuration":       true,
+	"fastly_configstore":                     true,
+	"fastly_configstore_entries":             true,
+	"fastly_secretstore":                     true,
+	"fastly_kvstore":                         true,
+}
+
+// cleanupHCL extracts the supported resource blocks from the "terraform show" output, which is not valid HCL as a whole.
+// Outside the blocks, the output has comments naming the resources, the outputs and the blocks of other providers, which are dropped.
+// In the blocks, the sensitive values are shown as "(sensitive value)" and the contents of VCL, snippets and log formats
+// may not parse, such as a log format with "%{...}V" in jsonencode(). These values are replaced with an empty string,
+// as RewriteResources reads them from the state instead.
+//
+// The output is read as tokens by the hclsyntax lexer, so that heredocs with any delimiter, templates and
+// braces in strings are told apart from the nested blocks. The blocks are kept as they are written otherwise.
 func cleanupHCL(rawHCL string) string {
+	src := []byte(rawHCL)
+	tokens, _ := hclsyntax.LexConfig(src, "", hcl.Pos{Line: 1, Column: 1})
+	c := &cleaner{src: src, tokens: tokens}
+
 	var buf bytes.Buffer
-	scanner := bufio.NewScanner(strings.NewReader(rawHCL))
-
-	var blocks []string
-	var eot string
-	var skip bool
-
-	// Helper function to handle multiline strings
-	handleMultilineStrings := func(t string) {
-		switch {
-		case strings.HasSuffix(t, "EOT"):
-			skip = true
-			eot = "EOT"
-		case strings.HasSuffix(t, "jsonencode("):
-			skip = true
-			eot = ")"
+	for c.tokens[c.pos].Type != hclsyntax.TokenEOF {
+		switch c.tokens[c.pos].Type {
+		case hclsyntax.TokenNewline, hclsyntax.TokenComment:
+			c.pos++
+			continue
 		}
-	}
-
-	// Helper function to truncate attribute values
-	truncateValue := func(t string) string {
-		before, _, _ := strings.Cut(t, "=")
-		return before + `= ""`
-	}
 
-	// Helper function to check if a given block is a supported Terraform resource block
-	isSupportedResourceBlock := func(block string) bool {
-		supportedBlocks := []string{
-			"fastly_service_acl_entries",
-			"fastly_service_compute",
-			"fastly_service_dictionary_items",
-			"fastly_service_dynamic_snippet_content",
-			"fastly_service_vcl",
-			"fastly_service_waf_configuration",
-			"fastly_configstore",
-			"fastly_configstore_entries",
-			"fastly_secretstore",
-			"fastly_kvstore",
+		start := c.pos
+		block, ok := c.resourceBlock()
+		if !ok {
+			// Skip anything else up to the end of the line, including the blocks of other resources
+			c.pos = start
+			if c.expression(); c.pos == start {
+				c.pos++
+			}
+			continue
 		}
 
-		for _, supportedBlock := range supportedBlocks {
-			if strings.HasPrefix(block, supportedBlock) {
-				return true
-			}
+		if buf.Len() > 0 {
+			buf.WriteString("\n")
 		}
-		return false
+		c.write(&buf, block)
+		buf.WriteString("\n")
 	}
+	return buf.String()
+}
 
-	for scanner.Scan() {
-		text := scanner.Text()
-		trimedText := strings.TrimSpace(scanner.Text())
+// cleaner reads the tokens of the "terraform show" output
+type cleaner struct {
+	src    []byte
+	tokens hclsyntax.Tokens
+	pos    int
+}
 
-		// Skip lines until the end of the multiline string is found
-		if skip {
-			if trimedText == eot {
-				skip = false
-			}
-			continue
-		}
+// cleanupBlock is a supported resource block in the source
+type cleanupBlock struct {
+	// start and end are the offsets of the block in the source, from the beginning of its first line to the closing brace
+	start, end int
+	// emptied are the values to replace with an empty string
+	emptied []hcl.Range
+}
 
-		// Check for empty line and preserve it
-		if trimedText == "" {
-			buf.WriteString(text + "\n")
-			continue
-		}
+// resourceBlock reads the resource block at the position if it is of a supported type
+func (c *cleaner) resourceBlock() (*cleanupBlock, bool) {
+	first := c.tokens[c.pos]
+	if first.Type != hclsyntax.TokenIdent || string(first.Bytes) != "resource" {
+		return nil, false
+	}
+	c.pos++
+	resourceType, ok := c.label()
+	if !ok || !cleanupResourceTypes[resourceType] {
+		return nil, false
+	}
+	if _, ok := c.label(); !ok || c.tokens[c.pos].Type != hclsyntax.TokenOBrace {
+		return nil, false
+	}
+	c.pos++
+
+	block := &cleanupBlock{start: bytes.LastIndexByte(c.src[:first.Range.Start.Byte], '\n') + 1}
+	if c.body(block, []string{resourceType}) {
+		block.end = c.tokens[c.pos-1].Range.End.Byte
+	} else {
+		// Keep the rest of an unterminated block, so that the parser reports it
+		block.end = len(c.src)
+	}
+	return block, true
+}
 
-		// Check for the opening bracket of a block
-		if strings.HasSuffix(trimedText, "{") {
-			switch len(blocks) {
-			case 0:
-				// If we're not in a block, check if it's a supported resource block
-				if strings.HasPrefix(trimedText, "resource") {
-					b := strings.Fields(trimedText)[1]
-					b = strings.Trim(b, "\"")
-					if isSupportedResourceBlock(b) {
-						blocks = append(blocks, b)
-					}
-				}
-			case 1:
-				// If we're inside a resource block, check if the block needs a special handling
-				switch {
-				case strings.HasPrefix(trimedText, "backend"):
-					blocks = append(blocks, "backend")
-				case strings.HasPrefix(trimedText, "rate_limiter"):
-					blocks = append(blocks, "rate_limiter")
-				case strings.HasPrefix(trimedText, "response_object"):
-					blocks = append(blocks, "response_object")
-				case strings.HasPrefix(trimedText, "snippet"):
-					blocks = append(blocks, "snippet")
-				case strings.HasPrefix(trimedText, "vcl"):
-					blocks = append(blocks, "vcl")
-				case strings.HasPrefix(trimedText, "logging_"):
-					blocks = append(blocks, "logging")
-				default:
-					blocks = append(blocks, "other")
-				}
-			case 2:
-				// If we're inside "rate_limiter" block, check if it's a "response" block
-				if blocks[len(blocks)-1] == "rate_limiter" && strings.HasPrefix(trimedText, "response ") {
-					blocks = append(blocks, "response")
-				}
-			}
-		}
+// label reads a block label, which is a quoted string or an identifier
+func (c *cleaner) label() (string, bool) {
+	t := c.tokens[c.pos]
+	if t.Type == hclsyntax.TokenIdent {
+		c.pos++
+		return string(t.Bytes), true
+	}
+	if t.Type != hclsyntax.TokenOQuote {
+		return "", false
+	}
+
+	var label string
+	if c.tokens[c.pos+1].Type == hclsyntax.TokenQuotedLit {
+		label = string(c.tokens[c.pos+1].Bytes)
+		c.pos++
+	}
+	if c.tokens[c.pos+1].Type != hclsyntax.TokenCQuote {
+		return "", false
+	}
+	c.pos += 2
+	return label, true
+}
 
-		// Continue if we're not inside a supported Terraform resource block
-		if len(blocks) == 0 {
+// body reads the attributes and the nested blocks up to the closing brace of the block at the path,
+// such as fastly_service_vcl.rate_limiter.response. It reports false if the source ends first.
+func (c *cleaner) body(block *cleanupBlock, path []string) bool {
+	for {
+		t := c.tokens[c.pos]
+		switch t.Type {
+		case hclsyntax.TokenEOF:
+			return false
+		case hclsyntax.TokenCBrace:
+			c.pos++
+			return true
+		case hclsyntax.TokenNewline, hclsyntax.TokenComment:
+			c.pos++
 			continue
 		}
 
-		// If we find a closing bracket, remove the current block from the list
-		if trimedText == "}" {
-			blocks = blocks[:len(blocks)-1]
+		start := c.pos
+		if t.Type == hclsyntax.TokenIdent && c.tokens[c.pos+1].Type == hclsyntax.TokenEqual {
+			c.pos += 2
+			valueStart := c.pos
+			c.expression()
+			if value := c.tokens[valueStart:c.pos]; len(value) > 0 && (emptiedAttribute(path, string(t.Bytes)) || isSensitiveValue(value)) {
+				block.emptied = append(block.emptied, hcl.RangeBetween(value[0].Range, value[len(value)-1].Range))
+			}
+			continue
 		}
 
-		// Special handling for nested blocks
-		switch len(blocks) {
-		case 1:
-			if blocks[len(blocks)-1] == "fastly_service_dynamic_snippet_content" {
-				switch {
-				case strings.HasPrefix(trimedText, "content "):
-					handleMultilineStrings(trimedText)
-					text = truncateValue(text)
+		if t.Type == hclsyntax.TokenIdent {
+			c.pos++
+			for {
+				if _, ok := c.label(); !ok {
+					break
 				}
 			}
-		case 2:
-			if blocks[len(blocks)-1] == "backend" {
-				switch {
-				case strings.HasSuffix(trimedText, "(sensitive value)"):
-					text = truncateValue(text)
-				}
-			}
-
-			if blocks[len(blocks)-1] == "response_object" {
-				switch {
-				case strings.HasPrefix(trimedText, "content "):
-					handleMultilineStrings(trimedText)
-					text = truncateValue(text)
+			if c.tokens[c.pos].Type == hclsyntax.TokenOBrace {
+				c.pos++
+				nested := append(append([]string(nil), path...), string(t.Bytes))
+				if !c.body(block, nested) {
+					return false
 				}
+				continue
 			}
+			c.pos = start
+		}
 
-			if blocks[len(blocks)-1] == "snippet" {
-				switch {
-				case strings.HasPrefix(trimedText, "content "):
-					handleMultilineStrings(trimedText)
-					text = truncateValue(text)
-				}
-			}
+		// Skip a line that is neither an attribute nor a block
+		if c.expression(); c.pos == start {
+			c.pos++
+		}
+	}
+}
 
-			if blocks[len(blocks)-1] == "vcl" {
-				switch {
-				case strings.HasPrefix(trimedText, "content "):
-					handleMultilineStrings(trimedText)
-					text = truncateValue(text)
-				}
+// expression moves the position to the end of the expression, which is the end of the line
+// or the closing brace of the block, outside of any brackets, parentheses and template sequences.
+// Heredocs and quoted strings are single tokens or sequences of template tokens, so the braces in them are not counted.
+func (c *cleaner) expression() {
+	depth := 0
+	for {
+		switch c.tokens[c.pos].Type {
+		case hclsyntax.TokenEOF:
+			return
+		case hclsyntax.TokenNewline, hclsyntax.TokenComment:
+			if depth == 0 {
+				return
 			}
-
-			if blocks[len(blocks)-1] == "logging" {
-				switch {
-				case strings.HasPrefix(trimedText, "format "):
-					handleMultilineStrings(trimedText)
-					text = truncateValue(text)
-				case strings.HasSuffix(trimedText, "(sensitive value)"):
-					text = truncateValue(text)
-				}
+		case hclsyntax.TokenOBrace, hclsyntax.TokenOBrack, hclsyntax.TokenOParen, hclsyntax.TokenTemplateInterp, hclsyntax.TokenTemplateControl:
+			depth++
+		case hclsyntax.TokenCBrace:
+			if depth == 0 {
+				return
 			}
-		case 3:
-			if blocks[len(blocks)-2] == "rate_limiter" && blocks[len(blocks)-1] == "response" {
-				switch {
-				case strings.HasPrefix(trimedText, "content "):
-					handleMultilineStrings(trimedText)
-					text = truncateValue(text)
-				}
+			depth--
+		case hclsyntax.TokenCBrack, hclsyntax.TokenCParen, hclsyntax.TokenTemplateSeqEnd:
+			if depth > 0 {
+				depth--
 			}
 		}
+		c.pos++
+	}
+}
 
-		buf.WriteString(text + "\n")
+// write writes the block with the values to be emptied replaced
+func (c *cleaner) write(buf *bytes.Buffer, block *cleanupBlock) {
+	offset := block.start
+	for _, r := range block.emptied {
+		buf.Write(c.src[offset:r.Start.Byte])
+		buf.WriteString(`""`)
+		offset = r.End.Byte
 	}
+	buf.Write(c.src[offset:block.end])
+}
 
-	return buf.String()
+// emptiedAttribute reports whether the value of the attribute is replaced with an empty string.
+// They hold the contents that RewriteResources reads from the state and writes to files.
+func emptiedAttribute(path []string, attr string) bool {
+	if len(path) == 1 {
+		return path[0] == "fastly_service_dynamic_snippet_content" && attr == "content"
+	}
+
+	nested := strings.Join(path[1:], ".")
+	switch {
+	case nested == "response_object", nested == "snippet", nested == "vcl", nested == "rate_limiter.response":
+		return attr == "content"
+	case strings.HasPrefix(nested, "logging_") && len(path) == 2:
+		return attr == "format"
+	}
+	return false
+}
+
+// isSensitiveValue reports whether the value is "(sensitive value)", as shown by "terraform show" for a sensitive attribute
+func isSensitiveValue(value hclsyntax.Tokens) bool {
+	return len(value) == 4 &&
+		value[0].Type == hclsyntax.TokenOParen &&
+		string(value[1].Bytes) == "sensitive" &&
+		string(value[2].Bytes) == "value" &&
+		value[3].Type == hclsyntax.TokenCParen
 }
@@ -3,8 +3,12 @@ package tfconf
 import (
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
 )
 
 func TestCleanupHCL(t *testing.T) {
@@ -28,3 +32,121 @@ func TestCleanupHCL(t *testing.T) {
 		t.Errorf("cleanupHCL test failed.\nExpected:\n%v\n\nGot:\n%v", expectedOutput, output)
 	}
 }
+
+// cleanupSeeds are the cases the line-based cleanup got wrong
+var cleanupSeeds = []string{
+	// Heredocs with other delimiters, and lines in them that end with a brace or read EOT
+	`resource "fastly_service_vcl" "service" {
+    name = "test"
+
+    snippet {
+        content  = <<-VCL
+            if (req.url ~ "^/EOT") {
+            EOT
+            }
+        VCL
+        name     = "redirect"
+        priority = 100
+    }
+    response_object {
+        content = <<EOF
+{"message": "EOT"}
+EOF
+        name    = "error"
+    }
+}
+`,
+	// A nested block whose type starts with that of a block with special handling
+	`resource "fastly_service_vcl" "service" {
+    vcl_settings {
+        content = "kept"
+    }
+    vcl {
+        content = "if (true) { set req.http.a = \"}\"; }"
+        main    = true
+        name    = "main"
+    }
+}
+`,
+	// A log format with parentheses in the strings, and a resource type that starts with a supported one
+	`resource "fastly_service_vcl_extra" "other" {
+    name = "skipped"
+}
+
+resource "fastly_service_vcl" "service" {
+    logging_https {
+        format = jsonencode(
+            {
+                req_method = "%m)"
+                url        = "${req.url} ("
+            }
+        )
+        name   = "https"
+    }
+}
+`,
+}
+
+func FuzzCleanupHCL(f *testing.F) {
+	for _, name := range []string{"cleanup_input.hcl", "cleanup_output.hcl"} {
+		b, err := os.ReadFile(filepath.Join("..", "..", "testdata", name))
+		if err != nil {
+			f.Fatalf("Failed to read %s: %v", name, err)
+		}
+		f.Add(string(b))
+	}
+	for _, seed := range cleanupSeeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		output := cleanupHCL(input)
+
+		// The "terraform show" output is not valid HCL as a whole, but the cleanup of any valid HCL must be valid
+		// and keep all the supported resource blocks
+		in, diags := hclsyntax.ParseConfig([]byte(input), "input.tf", hcl.Pos{Line: 1, Column: 1})
+		if diags.HasErrors() {
+			return
+		}
+		out, diags := hclsyntax.ParseConfig([]byte(output), "output.tf", hcl.Pos{Line: 1, Column: 1})
+		if diags.HasErrors() {
+			t.Fatalf("cleanupHCL output does not parse: %s\nInput:\n%s\nOutput:\n%s", diags, input, output)
+		}
+		if expected, got := supportedBlocks(in), supportedBlocks(out); !reflect.DeepEqual(got, expected) {
+			t.Fatalf("cleanupHCL kept %q, want %q\nInput:\n%s\nOutput:\n%s", got, expected, input, output)
+		}
+	})
+}
+
+func TestCleanupHCLSeeds(t *testing.T) {
+	output := cleanupHCL(strings.Join(cleanupSeeds, "\n"))
+	for _, expected := range []string{
+		`content  = ""
+        name     = "redirect"`,
+		`content = ""
+        name    = "error"`,
+		`content = "kept"`,
+		`format = ""
+        name   = "https"`,
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("cleanupHCL output does not contain %q:\n%s", expected, output)
+		}
+	}
+	for _, unexpected := range []string{"EOT", "%m", "fastly_service_vcl_extra"} {
+		if strings.Contains(output, unexpected) {
+			t.Errorf("cleanupHCL output contains %q:\n%s", unexpected, output)
+		}
+	}
+}
+
+// supportedBlocks returns the labels of the resource blocks of the types kept by cleanupHCL
+func supportedBlocks(f *hcl.File) [][]string {
+	var labels [][]string
+	for _, block := range f.Body.(*hclsyntax.Body).Blocks {
+		if block.Type == "resource" && len(block.Labels) == 2 && cleanupResourceTypes[block.Labels[0]] {
+			labels = append(labels, block.Labels)
+		}
+	}
+	return labels
+}
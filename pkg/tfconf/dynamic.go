@@ -0,0 +1,236 @@
+package tfconf
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/hrmsk66/terraformify/pkg/cli"
+	"github.com/hrmsk66/terraformify/pkg/file"
+	"github.com/hrmsk66/terraformify/pkg/naming"
+	"github.com/zclconf/go-cty/cty"
+	"gopkg.in/yaml.v3"
+)
+
+// dynamicBlockTypes are the nested blocks of the services collapsed into dynamic blocks, which a service can have dozens of
+var dynamicBlockTypes = []string{"backend", "condition", "header", "cache_setting"}
+
+// CollapseDynamicBlocks replaces the nested blocks of each type in dynamicBlockTypes with a dynamic block iterating over
+// a local value, which maps the names of the blocks to their other attributes. Only the blocks of a type that are
+// homogeneous are collapsed: there are two or more of them, each with a unique name and no nested blocks, and their
+// attributes have literal values of the same primitive types. So the blocks with a variable, such as a sensitive
+// attribute, are left as they are. An attribute missing in some of the blocks is null in their values, which is the same
+// as leaving it out. The blocks are sets in the provider, so the order the dynamic block generates them in does not matter.
+// With c.DynamicBlocksData, the values are written to a data file in the format, which the local value decodes.
+func (tfconf *TFConf) CollapseDynamicBlocks(c *cli.Config, tx *file.Transaction) error {
+	var locals []*hclwrite.Attribute
+	localsBlock := hclwrite.NewBlock("locals", nil)
+
+	for _, block := range tfconf.Body().Blocks() {
+		labels := block.Labels()
+		if block.Type() != "resource" || len(labels) != 2 || (labels[0] != "fastly_service_vcl" && labels[0] != "fastly_service_compute") {
+			continue
+		}
+		body := block.Body()
+
+		for _, blockType := range dynamicBlockTypes {
+			var nested []*hclwrite.Block
+			for _, b := range body.Blocks() {
+				if b.Type() == blockType {
+					nested = append(nested, b)
+				}
+			}
+			values, ok := homogeneousBlocks(nested)
+			if !ok {
+				// The inputs of the module are lifted before the blocks are collapsed, so their blocks are kept
+				if c.AsModule && len(nested) > 1 && referencesVariables(nested) {
+					log.Printf("[WARN] Leaving the %d %s blocks of %s as they are, as some of their values are variables, such as the inputs lifted with --as-module", len(nested), blockType, labels[1])
+				}
+				continue
+			}
+
+			localName := fmt.Sprintf("%s_%ss", naming.Normalize(labels[1]), blockType)
+			log.Printf("[INFO] Collapsing %d %s blocks into local.%s", len(nested), blockType, localName)
+
+			if c.DynamicBlocksData == "" {
+				locals = append(locals, localsBlock.Body().SetAttributeValue(localName, values))
+			} else {
+				filename := fmt.Sprintf("%ss.%s", blockType, c.DynamicBlocksData)
+				content, err := encodeDynamicBlockData(values, c.DynamicBlocksData)
+				if err != nil {
+					return err
+				}
+				if err = tx.WriteData(c.ResourceName, filename, content); err != nil {
+					return err
+				}
+				decode := "jsondecode"
+				if c.DynamicBlocksData == cli.DataYAML {
+					decode = "yamldecode"
+				}
+				path := filePath(c, "data", c.ResourceName, filename)
+				locals = append(locals, localsBlock.Body().SetAttributeRaw(localName, hclwrite.TokensForFunctionCall(decode, buildFileFunction(path))))
+			}
+
+			for _, b := range nested {
+				body.RemoveBlock(b)
+			}
+			body.AppendNewline()
+			body.AppendBlock(buildDynamicBlock(blockType, localName, nested))
+		}
+	}
+
+	if len(locals) > 0 {
+		tfconf.Body().AppendNewline()
+		tfconf.Body().AppendBlock(localsBlock)
+	}
+	return nil
+}
+
+// homogeneousBlocks returns the values of the blocks keyed by their names, if the blocks can be collapsed into a dynamic block
+func homogeneousBlocks(blocks []*hclwrite.Block) (cty.Value, bool) {
+	if len(blocks) < 2 {
+		return cty.NilVal, false
+	}
+
+	attrTypes := map[string]cty.Type{}
+	attrs := map[string]map[string]cty.Value{}
+	for _, b := range blocks {
+		if len(b.Body().Blocks()) > 0 {
+			return cty.NilVal, false
+		}
+		name, err := literalName(b)
+		if err != nil {
+			return cty.NilVal, false
+		}
+		if _, ok := attrs[name]; ok {
+			return cty.NilVal, false
+		}
+
+		attrs[name] = map[string]cty.Value{}
+		for attrName, attr := range b.Body().Attributes() {
+			if attrName == "name" {
+				continue
+			}
+			v, ok := literalValue(attr)
+			if !ok || v.IsNull() || !v.Type().IsPrimitiveType() {
+				return cty.NilVal, false
+			}
+			if t, ok := attrTypes[attrName]; ok && !t.Equals(v.Type()) {
+				return cty.NilVal, false
+			}
+			attrTypes[attrName] = v.Type()
+			attrs[name][attrName] = v
+		}
+	}
+
+	values := map[string]cty.Value{}
+	for name, blockAttrs := range attrs {
+		for attrName, t := range attrTypes {
+			if _, ok := blockAttrs[attrName]; !ok {
+				blockAttrs[attrName] = cty.NullVal(t)
+			}
+		}
+		values[name] = cty.ObjectVal(blockAttrs)
+	}
+	return cty.ObjectVal(values), true
+}
+
+// referencesVariables tells whether any attribute of the blocks refers to a variable
+func referencesVariables(blocks []*hclwrite.Block) bool {
+	for _, b := range blocks {
+		for _, attr := range b.Body().Attributes() {
+			for _, traversal := range attr.Expr().Variables() {
+				if string(traversal.BuildTokens(nil)[0].Bytes) == "var" {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// buildDynamicBlock builds the dynamic block that generates the blocks of the type from the local value,
+// with the attributes in any of the blocks
+func buildDynamicBlock(blockType, localName string, blocks []*hclwrite.Block) *hclwrite.Block {
+	dynamic := hclwrite.NewBlock("dynamic", []string{blockType})
+	dynamicBody := dynamic.Body()
+	dynamicBody.SetAttributeTraversal("for_each", hcl.Traversal{
+		hcl.TraverseRoot{Name: "local"},
+		hcl.TraverseAttr{Name: localName},
+	})
+
+	var bodies []*hclwrite.Body
+	for _, b := range blocks {
+		bodies = append(bodies, b.Body())
+	}
+
+	contentBody := dynamicBody.AppendNewBlock("content", nil).Body()
+	for _, attrName := range attributeNames(bodies) {
+		if attrName == "name" {
+			contentBody.SetAttributeTraversal(attrName, hcl.Traversal{
+				hcl.TraverseRoot{Name: blockType},
+				hcl.TraverseAttr{Name: "key"},
+			})
+			continue
+		}
+		contentBody.SetAttributeTraversal(attrName, hcl.Traversal{
+			hcl.TraverseRoot{Name: blockType},
+			hcl.TraverseAttr{Name: "value"},
+			hcl.TraverseAttr{Name: attrName},
+		})
+	}
+	return dynamic
+}
+
+// encodeDynamicBlockData encodes the values of the blocks in the format of the data file
+func encodeDynamicBlockData(values cty.Value, format string) ([]byte, error) {
+	if format == cli.DataYAML {
+		b, err := yaml.Marshal(yamlNode(values))
+		if err != nil {
+			return nil, fmt.Errorf("tfconf: failed to encode the blocks in YAML: %w", err)
+		}
+		return b, nil
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	// Keep <, > and & in VCL conditions readable
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode((&jsonConverter{}).value(values)); err != nil {
+		return nil, fmt.Errorf("tfconf: failed to encode the blocks in JSON: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// yamlNode returns the YAML node of the value. The strings are quoted, so that yamldecode does not read
+// a string such as "true" or "443" as another type.
+func yamlNode(v cty.Value) *yaml.Node {
+	t := v.Type()
+	switch {
+	case v.IsNull():
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}
+	case t == cty.String:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: v.AsString(), Style: yaml.DoubleQuotedStyle}
+	case t == cty.Number:
+		tag := "!!float"
+		if v.AsBigFloat().IsInt() {
+			tag = "!!int"
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: v.AsBigFloat().Text('f', -1)}
+	case t == cty.Bool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: fmt.Sprint(v.True())}
+	}
+
+	node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	for it := v.ElementIterator(); it.Next(); {
+		k, e := it.Element()
+		node.Content = append(node.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: k.AsString(), Style: yaml.DoubleQuotedStyle},
+			yamlNode(e))
+	}
+	return node
+}
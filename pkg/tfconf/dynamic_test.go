@@ -0,0 +1,255 @@
+package tfconf
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/ext/dynblock"
+	"github.com/hashicorp/hcl/v2/hcldec"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hrmsk66/terraformify/pkg/cli"
+	"github.com/hrmsk66/terraformify/pkg/file"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+	"github.com/zclconf/go-cty/cty/function/stdlib"
+	"gopkg.in/yaml.v3"
+)
+
+const dynamicBlocksConfig = `resource "fastly_service_vcl" "service" {
+  name = "test"
+  backend {
+    address        = "www.example.com"
+    name           = "origin"
+    ssl_client_key = var.origin_ssl_client_key
+  }
+  backend {
+    address = "static.example.com"
+    name    = "static"
+  }
+  condition {
+    name      = "is_api"
+    priority  = 10
+    statement = "req.url ~ \"^/api\""
+    type      = "REQUEST"
+  }
+  header {
+    action      = "set"
+    destination = "http.Strict-Transport-Security"
+    name        = "Generated by force TLS and HSTS"
+    source      = "\"max-age=300; $${preload}\""
+    type        = "response"
+  }
+  header {
+    action      = "delete"
+    destination = "http.X-Powered-By"
+    name        = "remove x-powered-by"
+    type        = "cache"
+  }
+  header {
+    action      = "regex"
+    destination = "url"
+    name        = "rewrite"
+    regex       = "^/v1"
+    priority    = 20
+    type        = "request"
+  }
+}
+
+output "fastly_service_url" {
+  value = "https://test.example.com"
+}
+`
+
+// headerSpec decodes the header blocks, typing the attributes left out in some of them
+var headerSpec = &hcldec.BlockSetSpec{TypeName: "header", Nested: &hcldec.ObjectSpec{
+	"action":      &hcldec.AttrSpec{Name: "action", Type: cty.String},
+	"destination": &hcldec.AttrSpec{Name: "destination", Type: cty.String},
+	"name":        &hcldec.AttrSpec{Name: "name", Type: cty.String},
+	"priority":    &hcldec.AttrSpec{Name: "priority", Type: cty.Number},
+	"regex":       &hcldec.AttrSpec{Name: "regex", Type: cty.String},
+	"source":      &hcldec.AttrSpec{Name: "source", Type: cty.String},
+	"type":        &hcldec.AttrSpec{Name: "type", Type: cty.String},
+}}
+
+// expandHeaders returns the header blocks of the service in the configuration, with the dynamic blocks expanded
+// as Terraform does. The file function reads the files in the directory.
+func expandHeaders(t *testing.T, src []byte, dir string) cty.Value {
+	t.Helper()
+	f, diags := hclsyntax.ParseConfig(src, "service.tf", hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		t.Fatalf("failed to parse the configuration: %s\n%s", diags, src)
+	}
+
+	ctx := &hcl.EvalContext{Functions: map[string]function.Function{
+		"jsondecode": stdlib.JSONDecodeFunc,
+		"file": function.New(&function.Spec{
+			Params: []function.Parameter{{Name: "path", Type: cty.String}},
+			Type:   function.StaticReturnType(cty.String),
+			Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+				b, err := os.ReadFile(filepath.Join(dir, args[0].AsString()))
+				return cty.StringVal(string(b)), err
+			},
+		}),
+	}}
+	locals := map[string]cty.Value{}
+	var service *hclsyntax.Body
+	for _, block := range f.Body.(*hclsyntax.Body).Blocks {
+		switch block.Type {
+		case "locals":
+			for name, attr := range block.Body.Attributes {
+				v, diags := attr.Expr.Value(ctx)
+				if diags.HasErrors() {
+					t.Fatalf("failed to evaluate local.%s: %s", name, diags)
+				}
+				locals[name] = v
+			}
+		case "resource":
+			service = block.Body
+		}
+	}
+	ctx.Variables = map[string]cty.Value{"local": cty.ObjectVal(locals)}
+
+	v, _, diags := hcldec.PartialDecode(dynblock.Expand(service, ctx), headerSpec, ctx)
+	if diags.HasErrors() {
+		t.Fatalf("failed to decode the headers: %s\n%s", diags, src)
+	}
+	return v
+}
+
+func TestCollapseDynamicBlocks(t *testing.T) {
+	original := expandHeaders(t, []byte(dynamicBlocksConfig), "")
+	if original.LengthInt() != 3 {
+		t.Fatalf("headers = %#v, want 3", original)
+	}
+
+	for data, expectedLocal := range map[string]string{
+		"":           "service_headers = {",
+		cli.DataJSON: `service_headers = jsondecode(file("data/service/headers.json"))`,
+	} {
+		dir := t.TempDir()
+		conf, err := LoadFile([]byte(dynamicBlocksConfig), "service.tf")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx, err := file.Begin(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := conf.CollapseDynamicBlocks(&cli.Config{ResourceName: "service", DynamicBlocksData: data}, tx); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// The headers are the same as they were, and the backend with a variable and the lone condition are left as they are
+		src := conf.Bytes()
+		if headers := expandHeaders(t, src, dir); !headers.Equals(original).True() {
+			t.Errorf("headers with data %q = %#v, want %#v", data, headers, original)
+		}
+		output := strings.Join(strings.Fields(string(src)), " ")
+		for _, expected := range []string{
+			`dynamic "header" { for_each = local.service_headers content {`,
+			"name = header.key",
+			"regex = header.value.regex",
+			`address = "static.example.com"`,
+			`type = "REQUEST"`,
+			expectedLocal,
+		} {
+			if !strings.Contains(output, expected) {
+				t.Errorf("configuration with data %q does not contain %q:\n%s", data, expected, src)
+			}
+		}
+		if strings.Contains(output, "local.service_backends") || strings.Contains(output, "local.service_conditions") {
+			t.Errorf("configuration with data %q collapses the backends or the conditions:\n%s", data, src)
+		}
+	}
+}
+
+func TestEncodeDynamicBlockDataYAML(t *testing.T) {
+	values := cty.ObjectVal(map[string]cty.Value{
+		"origin": cty.ObjectVal(map[string]cty.Value{
+			"address":        cty.StringVal("443"),
+			"port":           cty.NumberIntVal(443),
+			"ssl_check_cert": cty.True,
+			"shield":         cty.NullVal(cty.String),
+		}),
+	})
+	content, err := encodeDynamicBlockData(values, cli.DataYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded map[string]map[string]interface{}
+	if err := yaml.Unmarshal(content, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]map[string]interface{}{
+		"origin": {"address": "443", "port": 443, "ssl_check_cert": true, "shield": nil},
+	}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("decoded = %#v, want %#v\n%s", decoded, expected, content)
+	}
+}
+
+func TestCollapseDynamicBlocksAsModule(t *testing.T) {
+	conf, err := LoadFile([]byte(`resource "fastly_service_vcl" "service" {
+  name = "test"
+  backend {
+    address = "www.example.com"
+    name    = "origin"
+  }
+  backend {
+    address = "static.example.com"
+    name    = "static"
+  }
+  header {
+    action      = "delete"
+    destination = "http.X-Powered-By"
+    name        = "remove x-powered-by"
+    type        = "cache"
+  }
+  header {
+    action      = "delete"
+    destination = "http.Server"
+    name        = "remove server"
+    type        = "cache"
+  }
+}
+`), "service.tf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := file.Begin(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Close()
+
+	// The addresses are lifted into the inputs of the module first, so the backends are no longer collapsed
+	c := &cli.Config{ResourceName: "service", AsModule: true}
+	conf.LiftModuleInputs("fastly_service_vcl", nil)
+	if err := conf.CollapseDynamicBlocks(c, tx); err != nil {
+		t.Fatal(err)
+	}
+
+	output := strings.Join(strings.Fields(string(conf.Bytes())), " ")
+	for _, expected := range []string{`dynamic "header" {`, "address = var.origin_address"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("configuration does not contain %q:\n%s", expected, conf.Bytes())
+		}
+	}
+	if strings.Contains(output, `dynamic "backend"`) {
+		t.Errorf("configuration collapses the backends with the inputs of the module:\n%s", conf.Bytes())
+	}
+	if !referencesVariables(conf.Body().Blocks()[0].Body().Blocks()[:2]) {
+		t.Error("referencesVariables does not find the inputs of the module in the backends")
+	}
+}
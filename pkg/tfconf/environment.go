@@ -0,0 +1,409 @@
+package tfconf
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/hrmsk66/terraformify/pkg/file"
+	"github.com/hrmsk66/terraformify/pkg/naming"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// EnvironmentInput is a value that differs between the environments, which the shared module takes as an input variable
+type EnvironmentInput struct {
+	// Key is the name of the variable
+	Key         string
+	Description string
+	// Type is the type of the values, or cty.DynamicPseudoType if they differ in type
+	Type cty.Type
+	// Values are the values in each environment, in the order the configurations are given in
+	Values []cty.Value
+}
+
+// FactorEnvironments turns the configuration of the first environment into the one shared by all of them.
+// The configurations are compared block by block, the nested blocks matched by their names where they have them
+// and by their order otherwise. Each literal value that differs between the environments is replaced with a reference
+// to a variable, and returned as an input with the value of each environment. The differences that cannot be turned
+// into an input, such as a block only some of the environments have or an expression that differs, are returned
+// as messages, and the shared configuration follows the first environment in them.
+func FactorEnvironments(envs []string, confs []*TFConf) ([]EnvironmentInput, []string) {
+	f := &factorer{envs: envs, taken: map[string]bool{}}
+	// The variables of the sensitive attributes are already in the configuration
+	for _, conf := range confs {
+		refs, err := conf.VariableRefs()
+		if err != nil {
+			continue
+		}
+		for key := range refs {
+			f.taken[key] = true
+		}
+	}
+
+	bodies := make([]*hclwrite.Body, len(confs))
+	for i, conf := range confs {
+		bodies[i] = conf.Body()
+	}
+	f.topLevelBlocks(bodies)
+	return f.inputs, f.diffs
+}
+
+// factorer collects the inputs and the differences between the environments
+type factorer struct {
+	envs   []string
+	inputs []EnvironmentInput
+	diffs  []string
+	taken  map[string]bool
+}
+
+// topLevelBlocks matches the blocks of the files, such as the resources and the outputs, by their types and labels
+func (f *factorer) topLevelBlocks(bodies []*hclwrite.Body) {
+	var keys []string
+	blocks := map[string][]*hclwrite.Block{}
+	for i, body := range bodies {
+		for _, block := range body.Blocks() {
+			key := strings.Join(append([]string{block.Type()}, block.Labels()...), ".")
+			if _, ok := blocks[key]; !ok {
+				keys = append(keys, key)
+				blocks[key] = make([]*hclwrite.Block, len(bodies))
+			}
+			blocks[key][i] = block
+		}
+	}
+
+	for _, key := range keys {
+		matched := blocks[key]
+		// A resource is known by its address
+		path := strings.TrimPrefix(key, "resource.")
+		if !f.inAll(path, blocksPresent(matched)) {
+			continue
+		}
+		labels := matched[0].Labels()
+		prefix := matched[0].Type()
+		if len(labels) > 0 {
+			prefix = naming.Normalize(labels[len(labels)-1])
+		}
+		f.body(bodyOf(matched), path, prefix, true)
+	}
+}
+
+// body compares the attributes and the nested blocks of the matched bodies. The names of the variables
+// for the attributes start with the prefix. The names for the blocks nested in a top-level block start
+// with their own name alone, as in the variables of the sensitive attributes.
+func (f *factorer) body(bodies []*hclwrite.Body, path, prefix string, topLevel bool) {
+	for _, name := range attributeNames(bodies) {
+		f.attribute(bodies, path, prefix, name)
+	}
+
+	var types []string
+	blocks := map[string][][]*hclwrite.Block{}
+	for i, body := range bodies {
+		for _, block := range body.Blocks() {
+			t := block.Type()
+			if _, ok := blocks[t]; !ok {
+				types = append(types, t)
+				blocks[t] = make([][]*hclwrite.Block, len(bodies))
+			}
+			blocks[t][i] = append(blocks[t][i], block)
+		}
+	}
+
+	for _, t := range types {
+		for _, m := range f.matchBlocks(blocks[t], path+"."+t) {
+			own := t
+			if m.name != "" {
+				own = naming.Normalize(m.name)
+			} else if m.index > 0 {
+				own = fmt.Sprintf("%s_%d", t, m.index+1)
+			}
+			if !topLevel {
+				own = prefix + "_" + own
+			}
+			f.body(bodyOf(m.blocks), m.path, own, false)
+		}
+	}
+}
+
+// attribute compares the attribute in the matched bodies and replaces it with a variable if the literal values differ
+func (f *factorer) attribute(bodies []*hclwrite.Body, path, prefix, name string) {
+	attrs := make([]*hclwrite.Attribute, len(bodies))
+	present := make([]bool, len(bodies))
+	for i, body := range bodies {
+		attrs[i] = body.GetAttribute(name)
+		present[i] = attrs[i] != nil
+	}
+	if !f.inAll(path+"."+name, present) {
+		return
+	}
+
+	values := make([]cty.Value, len(attrs))
+	literal := true
+	for i, attr := range attrs {
+		v, ok := literalValue(attr)
+		if !ok {
+			literal = false
+			break
+		}
+		values[i] = v
+	}
+
+	if !literal {
+		exprs := make([]string, len(attrs))
+		same := true
+		for i, attr := range attrs {
+			exprs[i] = string(bytes.TrimSpace(hclwrite.Format(attr.Expr().BuildTokens(nil).Bytes())))
+			same = same && exprs[i] == exprs[0]
+		}
+		if !same {
+			var in []string
+			for i, expr := range exprs {
+				in = append(in, fmt.Sprintf("%s in %s", expr, f.envs[i]))
+			}
+			f.diffs = append(f.diffs, fmt.Sprintf("%s.%s is %s, which cannot be turned into a variable", path, name, strings.Join(in, ", ")))
+		}
+		return
+	}
+
+	same := true
+	t := values[0].Type()
+	for _, v := range values[1:] {
+		same = same && v.RawEquals(values[0])
+		if !v.Type().Equals(t) {
+			t = cty.DynamicPseudoType
+		}
+	}
+	if same {
+		return
+	}
+
+	key := prefix + "_" + name
+	base := key
+	for i := 2; f.taken[key]; i++ {
+		key = fmt.Sprintf("%s_%d", base, i)
+	}
+	f.taken[key] = true
+
+	bodies[0].SetAttributeTraversal(name, buildVariableRef(key))
+	f.inputs = append(f.inputs, EnvironmentInput{
+		Key:         key,
+		Description: fmt.Sprintf("%s of %s", name, path),
+		Type:        t,
+		Values:      values,
+	})
+}
+
+// matchedBlocks are the nested blocks matched between the environments
+type matchedBlocks struct {
+	blocks []*hclwrite.Block
+	path   string
+	// name is the name the blocks are matched by, which is empty if they are matched by their order
+	name  string
+	index int
+}
+
+// matchBlocks matches the nested blocks of a type between the environments. The blocks are matched by their names
+// if each environment has the same names, or by their order if they have the same number of blocks. Otherwise, the blocks
+// are matched by their names where they can be, and the rest is reported.
+func (f *factorer) matchBlocks(blocks [][]*hclwrite.Block, path string) []matchedBlocks {
+	names := make([][]string, len(blocks))
+	named, sameNames, sameCount := true, true, true
+	for i, bs := range blocks {
+		seen := map[string]bool{}
+		for _, b := range bs {
+			name, err := literalName(b)
+			if err != nil || seen[name] {
+				named = false
+			}
+			seen[name] = true
+			names[i] = append(names[i], name)
+		}
+		sameCount = sameCount && len(bs) == len(blocks[0])
+		sameNames = sameNames && sameCount && sameSet(names[i], names[0])
+	}
+
+	var matched []matchedBlocks
+	switch {
+	case named && sameNames:
+		for _, name := range names[0] {
+			m := matchedBlocks{blocks: make([]*hclwrite.Block, len(blocks)), path: fmt.Sprintf("%s[%q]", path, name), name: name}
+			for i := range blocks {
+				m.blocks[i] = blocks[i][indexOf(names[i], name)]
+			}
+			matched = append(matched, m)
+		}
+	case sameCount:
+		for j := range blocks[0] {
+			m := matchedBlocks{blocks: make([]*hclwrite.Block, len(blocks)), path: fmt.Sprintf("%s[%d]", path, j), index: j}
+			for i := range blocks {
+				m.blocks[i] = blocks[i][j]
+			}
+			matched = append(matched, m)
+		}
+	case named:
+		var all []string
+		for _, ns := range names {
+			for _, name := range ns {
+				if indexOf(all, name) < 0 {
+					all = append(all, name)
+				}
+			}
+		}
+		for _, name := range all {
+			m := matchedBlocks{blocks: make([]*hclwrite.Block, len(blocks)), path: fmt.Sprintf("%s[%q]", path, name), name: name}
+			for i := range blocks {
+				if j := indexOf(names[i], name); j >= 0 {
+					m.blocks[i] = blocks[i][j]
+				}
+			}
+			if f.inAll(m.path, blocksPresent(m.blocks)) {
+				matched = append(matched, m)
+			}
+		}
+	default:
+		var counts []string
+		for i, bs := range blocks {
+			counts = append(counts, fmt.Sprintf("%d in %s", len(bs), f.envs[i]))
+		}
+		f.diffs = append(f.diffs, fmt.Sprintf("%s has different numbers of blocks (%s), which cannot be matched", path, strings.Join(counts, ", ")))
+	}
+	return matched
+}
+
+// inAll reports whether each environment has the block or the attribute, and adds a difference if not
+func (f *factorer) inAll(path string, present []bool) bool {
+	var in, notIn []string
+	for i, ok := range present {
+		if ok {
+			in = append(in, f.envs[i])
+		} else {
+			notIn = append(notIn, f.envs[i])
+		}
+	}
+	if len(notIn) == 0 {
+		return true
+	}
+	f.diffs = append(f.diffs, fmt.Sprintf("%s is in %s but not in %s", path, strings.Join(in, ", "), strings.Join(notIn, ", ")))
+	return false
+}
+
+// literalName returns the name of the block if it is a literal string
+func literalName(block *hclwrite.Block) (string, error) {
+	attr := block.Body().GetAttribute("name")
+	if attr == nil {
+		return "", fmt.Errorf(`%w: failed to find "name" in "%s"`, ErrAttrNotFound, block.Type())
+	}
+	v, ok := literalValue(attr)
+	if !ok || v.IsNull() || v.Type() != cty.String {
+		return "", fmt.Errorf("the name of %s is not a literal string", block.Type())
+	}
+	return v.AsString(), nil
+}
+
+// attributeNames returns the names of the attributes in any of the bodies
+func attributeNames(bodies []*hclwrite.Body) []string {
+	var names []string
+	for _, body := range bodies {
+		for name := range body.Attributes() {
+			if indexOf(names, name) < 0 {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func blocksPresent(blocks []*hclwrite.Block) []bool {
+	present := make([]bool, len(blocks))
+	for i, b := range blocks {
+		present[i] = b != nil
+	}
+	return present
+}
+
+func bodyOf(blocks []*hclwrite.Block) []*hclwrite.Body {
+	bodies := make([]*hclwrite.Body, len(blocks))
+	for i, b := range blocks {
+		bodies[i] = b.Body()
+	}
+	return bodies
+}
+
+func indexOf(list []string, s string) int {
+	for i, item := range list {
+		if item == s {
+			return i
+		}
+	}
+	return -1
+}
+
+func sameSet(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, s := range a {
+		if indexOf(b, s) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// BuildEnvironmentVariables builds the variable blocks of the inputs of the shared module
+func BuildEnvironmentVariables(inputs []EnvironmentInput) []byte {
+	f := hclwrite.NewEmptyFile()
+	rootBody := f.Body()
+
+	for i, input := range inputs {
+		if i != 0 {
+			rootBody.AppendNewline()
+		}
+
+		varBody := rootBody.AppendNewBlock("variable", []string{input.Key}).Body()
+		varBody.SetAttributeValue("description", cty.StringVal(input.Description))
+		varBody.SetAttributeRaw("type", typeTokens(input.Type))
+	}
+
+	return f.Bytes()
+}
+
+// BuildEnvironmentModuleBlock builds the module block that calls the shared module from the root module.
+// All the inputs, including the sensitive attributes, are passed on through the variables of the same name,
+// which each environment sets in its own .tfvars file.
+func BuildEnvironmentModuleBlock(moduleName string, keys []string) []byte {
+	f := hclwrite.NewEmptyFile()
+	moduleBody := f.Body().AppendNewBlock("module", []string{moduleName}).Body()
+	moduleBody.SetAttributeValue("source", cty.StringVal("./"+filepath.ToSlash(file.ModuleDir(moduleName))))
+
+	if len(keys) > 0 {
+		moduleBody.AppendNewline()
+	}
+	for _, key := range keys {
+		moduleBody.SetAttributeTraversal(key, buildVariableRef(key))
+	}
+
+	return f.Bytes()
+}
+
+// BuildEnvironmentTFVars builds the .tfvars file of the environment at the index, with the values of the inputs
+// and the sensitive attributes of the environment
+func BuildEnvironmentTFVars(inputs []EnvironmentInput, index int, attrs []SensitiveAttr) []byte {
+	f := hclwrite.NewEmptyFile()
+	rootBody := f.Body()
+
+	for _, input := range inputs {
+		rootBody.SetAttributeValue(input.Key, input.Values[index])
+	}
+	if len(inputs) > 0 && len(attrs) > 0 {
+		rootBody.AppendNewline()
+	}
+	for _, attr := range attrs {
+		rootBody.SetAttributeValue(attr.Key, cty.StringVal(attr.Value))
+	}
+
+	return f.Bytes()
+}
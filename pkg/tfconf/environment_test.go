@@ -0,0 +1,159 @@
+package tfconf
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestFactorEnvironments(t *testing.T) {
+	staging, err := LoadFile([]byte(`resource "fastly_service_vcl" "service" {
+  name = "staging"
+  backend {
+    address        = "staging.example.com"
+    name           = "origin"
+    port           = 443
+    ssl_client_key = var.origin_ssl_client_key
+  }
+  backend {
+    address = "static.example.com"
+    name    = "static"
+  }
+  domain {
+    name = "staging.example.com"
+  }
+  condition {
+    name      = "is_api"
+    statement = "req.url ~ \"^/api\""
+  }
+  logging_https {
+    name = "https"
+    url  = "https://logs.example.com/${var.path}"
+  }
+}
+`), "staging.tf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	production, err := LoadFile([]byte(`resource "fastly_service_vcl" "service" {
+  name = "production"
+  backend {
+    address = "static.example.com"
+    name    = "static"
+  }
+  backend {
+    address        = "www.example.com"
+    name           = "origin"
+    port           = 443
+    ssl_client_key = var.origin_ssl_client_key
+  }
+  domain {
+    name = "www.example.com"
+  }
+  condition {
+    name      = "is_api"
+    statement = "req.url ~ \"^/v2/api\""
+    priority  = 10
+  }
+  logging_https {
+    name = "https"
+    url  = "https://logs.example.com/${var.prefix}"
+  }
+  logging_s3 {
+    name = "s3"
+  }
+}
+`), "production.tf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inputs, diffs := FactorEnvironments([]string{"staging", "production"}, []*TFConf{staging, production})
+
+	// The backends are matched by their names, and the domains by their order
+	expected := []EnvironmentInput{
+		{Key: "service_name", Type: cty.String, Values: []cty.Value{cty.StringVal("staging"), cty.StringVal("production")}},
+		{Key: "origin_address", Type: cty.String, Values: []cty.Value{cty.StringVal("staging.example.com"), cty.StringVal("www.example.com")}},
+		{Key: "domain_name", Type: cty.String, Values: []cty.Value{cty.StringVal("staging.example.com"), cty.StringVal("www.example.com")}},
+		{Key: "is_api_statement", Type: cty.String, Values: []cty.Value{cty.StringVal(`req.url ~ "^/api"`), cty.StringVal(`req.url ~ "^/v2/api"`)}},
+	}
+	var keys []string
+	for i, input := range inputs {
+		keys = append(keys, input.Key)
+		if i >= len(expected) {
+			continue
+		}
+		if input.Key != expected[i].Key || !input.Type.Equals(expected[i].Type) || len(input.Values) != 2 ||
+			!input.Values[0].RawEquals(expected[i].Values[0]) || !input.Values[1].RawEquals(expected[i].Values[1]) {
+			t.Errorf("input %d = %#v, want %#v", i, input, expected[i])
+		}
+	}
+	if len(inputs) != len(expected) {
+		t.Errorf("inputs = %q, want %d inputs", keys, len(expected))
+	}
+
+	expectedDiffs := []string{
+		`fastly_service_vcl.service.condition["is_api"].priority is in production but not in staging`,
+		`fastly_service_vcl.service.logging_https["https"].url is "https://logs.example.com/${var.path}" in staging, "https://logs.example.com/${var.prefix}" in production, which cannot be turned into a variable`,
+		`fastly_service_vcl.service.logging_s3["s3"] is in production but not in staging`,
+	}
+	if !reflect.DeepEqual(diffs, expectedDiffs) {
+		t.Errorf("diffs = %q, want %q", diffs, expectedDiffs)
+	}
+
+	output := strings.Join(strings.Fields(string(staging.Bytes())), " ")
+	for _, expected := range []string{
+		"name = var.service_name",
+		"address = var.origin_address",
+		`address = "static.example.com"`,
+		"name = var.domain_name",
+		"statement = var.is_api_statement",
+		"ssl_client_key = var.origin_ssl_client_key",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("shared configuration does not contain %q:\n%s", expected, staging.Bytes())
+		}
+	}
+}
+
+func TestFactorEnvironmentsUnmatchedBlocks(t *testing.T) {
+	a, err := LoadFile([]byte("resource \"fastly_service_vcl\" \"service\" {\n  domain {\n    name = var.a\n  }\n}\n"), "a.tf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := LoadFile([]byte("resource \"fastly_service_vcl\" \"service\" {\n  domain {\n    name = var.a\n  }\n  domain {\n    name = var.b\n  }\n}\n\noutput \"id\" {\n  value = 1\n}\n"), "b.tf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inputs, diffs := FactorEnvironments([]string{"a", "b"}, []*TFConf{a, b})
+	if len(inputs) != 0 {
+		t.Errorf("inputs = %#v, want none", inputs)
+	}
+	expected := []string{
+		"fastly_service_vcl.service.domain has different numbers of blocks (1 in a, 2 in b), which cannot be matched",
+		"output.id is in b but not in a",
+	}
+	if !reflect.DeepEqual(diffs, expected) {
+		t.Errorf("diffs = %q, want %q", diffs, expected)
+	}
+}
+
+func TestBuildEnvironmentTFVars(t *testing.T) {
+	inputs := []EnvironmentInput{
+		{Key: "service_name", Type: cty.String, Values: []cty.Value{cty.StringVal("staging"), cty.StringVal("${production}")}},
+		{Key: "origin_port", Type: cty.Number, Values: []cty.Value{cty.NumberIntVal(80), cty.NumberIntVal(443)}},
+	}
+	tfvars := string(BuildEnvironmentTFVars(inputs, 1, []SensitiveAttr{{Key: "origin_ssl_client_key", Value: "key"}}))
+	expected := "service_name = \"$${production}\"\norigin_port  = 443\n\norigin_ssl_client_key = \"key\"\n"
+	if tfvars != expected {
+		t.Errorf("BuildEnvironmentTFVars = %q, want %q", tfvars, expected)
+	}
+
+	variables := string(BuildEnvironmentVariables(inputs))
+	if !strings.Contains(variables, "type        = number") {
+		t.Errorf("BuildEnvironmentVariables does not type the port:\n%s", variables)
+	}
+}
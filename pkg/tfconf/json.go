@@ -0,0 +1,293 @@
+package tfconf
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// ToJSON converts a configuration written in the native syntax, such as the rewritten TF file of the service,
+// into the JSON syntax. The literal values are written as JSON values, with "${" and "%{" in strings escaped as
+// the strings are read as templates. The other expressions, such as the references to variables, the file function
+// and for_each, are written as a template with the single interpolation "${...}", which evaluates to the value of
+// the expression itself.
+func ToJSON(src []byte) ([]byte, error) {
+	return toJSON(src, true)
+}
+
+// TFVarsToJSON converts a variable definitions file, such as terraform.tfvars, into the JSON syntax.
+// Unlike ToJSON, the strings are not escaped, as Terraform reads the values of a .tfvars.json file literally.
+func TFVarsToJSON(src []byte) ([]byte, error) {
+	return toJSON(src, false)
+}
+
+func toJSON(src []byte, template bool) ([]byte, error) {
+	f, diags := hclsyntax.ParseConfig(src, "", hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("tfconf: failed to parse the configuration to convert into JSON: %w", diags)
+	}
+
+	c := &jsonConverter{src: src, template: template}
+	root := c.body(f.Body.(*hclsyntax.Body), nil)
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	// Keep <, > and & in VCL conditions and URLs readable
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(root); err != nil {
+		return nil, fmt.Errorf("tfconf: failed to encode the configuration in JSON: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// jsonObject is a JSON object that keeps its properties in the order they are set, as the blocks and
+// the attributes are in the native syntax
+type jsonObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func newJSONObject() *jsonObject {
+	return &jsonObject{values: map[string]interface{}{}}
+}
+
+func (o *jsonObject) set(key string, value interface{}) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = value
+}
+
+// object returns the object under the key, setting an empty one if there is none
+func (o *jsonObject) object(key string) *jsonObject {
+	if child, ok := o.values[key].(*jsonObject); ok {
+		return child
+	}
+	child := newJSONObject()
+	o.set(key, child)
+	return child
+}
+
+// appendBlock sets the body of a block under the key. A second block under the same key turns the value into an array.
+func (o *jsonObject) appendBlock(key string, body *jsonObject, array bool) {
+	switch v := o.values[key].(type) {
+	case nil:
+		if array {
+			o.set(key, []interface{}{body})
+		} else {
+			o.set(key, body)
+		}
+	case []interface{}:
+		o.values[key] = append(v, body)
+	default:
+		o.values[key] = []interface{}{v, body}
+	}
+}
+
+func (o *jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err := encodeJSON(&buf, key); err != nil {
+			return nil, err
+		}
+		buf.WriteByte(':')
+		if err := encodeJSON(&buf, o.values[key]); err != nil {
+			return nil, err
+		}
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func encodeJSON(buf *bytes.Buffer, v interface{}) error {
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	// Encode ends the value with a newline
+	buf.Truncate(buf.Len() - 1)
+	return nil
+}
+
+// repeatableBlocks are the top-level blocks without labels that a configuration can have many of
+var repeatableBlocks = map[string]bool{
+	"import":  true,
+	"moved":   true,
+	"removed": true,
+}
+
+// jsonConverter converts the body of a configuration into the JSON syntax
+type jsonConverter struct {
+	src []byte
+	// template tells whether the strings are read as templates, which is the case in the TF files but not in the .tfvars.json files
+	template bool
+}
+
+// body converts the attributes and the blocks of the body. The path holds the types of the blocks the body is in,
+// such as ["resource", "lifecycle"], and is empty for the body of the file.
+func (c *jsonConverter) body(body *hclsyntax.Body, path []string) *jsonObject {
+	obj := newJSONObject()
+
+	for _, attr := range sortedAttributes(body.Attributes) {
+		if isReferenceAttribute(path, attr.Name) {
+			obj.set(attr.Name, c.reference(attr.Expr))
+			continue
+		}
+		obj.set(attr.Name, c.expression(attr.Expr))
+	}
+
+	for _, block := range body.Blocks {
+		parent := obj
+		key := block.Type
+		for _, label := range block.Labels {
+			parent = parent.object(key)
+			key = label
+		}
+		// The repeatable top-level blocks, such as import blocks, are always written as an array
+		// so that the blocks appended to the file later are added to it
+		array := len(path) == 0 && repeatableBlocks[block.Type]
+		nested := append(append([]string(nil), path...), block.Type)
+		parent.appendBlock(key, c.body(block.Body, nested), array)
+	}
+	return obj
+}
+
+// expression returns the value of a literal expression as a JSON value,
+// or the expression as a template with the single interpolation "${...}" otherwise
+func (c *jsonConverter) expression(expr hclsyntax.Expression) interface{} {
+	v, diags := expr.Value(nil)
+	if !diags.HasErrors() && v.IsWhollyKnown() {
+		return c.value(v)
+	}
+
+	// A quoted template is written as it is, as the strings in the JSON syntax are templates too.
+	// It is not with escape sequences, which are not read in them.
+	src := c.source(expr)
+	if _, ok := expr.(*hclsyntax.TemplateExpr); ok && strings.HasPrefix(src, `"`) && !strings.Contains(src, `\`) {
+		return strings.TrimSuffix(strings.TrimPrefix(src, `"`), `"`)
+	}
+	return "${" + src + "}"
+}
+
+func (c *jsonConverter) value(v cty.Value) interface{} {
+	if v.IsNull() {
+		return nil
+	}
+
+	t := v.Type()
+	switch {
+	case t == cty.String:
+		if c.template {
+			return escapeTemplate(v.AsString())
+		}
+		return v.AsString()
+	case t == cty.Number:
+		return json.Number(v.AsBigFloat().Text('f', -1))
+	case t == cty.Bool:
+		return v.True()
+	case t.IsObjectType() || t.IsMapType():
+		obj := newJSONObject()
+		for it := v.ElementIterator(); it.Next(); {
+			k, e := it.Element()
+			key := k.AsString()
+			if c.template {
+				key = escapeTemplate(key)
+			}
+			obj.set(key, c.value(e))
+		}
+		return obj
+	default:
+		// Lists, sets and tuples
+		list := []interface{}{}
+		for it := v.ElementIterator(); it.Next(); {
+			_, e := it.Element()
+			list = append(list, c.value(e))
+		}
+		return list
+	}
+}
+
+// reference returns the references in the expression as strings, which are written without "${}" in the JSON syntax
+func (c *jsonConverter) reference(expr hclsyntax.Expression) interface{} {
+	switch e := expr.(type) {
+	case *hclsyntax.TupleConsExpr:
+		list := []interface{}{}
+		for _, item := range e.Exprs {
+			list = append(list, c.source(item))
+		}
+		return list
+	case *hclsyntax.ObjectConsExpr:
+		obj := newJSONObject()
+		for _, item := range e.Items {
+			key := hcl.ExprAsKeyword(item.KeyExpr)
+			if key == "" {
+				key = c.source(item.KeyExpr)
+			}
+			obj.set(key, c.source(item.ValueExpr))
+		}
+		return obj
+	}
+	return c.source(expr)
+}
+
+// source returns the expression as it is written
+func (c *jsonConverter) source(expr hclsyntax.Expression) string {
+	r := expr.Range()
+	return string(c.src[r.Start.Byte:r.End.Byte])
+}
+
+// isReferenceAttribute reports whether the value of the attribute is a reference or a type constraint,
+// which the JSON syntax takes as a string rather than a template
+func isReferenceAttribute(path []string, attr string) bool {
+	if len(path) == 0 {
+		return false
+	}
+
+	switch last := path[len(path)-1]; {
+	case len(path) == 1 && (path[0] == "resource" || path[0] == "data"):
+		return attr == "provider" || attr == "depends_on"
+	case len(path) == 1 && path[0] == "module":
+		return attr == "providers" || attr == "depends_on"
+	case len(path) == 1 && path[0] == "output":
+		return attr == "depends_on"
+	case len(path) == 1 && path[0] == "variable":
+		return attr == "type"
+	case len(path) == 1 && (path[0] == "import" || path[0] == "moved" || path[0] == "removed"):
+		return attr == "to" || attr == "from" || attr == "provider"
+	case last == "lifecycle":
+		return attr == "ignore_changes" || attr == "replace_triggered_by"
+	case last == "dynamic":
+		return attr == "iterator"
+	}
+	return false
+}
+
+// escapeTemplate escapes the template sequences in the string, so that it is read literally as a template
+func escapeTemplate(s string) string {
+	s = strings.ReplaceAll(s, "${", "$${")
+	return strings.ReplaceAll(s, "%{", "%%{")
+}
+
+// sortedAttributes returns the attributes in the order they are written
+func sortedAttributes(attrs hclsyntax.Attributes) []*hclsyntax.Attribute {
+	sorted := make([]*hclsyntax.Attribute, 0, len(attrs))
+	for _, attr := range attrs {
+		sorted = append(sorted, attr)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].SrcRange.Start.Byte < sorted[j].SrcRange.Start.Byte
+	})
+	return sorted
+}
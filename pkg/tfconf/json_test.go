@@ -0,0 +1,331 @@
+package tfconf
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	hcljson "github.com/hashicorp/hcl/v2/json"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+)
+
+// jsonTestConfig has the expressions the rewrites write, such as the file function, variables and for_each,
+// and the values that have to be escaped or written as references in the JSON syntax
+const jsonTestConfig = `resource "fastly_service_vcl" "service" {
+  name          = var.name
+  force_destroy = true
+  comment       = ""
+
+  backend {
+    address = "httpbin.org"
+    name    = "httpbin"
+    port    = 443
+    weight  = 100.5
+    ssl_client_key = var.httpbin_ssl_client_key
+  }
+
+  condition {
+    name      = "is & < >"
+    statement = "req.url ~ \"^/$${path}\" && req.http.X == \"%%{x}\""
+    type      = "REQUEST"
+  }
+
+  vcl {
+    content = file("${path.module}/vcl/main.vcl")
+    main    = true
+    name    = "main"
+  }
+
+  logging_https {
+    format = file("${path.module}/logformat/https.txt")
+    name   = "https"
+    url    = "https://example.com/${var.name}"
+    path   = "/$${x}/${var.name}"
+    header_name = "\"${var.name}\""
+    header_value = null
+  }
+
+  dynamic "header" {
+    for_each = var.headers
+    iterator = h
+    content {
+      name = h.value.name
+    }
+  }
+
+  product_enablement {
+    brotli_compression = false
+  }
+
+  lifecycle {
+    ignore_changes = [comment, backend]
+  }
+}
+
+resource "fastly_service_acl_entries" "allow_list" {
+  for_each = {
+    for a in fastly_service_vcl.service.acl : a.name => a if a.name == "allow list"
+  }
+  acl_id     = each.value.acl_id
+  depends_on = [fastly_service_vcl.service]
+  service_id = fastly_service_vcl.service.id
+  entry {
+    ip      = "192.168.0.1"
+    subnet  = "24"
+    negated = false
+  }
+  entry {
+    ip     = "192.168.0.2"
+    tags   = ["a", "b"]
+    labels = { env = "prod", "${x}" = 1 }
+  }
+}
+
+variable "httpbin_ssl_client_key" {
+  description = "httpbin ssl client key"
+  type        = string
+  sensitive   = true
+}
+
+variable "headers" {
+  type = list(object({ name = string }))
+}
+
+import {
+  to = fastly_service_vcl.service
+  id = "7ManTUgtlSytxeXRMPYY33"
+}
+
+import {
+  to = fastly_service_acl_entries.allow_list["allow list"]
+  id = "7ManTUgtlSytxeXRMPYY33/2Csd4ocnhkhD3J5KIP4OeK"
+}
+`
+
+func TestToJSON(t *testing.T) {
+	sources := map[string][]byte{"jsonTestConfig": []byte(jsonTestConfig)}
+	files, err := filepath.Glob(filepath.Join("..", "..", "testdata", "*.tf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		src, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sources[filepath.Base(f)] = src
+	}
+
+	for name, src := range sources {
+		t.Run(name, func(t *testing.T) {
+			converted, err := ToJSON(src)
+			if err != nil {
+				t.Fatalf("ToJSON failed: %v", err)
+			}
+			assertEquivalentJSON(t, src, converted)
+		})
+	}
+}
+
+func TestToJSONValues(t *testing.T) {
+	converted, err := ToJSON([]byte(jsonTestConfig))
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+
+	for _, expected := range []string{
+		`"content": "${file(\"${path.module}/vcl/main.vcl\")}"`,
+		`"ssl_client_key": "${var.httpbin_ssl_client_key}"`,
+		`"for_each": "${var.headers}"`,
+		`"iterator": "h"`,
+		`"depends_on": [`,
+		`"fastly_service_vcl.service"`,
+		`"ignore_changes": [`,
+		`"type": "string"`,
+		`"to": "fastly_service_acl_entries.allow_list[\"allow list\"]"`,
+		`"statement": "req.url ~ \"^/$${path}\" && req.http.X == \"%%{x}\""`,
+		`"name": "is & < >"`,
+		`"port": 443`,
+		`"weight": 100.5`,
+		`"url": "https://example.com/${var.name}"`,
+		`"path": "/$${x}/${var.name}"`,
+		`"header_name": "${\"\\\"${var.name}\\\"\"}"`,
+		`"header_value": null`,
+	} {
+		if !strings.Contains(string(converted), expected) {
+			t.Errorf("ToJSON output does not contain %s:\n%s", expected, converted)
+		}
+	}
+}
+
+func TestTFVarsToJSON(t *testing.T) {
+	tfvars := BuildTFVars([]SensitiveAttr{{Key: "httpbin_ssl_client_key", Value: "-----BEGIN ${KEY}-----"}})
+	converted, err := TFVarsToJSON(tfvars)
+	if err != nil {
+		t.Fatalf("TFVarsToJSON failed: %v", err)
+	}
+
+	// Terraform reads the values of a .tfvars.json file literally, so the value is not escaped
+	expected := "{\n  \"httpbin_ssl_client_key\": \"-----BEGIN ${KEY}-----\"\n}\n"
+	if string(converted) != expected {
+		t.Errorf("TFVarsToJSON = %s, want %s", converted, expected)
+	}
+}
+
+// assertEquivalentJSON checks that the configuration in the JSON syntax has the same blocks as the one in the native syntax,
+// and that the attributes evaluate to the same values
+func assertEquivalentJSON(t *testing.T, src, converted []byte) {
+	t.Helper()
+	native, diags := hclsyntax.ParseConfig(src, "native.tf", hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		t.Fatalf("Failed to parse the native syntax: %v", diags)
+	}
+	f, diags := hcljson.Parse(converted, "converted.tf.json")
+	if diags.HasErrors() {
+		t.Fatalf("Failed to parse the JSON syntax: %v\n%s", diags, converted)
+	}
+
+	ctx := &hcl.EvalContext{
+		Variables: map[string]cty.Value{
+			"var":                        cty.DynamicVal,
+			"each":                       cty.DynamicVal,
+			"path":                       cty.ObjectVal(map[string]cty.Value{"module": cty.StringVal(".")}),
+			"fastly_service_vcl":         cty.DynamicVal,
+			"fastly_service_compute":     cty.DynamicVal,
+			"fastly_service_acl_entries": cty.DynamicVal,
+		},
+		Functions: map[string]function.Function{
+			"file": function.New(&function.Spec{
+				Params: []function.Parameter{{Name: "path", Type: cty.String}},
+				Type:   function.StaticReturnType(cty.String),
+				Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+					return cty.StringVal("content of " + args[0].AsString()), nil
+				},
+			}),
+		},
+	}
+	assertEquivalentBody(t, src, native.Body.(*hclsyntax.Body), f.Body, ctx, nil, "")
+}
+
+func assertEquivalentBody(t *testing.T, src []byte, native *hclsyntax.Body, body hcl.Body, ctx *hcl.EvalContext, path []string, where string) {
+	t.Helper()
+
+	schema := &hcl.BodySchema{}
+	for name := range native.Attributes {
+		schema.Attributes = append(schema.Attributes, hcl.AttributeSchema{Name: name, Required: true})
+	}
+	nativeBlocks := map[string][]*hclsyntax.Block{}
+	for _, b := range native.Blocks {
+		key := blockKey(b.Type, b.Labels)
+		if len(nativeBlocks[key]) == 0 && !hasBlockSchema(schema, b.Type) {
+			var labels []string
+			for i := range b.Labels {
+				labels = append(labels, fmt.Sprintf("label%d", i))
+			}
+			schema.Blocks = append(schema.Blocks, hcl.BlockHeaderSchema{Type: b.Type, LabelNames: labels})
+		}
+		nativeBlocks[key] = append(nativeBlocks[key], b)
+	}
+
+	content, diags := body.Content(schema)
+	if diags.HasErrors() {
+		t.Errorf("%s: failed to read the JSON syntax: %v", where, diags)
+		return
+	}
+
+	for name, attr := range native.Attributes {
+		converted := content.Attributes[name]
+		if isReferenceAttribute(path, name) {
+			assertEquivalentReference(t, src, attr.Expr, converted.Expr, where+name)
+			continue
+		}
+
+		// The expressions that refer to what the context lacks, such as data sources, have to fail in the same way
+		want, wantDiags := attr.Expr.Value(ctx)
+		got, gotDiags := converted.Expr.Value(ctx)
+		if wantDiags.HasErrors() || gotDiags.HasErrors() {
+			if !wantDiags.HasErrors() || !gotDiags.HasErrors() || gotDiags[0].Detail != wantDiags[0].Detail {
+				t.Errorf("%s%s: failed to evaluate: %v, %v", where, name, wantDiags, gotDiags)
+			}
+			continue
+		}
+		if !got.RawEquals(want) {
+			t.Errorf("%s%s = %#v, want %#v", where, name, got, want)
+		}
+	}
+
+	convertedBlocks := map[string][]*hcl.Block{}
+	for _, b := range content.Blocks {
+		key := blockKey(b.Type, b.Labels)
+		convertedBlocks[key] = append(convertedBlocks[key], b)
+	}
+	var keys []string
+	for key := range nativeBlocks {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if len(convertedBlocks[key]) != len(nativeBlocks[key]) {
+			t.Errorf("%s%s: %d blocks, want %d", where, key, len(convertedBlocks[key]), len(nativeBlocks[key]))
+			continue
+		}
+		for i, b := range nativeBlocks[key] {
+			nested := append(append([]string(nil), path...), b.Type)
+			assertEquivalentBody(t, src, b.Body, convertedBlocks[key][i].Body, ctx, nested, fmt.Sprintf("%s%s[%d].", where, key, i))
+		}
+	}
+	if len(convertedBlocks) != len(nativeBlocks) {
+		t.Errorf("%s: %d kinds of blocks, want %d", where, len(convertedBlocks), len(nativeBlocks))
+	}
+}
+
+// assertEquivalentReference checks that the references, which are strings in the JSON syntax, are written as in the native syntax
+func assertEquivalentReference(t *testing.T, src []byte, native hclsyntax.Expression, converted hcl.Expression, where string) {
+	t.Helper()
+	var want []string
+	if tuple, ok := native.(*hclsyntax.TupleConsExpr); ok {
+		for _, e := range tuple.Exprs {
+			want = append(want, string(e.Range().SliceBytes(src)))
+		}
+	} else {
+		want = []string{string(native.Range().SliceBytes(src))}
+	}
+
+	v, diags := converted.Value(nil)
+	if diags.HasErrors() {
+		t.Errorf("%s: failed to read the reference: %v", where, diags)
+		return
+	}
+	var got []string
+	if v.Type() == cty.String {
+		got = []string{v.AsString()}
+	} else {
+		for it := v.ElementIterator(); it.Next(); {
+			_, e := it.Element()
+			got = append(got, e.AsString())
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("%s = %q, want %q", where, got, want)
+	}
+}
+
+func blockKey(blockType string, labels []string) string {
+	return strings.Join(append([]string{blockType}, labels...), ".")
+}
+
+func hasBlockSchema(schema *hcl.BodySchema, blockType string) bool {
+	for _, b := range schema.Blocks {
+		if b.Type == blockType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,198 @@
+package tfconf
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/ext/typeexpr"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/hrmsk66/terraformify/pkg/naming"
+	"github.com/hrmsk66/terraformify/pkg/prop"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// ModuleInput is a value of the service that differs between environments, such as a domain or a backend address,
+// which is lifted into an input variable of the module
+type ModuleInput struct {
+	// Key is the name of the variable
+	Key         string
+	Description string
+	Value       cty.Value
+}
+
+// moduleInputs are the attributes lifted into the inputs of the module: the name of the service, the domains,
+// the hosts, the ports and the shield POPs of the backends and the endpoints of the logging blocks
+var moduleInputs = environmentAttributes("fastly_service_vcl", "fastly_service_compute")
+
+// environmentAttributes returns the attributes the service resources and their nested blocks have that differ between environments
+func environmentAttributes(resourceTypes ...string) BlockAttributes {
+	nested := map[string][]string{
+		"domain":                   {"name"},
+		"backend":                  {"address", "port", "override_host", "ssl_cert_hostname", "ssl_sni_hostname", "shield"},
+		"logging_bigquery":         {"project_id", "dataset", "table"},
+		"logging_blobstorage":      {"account_name", "container"},
+		"logging_cloudfiles":       {"bucket_name"},
+		"logging_digitalocean":     {"bucket_name", "domain"},
+		"logging_elasticsearch":    {"url", "index"},
+		"logging_ftp":              {"address", "port"},
+		"logging_gcs":              {"bucket_name", "project_id"},
+		"logging_googlepubsub":     {"project_id", "topic"},
+		"logging_heroku":           {"url"},
+		"logging_honeycomb":        {"dataset"},
+		"logging_https":            {"url"},
+		"logging_kafka":            {"brokers", "topic"},
+		"logging_kinesis":          {"topic"},
+		"logging_logshuttle":       {"url"},
+		"logging_newrelicotlp":     {"url"},
+		"logging_openstack":        {"url", "bucket_name"},
+		"logging_papertrail":       {"address", "port"},
+		"logging_s3":               {"bucket_name", "domain"},
+		"logging_sftp":             {"address", "port"},
+		"logging_splunk":           {"url"},
+		"logging_sumologic":        {"url"},
+		"logging_syslog":           {"address", "port"},
+		"logging_grafanacloudlogs": {"url"},
+	}
+
+	attrs := BlockAttributes{}
+	for _, resourceType := range resourceTypes {
+		attrs[resourceType] = []string{"name"}
+		for blockType, a := range nested {
+			attrs[resourceType+"."+blockType] = a
+		}
+	}
+	return attrs
+}
+
+// LiftModuleInputs replaces the values of the service that differ between environments with references to variables,
+// and returns the variables with the values they replaced. Only the literal values are lifted, so the sensitive attributes
+// already moved to variables are left as they are. The names of the variables do not clash with those of the sensitive attributes.
+func (tfconf *TFConf) LiftModuleInputs(resourceType string, sensitiveAttrs []SensitiveAttr) []ModuleInput {
+	l := &inputLifter{taken: map[string]bool{}}
+	for _, attr := range sensitiveAttrs {
+		l.taken[attr.Key] = true
+	}
+
+	for _, block := range tfconf.Body().Blocks() {
+		if labels := block.Labels(); block.Type() != "resource" || len(labels) != 2 || labels[0] != resourceType {
+			continue
+		}
+		body := block.Body()
+		l.lift(body, "name", "service_name", "name of the service")
+
+		domains := 0
+		for _, nested := range body.Blocks() {
+			blockType := nested.Type()
+			if blockType == "domain" {
+				// The name of a domain block is the domain itself, which differs between environments
+				domains++
+				key := "domain"
+				if domains > 1 {
+					key = fmt.Sprintf("domain_%d", domains)
+				}
+				l.lift(nested.Body(), "name", key, "domain of the service")
+				continue
+			}
+
+			attrs := moduleInputs[resourceType+"."+blockType]
+			if len(attrs) == 0 {
+				continue
+			}
+			name, err := getStringAttributeValue(nested, "name")
+			if err != nil {
+				continue
+			}
+			for _, attr := range attrs {
+				l.lift(nested.Body(), attr, naming.Normalize(name)+"_"+attr, fmt.Sprintf("%s of the %s %s", attr, name, blockType))
+			}
+		}
+	}
+	return l.inputs
+}
+
+// inputLifter collects the inputs of the module
+type inputLifter struct {
+	inputs []ModuleInput
+	taken  map[string]bool
+}
+
+// lift replaces the literal value of the attribute with a reference to a variable
+func (l *inputLifter) lift(body *hclwrite.Body, attrName, key, description string) {
+	attr := body.GetAttribute(attrName)
+	if attr == nil {
+		return
+	}
+	v, ok := literalValue(attr)
+	if !ok || v.IsNull() || (v.Type() == cty.String && v.AsString() == "") {
+		return
+	}
+
+	base := key
+	for i := 2; l.taken[key]; i++ {
+		key = fmt.Sprintf("%s_%d", base, i)
+	}
+	l.taken[key] = true
+
+	body.SetAttributeTraversal(attrName, buildVariableRef(key))
+	l.inputs = append(l.inputs, ModuleInput{Key: key, Description: description, Value: v})
+}
+
+// literalValue returns the value of the attribute if it is a literal, such as a string or a number
+func literalValue(attr *hclwrite.Attribute) (cty.Value, bool) {
+	src := attr.Expr().BuildTokens(nil).Bytes()
+	expr, diags := hclsyntax.ParseExpression(src, "", hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return cty.NilVal, false
+	}
+	v, diags := expr.Value(nil)
+	if diags.HasErrors() || !v.IsWhollyKnown() {
+		return cty.NilVal, false
+	}
+	return v, true
+}
+
+// BuildInputVariables builds the variable blocks of the inputs of the module
+func BuildInputVariables(inputs []ModuleInput) []byte {
+	f := hclwrite.NewEmptyFile()
+	rootBody := f.Body()
+
+	for i, input := range inputs {
+		if i != 0 {
+			rootBody.AppendNewline()
+		}
+
+		varBody := rootBody.AppendNewBlock("variable", []string{input.Key}).Body()
+		varBody.SetAttributeValue("description", cty.StringVal(input.Description))
+		varBody.SetAttributeRaw("type", typeTokens(input.Value.Type()))
+	}
+
+	return f.Bytes()
+}
+
+// typeTokens returns the type constraint of a variable of the type, such as list(string).
+// Unlike the friendly names of cty, such as "list of string", it is valid in the configuration.
+func typeTokens(t cty.Type) hclwrite.Tokens {
+	return hclwrite.Tokens{{Type: hclsyntax.TokenIdent, Bytes: []byte(typeexpr.TypeString(t))}}
+}
+
+// BuildModuleOutputs builds the outputs of the module, the ID and the active version of the service
+func BuildModuleOutputs(p prop.TFBlock) []byte {
+	f := hclwrite.NewEmptyFile()
+	rootBody := f.Body()
+
+	for i, attr := range []string{"id", "active_version"} {
+		if i != 0 {
+			rootBody.AppendNewline()
+		}
+
+		outputBody := rootBody.AppendNewBlock("output", []string{p.GetNormalizedName() + "_" + attr}).Body()
+		outputBody.SetAttributeTraversal("value", hcl.Traversal{
+			hcl.TraverseRoot{Name: p.GetType()},
+			hcl.TraverseAttr{Name: p.GetNormalizedName()},
+			hcl.TraverseAttr{Name: attr},
+		})
+	}
+
+	return f.Bytes()
+}
@@ -0,0 +1,96 @@
+package tfconf
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestLiftModuleInputs(t *testing.T) {
+	conf, err := LoadFile([]byte(`resource "fastly_service_vcl" "service" {
+  name = "test"
+  backend {
+    address        = "httpbin.org"
+    name           = "httpbin"
+    port           = 443
+    override_host  = ""
+    ssl_client_key = var.httpbin_ssl_client_key
+  }
+  domain {
+    name = "test.terraformify.me"
+  }
+  domain {
+    name = "www.terraformify.me"
+  }
+  logging_https {
+    name = "https"
+    url  = "https://example.com/${var.path}"
+  }
+}
+
+resource "fastly_service_acl_entries" "allow_list" {
+  service_id = fastly_service_vcl.service.id
+}
+`), "service.tf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The variable of the sensitive attribute takes the name first
+	sensitiveAttrs := []SensitiveAttr{{Key: "httpbin_address"}}
+	inputs := conf.LiftModuleInputs("fastly_service_vcl", sensitiveAttrs)
+
+	// The empty and the non-literal values are left as they are
+	expected := []ModuleInput{
+		{Key: "service_name", Description: "name of the service", Value: cty.StringVal("test")},
+		{Key: "httpbin_address_2", Description: "address of the httpbin backend", Value: cty.StringVal("httpbin.org")},
+		{Key: "httpbin_port", Description: "port of the httpbin backend", Value: cty.NumberIntVal(443)},
+		{Key: "domain", Description: "domain of the service", Value: cty.StringVal("test.terraformify.me")},
+		{Key: "domain_2", Description: "domain of the service", Value: cty.StringVal("www.terraformify.me")},
+	}
+	if len(inputs) != len(expected) {
+		t.Fatalf("LiftModuleInputs = %+v, want %+v", inputs, expected)
+	}
+	for i := range expected {
+		if inputs[i].Key != expected[i].Key || inputs[i].Description != expected[i].Description || !inputs[i].Value.RawEquals(expected[i].Value) {
+			t.Errorf("LiftModuleInputs[%d] = %+v, want %+v", i, inputs[i], expected[i])
+		}
+	}
+
+	output := strings.Join(strings.Fields(string(conf.Bytes())), " ")
+	for _, expected := range []string{
+		"name = var.service_name",
+		"address = var.httpbin_address_2",
+		"port = var.httpbin_port",
+		`override_host = ""`,
+		"name = var.domain_2",
+		`url = "https://example.com/${var.path}"`,
+		"service_id = fastly_service_vcl.service.id",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("output does not contain %q:\n%s", expected, conf.Bytes())
+		}
+	}
+}
+
+func TestBuildInputVariables(t *testing.T) {
+	variables := string(BuildInputVariables([]ModuleInput{
+		{Key: "service_name", Description: "name of the service", Value: cty.StringVal("test")},
+		{Key: "httpbin_port", Description: "port of the httpbin backend", Value: cty.NumberIntVal(443)},
+		{Key: "domains", Description: "domains of the service", Value: cty.ListVal([]cty.Value{cty.StringVal("test.example.com")})},
+	}))
+
+	conf, err := LoadFile([]byte(variables), "variables.tf")
+	if err != nil {
+		t.Fatalf("BuildInputVariables output does not parse: %v\n%s", err, variables)
+	}
+	var types []string
+	for _, block := range conf.Body().Blocks() {
+		types = append(types, strings.TrimSpace(string(block.Body().GetAttribute("type").Expr().BuildTokens(nil).Bytes())))
+	}
+	if expected := []string{"string", "number", "list(string)"}; !reflect.DeepEqual(types, expected) {
+		t.Errorf("types = %q, want %q:\n%s", types, expected, variables)
+	}
+}
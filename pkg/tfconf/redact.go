@@ -0,0 +1,81 @@
+package tfconf
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	hcljson "github.com/hashicorp/hcl/v2/json"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// RedactedValue replaces the values of the sensitive variables in the files shown to the user, as in the Terraform plans
+const RedactedValue = "(sensitive value)"
+
+var variableSchema = &hcl.BodySchema{
+	Blocks: []hcl.BlockHeaderSchema{{Type: "variable", LabelNames: []string{"name"}}},
+}
+
+var sensitiveSchema = &hcl.BodySchema{
+	Attributes: []hcl.AttributeSchema{{Name: "sensitive"}},
+}
+
+// parseFile parses the file in the JSON syntax if its name ends with .json, and in the native syntax otherwise
+func parseFile(src []byte, filename string) (*hcl.File, hcl.Diagnostics) {
+	if strings.HasSuffix(filename, ".json") {
+		return hcljson.Parse(src, filename)
+	}
+	return hclsyntax.ParseConfig(src, filename, hcl.Pos{Line: 1, Column: 1})
+}
+
+// SensitiveVariables returns the names of the variables declared sensitive in the configuration file
+func SensitiveVariables(src []byte, filename string) (map[string]bool, error) {
+	f, diags := parseFile(src, filename)
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("tfconf: failed to parse %s: %w", filename, diags)
+	}
+
+	names := map[string]bool{}
+	content, _, _ := f.Body.PartialContent(variableSchema)
+	for _, block := range content.Blocks {
+		vc, _, _ := block.Body.PartialContent(sensitiveSchema)
+		attr, ok := vc.Attributes["sensitive"]
+		if !ok {
+			continue
+		}
+		if v, diags := attr.Expr.Value(nil); !diags.HasErrors() && v.IsKnown() && !v.IsNull() && v.Type() == cty.Bool && v.True() {
+			names[block.Labels[0]] = true
+		}
+	}
+	return names, nil
+}
+
+// RedactTFVars returns the variable definitions file, such as terraform.tfvars or terraform.tfvars.json,
+// with the values of the variables of the names replaced with RedactedValue
+func RedactTFVars(src []byte, filename string, names map[string]bool) ([]byte, error) {
+	f, diags := parseFile(src, filename)
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("tfconf: failed to parse %s: %w", filename, diags)
+	}
+	attrs, diags := f.Body.JustAttributes()
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("tfconf: failed to read the values in %s: %w", filename, diags)
+	}
+
+	var ranges []hcl.Range
+	for name, attr := range attrs {
+		if names[name] {
+			ranges = append(ranges, attr.Expr.Range())
+		}
+	}
+	// Replace the values from the end, so that the byte offsets of the rest stay the same
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Start.Byte > ranges[j].Start.Byte })
+
+	redacted := string(src)
+	for _, r := range ranges {
+		redacted = redacted[:r.Start.Byte] + RedactedValue + redacted[r.End.Byte:]
+	}
+	return []byte(redacted), nil
+}
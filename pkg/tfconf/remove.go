@@ -0,0 +1,205 @@
+package tfconf
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+// LoadFile parses a configuration file written by the import, such as the TF file of a service or variables.tf
+func LoadFile(src []byte, filename string) (*TFConf, error) {
+	f, diags := hclwrite.ParseConfig(src, filename, hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("tfconf: failed to parse %s: %s", filename, diags)
+	}
+	return &TFConf{f}, nil
+}
+
+// ResourceAddresses returns the addresses of the resources and the data sources in the configuration,
+// such as "fastly_service_vcl.service" and "data.fastly_package_hash.service"
+func (tfconf *TFConf) ResourceAddresses() []string {
+	var addrs []string
+	for _, block := range tfconf.Body().Blocks() {
+		labels := block.Labels()
+		if len(labels) != 2 {
+			continue
+		}
+		switch block.Type() {
+		case "resource":
+			addrs = append(addrs, labels[0]+"."+labels[1])
+		case "data":
+			addrs = append(addrs, "data."+labels[0]+"."+labels[1])
+		}
+	}
+	return addrs
+}
+
+// VariableRefs returns the names of the variables referred to in the configuration
+func (tfconf *TFConf) VariableRefs() (map[string]bool, error) {
+	f, diags := hclsyntax.ParseConfig(tfconf.Bytes(), "", hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("tfconf: %s", diags)
+	}
+
+	refs := map[string]bool{}
+	var walk func(body *hclsyntax.Body)
+	walk = func(body *hclsyntax.Body) {
+		for _, attr := range body.Attributes {
+			for _, traversal := range attr.Expr.Variables() {
+				if traversal.RootName() != "var" || len(traversal) < 2 {
+					continue
+				}
+				if step, ok := traversal[1].(hcl.TraverseAttr); ok {
+					refs[step.Name] = true
+				}
+			}
+		}
+		for _, block := range body.Blocks {
+			walk(block.Body)
+		}
+	}
+	walk(f.Body.(*hclsyntax.Body))
+	return refs, nil
+}
+
+// RemoveVariables removes the variable blocks of the names, as written by BuildVariableDefinitions.
+// It reports whether any block was removed.
+func (tfconf *TFConf) RemoveVariables(names map[string]bool) bool {
+	removed := false
+	body := tfconf.Body()
+	for _, block := range body.Blocks() {
+		if labels := block.Labels(); block.Type() == "variable" && len(labels) == 1 && names[labels[0]] {
+			removed = body.RemoveBlock(block) || removed
+		}
+	}
+	return removed
+}
+
+// RemoveAttributes removes the top-level attributes of the names, such as the values in terraform.tfvars.
+// It reports whether any attribute was removed.
+func (tfconf *TFConf) RemoveAttributes(names map[string]bool) bool {
+	return removeAttributes(tfconf.Body(), names)
+}
+
+func removeAttributes(body *hclwrite.Body, names map[string]bool) bool {
+	removed := false
+	for name := range body.Attributes() {
+		if names[name] {
+			removed = body.RemoveAttribute(name) != nil || removed
+		}
+	}
+	return removed
+}
+
+// RemoveModuleArguments removes the arguments of the names from the module block, as written by BuildModuleBlock.
+// It reports whether any argument was removed.
+func (tfconf *TFConf) RemoveModuleArguments(moduleName string, names map[string]bool) bool {
+	removed := false
+	for _, block := range tfconf.Body().Blocks() {
+		if labels := block.Labels(); block.Type() == "module" && len(labels) == 1 && labels[0] == moduleName {
+			removed = removeAttributes(block.Body(), names) || removed
+		}
+	}
+	return removed
+}
+
+// RemoveModuleBlock removes the module block of the name, as written to main.tf by BuildModuleBlock.
+// It reports whether the block was removed.
+func (tfconf *TFConf) RemoveModuleBlock(moduleName string) bool {
+	removed := false
+	body := tfconf.Body()
+	for _, block := range body.Blocks() {
+		if labels := block.Labels(); block.Type() == "module" && len(labels) == 1 && labels[0] == moduleName {
+			removed = body.RemoveBlock(block) || removed
+		}
+	}
+	return removed
+}
+
+// HasModuleBlock reports whether the configuration has the module block of the name
+func (tfconf *TFConf) HasModuleBlock(moduleName string) bool {
+	for _, block := range tfconf.Body().Blocks() {
+		if labels := block.Labels(); block.Type() == "module" && len(labels) == 1 && labels[0] == moduleName {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveOutputBlocks removes the output blocks whose value refers to one of the addresses, as written by BuildModuleOutputs.
+// It reports whether any block was removed.
+func (tfconf *TFConf) RemoveOutputBlocks(addresses map[string]bool) bool {
+	removed := false
+	body := tfconf.Body()
+	for _, block := range body.Blocks() {
+		if block.Type() != "output" {
+			continue
+		}
+		value := block.Body().GetAttribute("value")
+		if value == nil {
+			continue
+		}
+		expr, diags := hclsyntax.ParseExpression(value.Expr().BuildTokens(nil).Bytes(), "", hcl.Pos{Line: 1, Column: 1})
+		if diags.HasErrors() {
+			continue
+		}
+		for _, traversal := range expr.Variables() {
+			if len(traversal) < 2 {
+				continue
+			}
+			name, ok := traversal[1].(hcl.TraverseAttr)
+			if ok && addresses[traversal.RootName()+"."+name.Name] {
+				removed = body.RemoveBlock(block) || removed
+				break
+			}
+		}
+	}
+	return removed
+}
+
+// RemoveImportBlocks removes the import blocks whose "to" is one of the addresses or an instance of one of them,
+// as written by BuildImportBlocks. It reports whether any block was removed.
+func (tfconf *TFConf) RemoveImportBlocks(addresses map[string]bool) bool {
+	removed := false
+	body := tfconf.Body()
+	for _, block := range body.Blocks() {
+		if block.Type() != "import" {
+			continue
+		}
+		to := block.Body().GetAttribute("to")
+		if to == nil {
+			continue
+		}
+		addr := strings.TrimSpace(string(to.Expr().BuildTokens(nil).Bytes()))
+		if i := strings.Index(addr, "["); i != -1 {
+			addr = addr[:i]
+		}
+		if addresses[addr] {
+			removed = body.RemoveBlock(block) || removed
+		}
+	}
+	return removed
+}
+
+// IsEmpty reports whether the configuration has no attributes or blocks left
+func (tfconf *TFConf) IsEmpty() bool {
+	body := tfconf.Body()
+	return len(body.Attributes()) == 0 && len(body.Blocks()) == 0
+}
+
+var blankLines = regexp.MustCompile(`\n{3,}`)
+
+// TidyBytes returns the configuration with the blank lines left by the removed blocks and attributes collapsed
+func (tfconf *TFConf) TidyBytes() []byte {
+	b := hclwrite.Format(tfconf.Bytes())
+	b = blankLines.ReplaceAll(b, []byte("\n\n"))
+	s := strings.TrimLeft(string(b), "\n")
+	if s != "" && !strings.HasSuffix(s, "\n") {
+		s += "\n"
+	}
+	return []byte(s)
+}
@@ -0,0 +1,169 @@
+package tfconf
+
+import (
+	"sort"
+
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	tfjson "github.com/hashicorp/terraform-json"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// BlockAttributes maps a block to some of its attributes.
+// The block is named by the resource type followed by the types of the nested blocks it is in, such as "fastly_service_vcl.acl".
+type BlockAttributes map[string][]string
+
+// Schema tells the rewrites how to handle the attributes of the resources, as read from the provider schema
+type Schema struct {
+	// Computed are the attributes computed by the provider, which cannot be set in the configuration
+	Computed BlockAttributes
+	// Sensitive are the string attributes whose values are moved to variables
+	Sensitive BlockAttributes
+	// Sets are the nested blocks with the set nesting mode, named as in BlockAttributes
+	Sets map[string]bool
+}
+
+// KnownSchema holds the attributes known to be computed or sensitive as of fastly provider v5.
+// It is used when the schema of the installed provider cannot be read.
+var KnownSchema = &Schema{
+	Computed: BlockAttributes{
+		"fastly_service_vcl":                   {"active_version", "cloned_version", "imported"},
+		"fastly_service_vcl.acl":               {"acl_id"},
+		"fastly_service_vcl.dictionary":        {"dictionary_id"},
+		"fastly_service_vcl.waf":               {"waf_id"},
+		"fastly_service_vcl.dynamicsnippet":    {"snippet_id"},
+		"fastly_service_vcl.rate_limiter":      {"ratelimiter_id"},
+		"fastly_service_compute":               {"active_version", "cloned_version", "imported"},
+		"fastly_service_compute.dictionary":    {"dictionary_id"},
+		"fastly_service_compute.resource_link": {"link_id"},
+		"fastly_service_waf_configuration":     {"active", "cloned_version", "number"},
+	},
+	Sensitive: knownSensitiveAttributes("fastly_service_vcl", "fastly_service_compute"),
+	Sets:      knownSets("fastly_service_vcl", "fastly_service_compute"),
+}
+
+// knownSensitiveBlocks are the sensitive attributes of the nested blocks both service resources have
+var knownSensitiveBlocks = map[string][]string{
+	"backend":               {"ssl_client_cert", "ssl_client_key"},
+	"logging_bigquery":      {"email", "secret_key"},
+	"logging_blobstorage":   {"sas_token"},
+	"logging_cloudfiles":    {"access_key"},
+	"logging_datadog":       {"token"},
+	"logging_digitalocean":  {"access_key", "secret_key"},
+	"logging_elasticsearch": {"password", "tls_client_key"},
+	"logging_ftp":           {"password"},
+	"logging_gcs":           {"secret_key"},
+	"logging_googlepubsub":  {"secret_key"},
+	"logging_heroku":        {"token"},
+	"logging_honeycomb":     {"token"},
+	"logging_https":         {"tls_client_key"},
+	"logging_kafka":         {"password", "tls_client_key"},
+	"logging_kinesis":       {"access_key", "secret_key"},
+	"logging_loggly":        {"token"},
+	"logging_logshuttle":    {"token"},
+	"logging_newrelic":      {"token"},
+	"logging_openstack":     {"access_key"},
+	"logging_s3":            {"s3_access_key", "s3_secret_key"},
+	"logging_scalyr":        {"token"},
+	"logging_sftp":          {"password", "secret_key"},
+	"logging_splunk":        {"tls_client_key", "token"},
+	"logging_syslog":        {"tls_client_key"},
+}
+
+// knownSensitiveAttributes returns the sensitive attributes of the nested blocks of the resources
+func knownSensitiveAttributes(resourceTypes ...string) BlockAttributes {
+	sensitive := BlockAttributes{}
+	for _, resourceType := range resourceTypes {
+		for blockType, attrs := range knownSensitiveBlocks {
+			sensitive[resourceType+"."+blockType] = attrs
+		}
+	}
+	return sensitive
+}
+
+// knownSets returns the nested blocks with sensitive attributes of the resources, which are all sets in the provider
+func knownSets(resourceTypes ...string) map[string]bool {
+	sets := map[string]bool{}
+	for _, resourceType := range resourceTypes {
+		for blockType := range knownSensitiveBlocks {
+			sets[resourceType+"."+blockType] = true
+		}
+	}
+	return sets
+}
+
+// SchemaFrom reads the computed and the sensitive attributes of each resource in the provider schema and of its nested blocks
+func SchemaFrom(provider *tfjson.ProviderSchema) *Schema {
+	schema := &Schema{Computed: BlockAttributes{}, Sensitive: BlockAttributes{}, Sets: map[string]bool{}}
+	for resourceType, s := range provider.ResourceSchemas {
+		if s != nil {
+			schema.add(resourceType, s.Block)
+		}
+	}
+	return schema
+}
+
+func (schema *Schema) add(name string, block *tfjson.SchemaBlock) {
+	if block == nil {
+		return
+	}
+
+	var computed, sensitive []string
+	for attr, s := range block.Attributes {
+		if s == nil {
+			continue
+		}
+		// Attributes that are also optional, such as "id", can be set
+		if s.Computed && !s.Optional && !s.Required {
+			computed = append(computed, attr)
+		}
+		if s.Sensitive && s.AttributeType.Equals(cty.String) {
+			sensitive = append(sensitive, attr)
+		}
+	}
+	if len(computed) > 0 {
+		sort.Strings(computed)
+		schema.Computed[name] = computed
+	}
+	if len(sensitive) > 0 {
+		sort.Strings(sensitive)
+		schema.Sensitive[name] = sensitive
+	}
+
+	for blockType, s := range block.NestedBlocks {
+		if s != nil {
+			if s.NestingMode == tfjson.SchemaNestingModeSet {
+				schema.Sets[name+"."+blockType] = true
+			}
+			schema.add(name+"."+blockType, s.Block)
+		}
+	}
+}
+
+// isSensitive reports whether the attribute of the block is sensitive
+func (schema *Schema) isSensitive(block, attr string) bool {
+	for _, a := range schema.Sensitive[block] {
+		if a == attr {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveComputedAttributes removes the computed attributes from the resource blocks and their nested blocks.
+// Terraform rejects a configuration that sets them.
+func (tfconf *TFConf) RemoveComputedAttributes(computed BlockAttributes) {
+	for _, block := range tfconf.Body().Blocks() {
+		if labels := block.Labels(); block.Type() == "resource" && len(labels) == 2 {
+			removeComputedAttributes(block.Body(), labels[0], computed)
+		}
+	}
+}
+
+func removeComputedAttributes(body *hclwrite.Body, name string, computed BlockAttributes) {
+	for _, attr := range computed[name] {
+		body.RemoveAttribute(attr)
+	}
+	for _, block := range body.Blocks() {
+		removeComputedAttributes(block.Body(), name+"."+block.Type(), computed)
+	}
+}
@@ -0,0 +1,123 @@
+package tfconf
+
+import (
+	"regexp"
+	"sort"
+
+	"github.com/hrmsk66/terraformify/pkg/tfstate"
+)
+
+// SecretLookingValue is a value that looks like a secret in an attribute the provider schema does not mark sensitive.
+// The value is written to the TF file, or to a file read by it, in plain text.
+type SecretLookingValue struct {
+	// BlockType and Name locate the nested block of the service the attribute is in. Both are empty for an attribute of the service.
+	BlockType string
+	Name      string
+	// Attribute is the name of the attribute, prefixed with the types of the blocks it is in under the nested block, such as "response.content"
+	Attribute string
+	// Reason tells what the value looks like, such as "a private key"
+	Reason string
+}
+
+var secretPatterns = []struct {
+	reason string
+	re     *regexp.Regexp
+}{
+	{"a private key", regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`)},
+	{"an AWS access key ID", regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"a GitHub token", regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36}\b`)},
+	{"a Slack token", regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`)},
+	{"a JSON Web Token", regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{10,}\.eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]+`)},
+	{"a URL with a password", regexp.MustCompile(`://[^/\s:@]+:[^/\s@]+@`)},
+}
+
+// secretName matches the names of the attributes that usually hold a secret
+var secretName = regexp.MustCompile(`(^|_)(password|secret|token|api_?key|credentials?)(_|$)`)
+
+// secretLike matches a value long enough and free of spaces to be a password or a token
+var secretLike = regexp.MustCompile(`^\S{8,}$`)
+
+// FindSecretLookingValues returns the values of the service that look like secrets in the attributes that are not sensitive.
+// The sensitive attributes are moved to variables by RewriteResources, while the others are written as they are.
+func FindSecretLookingValues(s *tfstate.TFState, resourceType, serviceID string, schema *Schema) []SecretLookingValue {
+	var found []SecretLookingValue
+	for _, r := range s.ResourcesWithID(serviceID) {
+		if r.Type != resourceType {
+			continue
+		}
+		for _, i := range r.Instances {
+			for _, attr := range sortedKeys(i.Attributes) {
+				v := i.Attributes[attr]
+				if objs, ok := blocks(v); ok {
+					for _, obj := range objs {
+						name, _ := obj["name"].(string)
+						findSecretLookingValues(&found, obj, resourceType+"."+attr, attr, name, "", schema)
+					}
+					continue
+				}
+				if reason := secretReason(attr, v); reason != "" && !schema.isSensitive(resourceType, attr) {
+					found = append(found, SecretLookingValue{Attribute: attr, Reason: reason})
+				}
+			}
+		}
+	}
+	return found
+}
+
+func findSecretLookingValues(found *[]SecretLookingValue, obj map[string]interface{}, block, blockType, name, prefix string, schema *Schema) {
+	for _, attr := range sortedKeys(obj) {
+		v := obj[attr]
+		if objs, ok := blocks(v); ok {
+			for _, o := range objs {
+				findSecretLookingValues(found, o, block+"."+attr, blockType, name, prefix+attr+".", schema)
+			}
+			continue
+		}
+		if reason := secretReason(attr, v); reason != "" && !schema.isSensitive(block, attr) {
+			*found = append(*found, SecretLookingValue{BlockType: blockType, Name: name, Attribute: prefix + attr, Reason: reason})
+		}
+	}
+}
+
+// secretReason tells what the value of the attribute looks like if it looks like a secret, or returns an empty string
+func secretReason(attr string, v interface{}) string {
+	value, ok := v.(string)
+	if !ok || value == "" {
+		return ""
+	}
+	for _, p := range secretPatterns {
+		if p.re.MatchString(value) {
+			return p.reason
+		}
+	}
+	if secretName.MatchString(attr) && secretLike.MatchString(value) {
+		return "a secret by the name of the attribute"
+	}
+	return ""
+}
+
+// blocks returns the value as a list of nested blocks if it is one
+func blocks(v interface{}) ([]map[string]interface{}, bool) {
+	list, ok := v.([]interface{})
+	if !ok || len(list) == 0 {
+		return nil, false
+	}
+	objs := make([]map[string]interface{}, 0, len(list))
+	for _, e := range list {
+		obj, ok := e.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		objs = append(objs, obj)
+	}
+	return objs, true
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,39 @@
+package tfconf
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hrmsk66/terraformify/pkg/tfstate"
+)
+
+func TestFindSecretLookingValues(t *testing.T) {
+	s, err := tfstate.Parse([]byte(serviceState))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The token is sensitive in the schema of the installed provider, and the header value is not
+	values := FindSecretLookingValues(s, "fastly_service_vcl", "7ManTUgtlSytxeXRMPYY33", readSchema(t))
+	expected := []SecretLookingValue{{
+		BlockType: "logging_https",
+		Name:      "https",
+		Attribute: "header_value",
+		Reason:    "a GitHub token",
+	}}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("FindSecretLookingValues = %+v, want %+v", values, expected)
+	}
+
+	// The provider that does not know the endpoint leaves its token in plain text
+	values = FindSecretLookingValues(s, "fastly_service_vcl", "7ManTUgtlSytxeXRMPYY33", KnownSchema)
+	expected = append(expected, SecretLookingValue{
+		BlockType: "logging_newrelicotlp",
+		Name:      "otlp",
+		Attribute: "token",
+		Reason:    "a secret by the name of the attribute",
+	})
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("FindSecretLookingValues with KnownSchema = %+v, want %+v", values, expected)
+	}
+}
@@ -27,10 +27,87 @@ type TFConf struct {
 	*hclwrite.File
 }
 
+// SensitiveAttr is a sensitive attribute of a nested block of the service, which is moved to a variable
 type SensitiveAttr struct {
+	// BlockType, Index and Attribute locate the attribute in the state, such as the secret key of the second logging_s3 block
 	BlockType string
-	Key       string
-	Value     string
+	Index     int
+	Attribute string
+	// Set tells whether the blocks of the type are a set, whose elements are not addressed by their index
+	Set bool
+	// Key is the name of the variable
+	Key   string
+	Value string
+}
+
+// Path returns the path to the attribute in the state, or to the blocks of the type if they are a set
+func (attr SensitiveAttr) Path() tfstate.Path {
+	if attr.Set {
+		return tfstate.BlockPath(attr.BlockType)
+	}
+	return tfstate.AttributePath(attr.BlockType, attr.Index, attr.Attribute)
+}
+
+// newSensitiveAttr looks up the index of the named nested block in the state
+func newSensitiveAttr(s *tfstate.TFState, serviceID, blockType, name, attribute, varName, value string, set bool) (SensitiveAttr, error) {
+	index, err := s.NestedBlockIndexQuery(tfstate.NestedBlockIndexQueryParams{
+		ServiceId:       serviceID,
+		NestedBlockName: blockType,
+		Name:            name,
+	})
+	if err != nil {
+		return SensitiveAttr{}, err
+	}
+	return SensitiveAttr{
+		BlockType: blockType,
+		Index:     index,
+		Attribute: attribute,
+		Set:       set,
+		Key:       varName,
+		Value:     value,
+	}, nil
+}
+
+// rewriteSensitiveAttributes replaces the values of the sensitive attributes of the nested block with references to variables
+// and returns the attributes, so that the values are written to terraform.tfvars instead of the TF file.
+// Attributes left empty are kept as they are.
+func rewriteSensitiveAttributes(nestedBlock *hclwrite.Block, resourceType string, schema *Schema, s *tfstate.TFState, c *cli.Config) ([]SensitiveAttr, error) {
+	blockType := nestedBlock.Type()
+	keys := schema.Sensitive[resourceType+"."+blockType]
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	name, err := getStringAttributeValue(nestedBlock, "name")
+	if err != nil {
+		return nil, fmt.Errorf("tfconf: failed to read the name of the %s block to move its sensitive attributes to variables: %w", blockType, err)
+	}
+
+	var attrs []SensitiveAttr
+	for _, key := range keys {
+		v, err := s.ServiceQuery(tfstate.ServiceQueryParams{
+			ServiceId:       c.ID,
+			NestedBlockName: blockType,
+			Name:            name,
+			AttributeName:   key,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if v == "" {
+			continue
+		}
+
+		// the attribute names for under "logging_s3" are redundant. Removing the prefix "s3_" in the variable names
+		varName := naming.Normalize(name) + "_" + strings.TrimPrefix(key, "s3_")
+		nestedBlock.Body().SetAttributeTraversal(key, buildVariableRef(varName))
+		attr, err := newSensitiveAttr(s, c.ID, blockType, name, key, varName, v, schema.Sets[resourceType+"."+blockType])
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, attr)
+	}
+	return attrs, nil
 }
 
 func Load(rawHCL string) (*TFConf, error) {
@@ -45,6 +122,109 @@ func Load(rawHCL string) (*TFConf, error) {
 	return &TFConf{f}, nil
 }
 
+// LoadGenerated parses the configuration written by "terraform plan -generate-config-out".
+// Unlike the "terraform show" output, the generated configuration is valid HCL and needs no cleanup.
+func LoadGenerated(generatedHCL string) (*TFConf, error) {
+	// Drop the "__generated__" comments Terraform adds to the file
+	var lines []string
+	for _, line := range strings.Split(generatedHCL, "\n") {
+		t := strings.TrimSpace(line)
+		if strings.HasPrefix(t, "# __generated__") || strings.HasPrefix(t, "# Please review these resources") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	f, diags := hclwrite.ParseConfig([]byte(strings.Join(lines, "\n")), "", hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("errors: %s", diags)
+	}
+
+	// Terraform writes every optional attribute, including unset ones as null and sensitive ones as "null # sensitive"
+	// Remove them so that the output looks like the one from "terraform show"
+	removeNullAttributes(f.Body())
+
+	return &TFConf{f}, nil
+}
+
+// RestoreIDs sets the read-only ID attributes that the generated configuration omits.
+// ParseServiceResource and RewriteResources rely on them to find the resources to import and rewrite.
+func (tfconf *TFConf) RestoreIDs(s *tfstate.TFState, c *cli.Config) error {
+
+	for _, block := range tfconf.Body().Blocks() {
+		labels := block.Labels()
+		if block.Type() != "resource" || len(labels) != 2 {
+			continue
+		}
+
+		id, err := s.ResourceIDQuery(tfstate.ResourceIDQueryParams{
+			ResourceType: labels[0],
+			ResourceName: labels[1],
+		})
+		if err != nil {
+			return err
+		}
+		block.Body().SetAttributeValue("id", cty.StringVal(id))
+
+		if id != c.ID {
+			continue
+		}
+
+		// Restore the IDs of the nested blocks in the service resource
+		for _, nestedBlock := range block.Body().Blocks() {
+			var idName string
+			switch nestedBlock.Type() {
+			case "acl":
+				idName = "acl_id"
+			case "dictionary":
+				idName = "dictionary_id"
+			case "dynamicsnippet":
+				idName = "snippet_id"
+			case "waf":
+				wafID, err := s.WAFIDQuery(tfstate.WAFIDQueryParams{
+					ServiceId: c.ID,
+				})
+				if err != nil {
+					return err
+				}
+				nestedBlock.Body().SetAttributeValue("waf_id", cty.StringVal(wafID))
+				continue
+			default:
+				continue
+			}
+
+			name, err := getStringAttributeValue(nestedBlock, "name")
+			if err != nil {
+				return err
+			}
+			v, err := s.ServiceQuery(tfstate.ServiceQueryParams{
+				ServiceId:       c.ID,
+				NestedBlockName: nestedBlock.Type(),
+				Name:            name,
+				AttributeName:   idName,
+			})
+			if err != nil {
+				return err
+			}
+			nestedBlock.Body().SetAttributeValue(idName, cty.StringVal(v))
+		}
+	}
+
+	return nil
+}
+
+func removeNullAttributes(body *hclwrite.Body) {
+	for name, attr := range body.Attributes() {
+		tokens := attr.Expr().BuildTokens(nil)
+		if len(tokens) == 1 && tokens[0].Type == hclsyntax.TokenIdent && string(tokens[0].Bytes) == "null" {
+			body.RemoveAttribute(name)
+		}
+	}
+	for _, block := range body.Blocks() {
+		removeNullAttributes(block.Body())
+	}
+}
+
 func (tfconf *TFConf) ParseServiceResource(serviceProp prop.TFBlock, c *cli.Config) ([]prop.TFBlock, error) {
 	// Check top-level blocks
 	for _, block := range tfconf.Body().Blocks() {
@@ -141,13 +321,8 @@ func (tfconf *TFConf) ParseServiceResource(serviceProp prop.TFBlock, c *cli.Conf
 	return nil, errors.New("tfconf: target service resource not found")
 }
 
-func (tfconf *TFConf) RewriteResources(serviceProp prop.TFBlock, props []prop.TFBlock, c *cli.Config) ([]SensitiveAttr, error) {
-	// Read terraform.tfstate into the variable
-	state, err := tfstate.Load(c.Directory)
-	if err != nil {
-		return nil, err
-	}
-
+func (tfconf *TFConf) RewriteResources(serviceProp prop.TFBlock, props []prop.TFBlock, state *tfstate.TFState, schema *Schema, c *cli.Config, tx *file.Transaction) ([]SensitiveAttr, error) {
+	var err error
 	var sensitiveAttrs []SensitiveAttr
 	// Read resource blocks
 	for _, block := range tfconf.Body().Blocks() {
@@ -167,7 +342,7 @@ func (tfconf *TFConf) RewriteResources(serviceProp prop.TFBlock, props []prop.TF
 				continue
 			}
 
-			sensitiveAttrs, err = rewriteVCLServiceResource(block, state, c)
+			sensitiveAttrs, err = rewriteVCLServiceResource(block, state, schema, c, tx)
 			if err != nil {
 				return nil, err
 			}
@@ -186,7 +361,7 @@ func (tfconf *TFConf) RewriteResources(serviceProp prop.TFBlock, props []prop.TF
 				appendFastlyPackageHashBlock(tfconf, serviceProp, c)
 			}
 
-			sensitiveAttrs, err = rewriteComputeServiceResource(block, serviceProp, props, state, c)
+			sensitiveAttrs, err = rewriteComputeServiceResource(block, serviceProp, props, state, schema, c, tx)
 			if err != nil {
 				return nil, err
 			}
@@ -221,7 +396,7 @@ func (tfconf *TFConf) RewriteResources(serviceProp prop.TFBlock, props []prop.TF
 				continue
 			}
 
-			err = rewriteDynamicSnippetResource(block, serviceProp, state, c)
+			err = rewriteDynamicSnippetResource(block, serviceProp, state, c, tx)
 			if err != nil {
 				return nil, err
 			}
@@ -249,14 +424,8 @@ func (tfconf *TFConf) RewriteResources(serviceProp prop.TFBlock, props []prop.TF
 					return nil, err
 				}
 
-				var st *tfstate.TFStateWithTemplate
-				st, err = state.AddTemplate(tfstate.ResourceNameQueryTmplate)
-				if err != nil {
-					return nil, err
-				}
-
-				var resourceName *tfstate.TFState
-				resourceName, err = st.ResourceNameQuery(tfstate.ResourceNameQueryParams{
+				var resourceName string
+				resourceName, err = state.ResourceNameQuery(tfstate.ResourceNameQueryParams{
 					ResourceType:    serviceProp.GetType(),
 					NestedBlockName: "dictionary",
 					IDName:          "dictionary_id",
@@ -273,7 +442,7 @@ func (tfconf *TFConf) RewriteResources(serviceProp prop.TFBlock, props []prop.TF
 				}
 
 				// Add fastly_configstore resource block
-				appendFastlyConfigstoreBlock(tfconf, resourceId, resourceName.String())
+				appendFastlyConfigstoreBlock(tfconf, resourceId, resourceName)
 			} else {
 				err = rewriteDictionaryResource(block, serviceProp, state, c)
 				if err != nil {
@@ -307,20 +476,13 @@ func (tfconf *TFConf) RewriteResources(serviceProp prop.TFBlock, props []prop.TF
 	return sensitiveAttrs, nil
 }
 
-func rewriteVCLServiceResource(block *hclwrite.Block, s *tfstate.TFState, c *cli.Config) ([]SensitiveAttr, error) {
+func rewriteVCLServiceResource(block *hclwrite.Block, s *tfstate.TFState, schema *Schema, c *cli.Config, tx *file.Transaction) ([]SensitiveAttr, error) {
 	var sensitiveAttrs []SensitiveAttr
 
-	st, err := s.AddTemplate(tfstate.ServiceQueryTmplate)
-	if err != nil {
-		return nil, err
-	}
-
-	// Remove read-only attributes
+	// Remove the ID and the attributes not to be kept in the configuration.
+	// The computed attributes are removed by RemoveComputedAttributes.
 	body := block.Body()
 	body.RemoveAttribute("id")
-	body.RemoveAttribute("active_version")
-	body.RemoveAttribute("cloned_version")
-	body.RemoveAttribute("imported")
 	body.RemoveAttribute("force_refresh")
 
 	// If no service level comments are set, set blank
@@ -347,24 +509,16 @@ func rewriteVCLServiceResource(block *hclwrite.Block, s *tfstate.TFState, c *cli
 
 		switch nestedBlockType {
 		case "acl":
-			nestedBlockBody.RemoveAttribute("acl_id")
 			if c.ForceDestroy {
 				nestedBlockBody.SetAttributeValue("force_destroy", cty.BoolVal(true))
 			}
 		case "dictionary":
-			nestedBlockBody.RemoveAttribute("dictionary_id")
 			if c.ForceDestroy {
 				nestedBlockBody.SetAttributeValue("force_destroy", cty.BoolVal(true))
 			}
-		case "waf":
-			nestedBlockBody.RemoveAttribute("waf_id")
-		case "dynamicsnippet":
-			nestedBlockBody.RemoveAttribute("snippet_id")
 		case "product_enablement":
 			nestedBlockBody.RemoveAttribute("name")
 		case "rate_limiter":
-			nestedBlockBody.RemoveAttribute("ratelimiter_id")
-
 			// Get action from the nested block
 			action, err := getStringAttributeValue(nestedBlock, "action")
 			if err != nil {
@@ -377,15 +531,11 @@ func rewriteVCLServiceResource(block *hclwrite.Block, s *tfstate.TFState, c *cli
 				responseBlockBody := responseBlock.Body()
 
 				// Get content from TFState
-				t, err := s.AddTemplate(tfstate.RateLimiterContentQueryTemplate)
-				if err != nil {
-					return nil, err
-				}
 				name, err := getStringAttributeValue(nestedBlock, "name")
 				if err != nil {
 					return nil, err
 				}
-				v, err := t.RateLimiterContentQuery(tfstate.RateLimiterContentQueryParams{
+				v, err := s.RateLimiterContentQuery(tfstate.RateLimiterContentQueryParams{
 					ServiceId: c.ID,
 					Name:      name,
 				})
@@ -396,12 +546,12 @@ func rewriteVCLServiceResource(block *hclwrite.Block, s *tfstate.TFState, c *cli
 				// Save content to a file
 				ext := "txt"
 				filename := fmt.Sprintf("%s.%s", naming.Normalize(name), ext)
-				if err = file.WriteContent(c.Directory, c.ResourceName, filename, v.Bytes()); err != nil {
+				if err = tx.WriteContent(c.ResourceName, filename, []byte(v)); err != nil {
 					return nil, err
 				}
 
 				// Replace content attribute of the nested block with file function expression
-				path := filepath.Join(".", "content", c.ResourceName, filename)
+				path := filePath(c, "content", c.ResourceName, filename)
 				tokens := buildFileFunction(path)
 				responseBlockBody.SetAttributeRaw("content", tokens)
 			}
@@ -415,7 +565,7 @@ func rewriteVCLServiceResource(block *hclwrite.Block, s *tfstate.TFState, c *cli
 			}
 
 			// Get content from TFState
-			v, err := st.ServiceQuery(tfstate.ServiceQueryParams{
+			v, err := s.ServiceQuery(tfstate.ServiceQueryParams{
 				ServiceId:       c.ID,
 				NestedBlockName: nestedBlockType,
 				Name:            name,
@@ -428,7 +578,7 @@ func rewriteVCLServiceResource(block *hclwrite.Block, s *tfstate.TFState, c *cli
 			// In the provider schema, xff is an optional attribute with a default value of "append"
 			// Because of the default value, Terraform attempts to add the default value even if the value is not set for the actual service.
 			// To workaround the issue, explicitly setting xff attribute with blank value if it's blank in the state file
-			if v.String() == "" {
+			if v == "" {
 				nestedBlockBody.SetAttributeValue("xff", cty.StringVal(""))
 			}
 		case "response_object":
@@ -439,7 +589,7 @@ func rewriteVCLServiceResource(block *hclwrite.Block, s *tfstate.TFState, c *cli
 			}
 
 			// Get content from TFState
-			v, err := st.ServiceQuery(tfstate.ServiceQueryParams{
+			v, err := s.ServiceQuery(tfstate.ServiceQueryParams{
 				ServiceId:       c.ID,
 				NestedBlockName: nestedBlockType,
 				Name:            name,
@@ -451,12 +601,12 @@ func rewriteVCLServiceResource(block *hclwrite.Block, s *tfstate.TFState, c *cli
 
 			ext := "txt"
 			filename := fmt.Sprintf("%s.%s", naming.Normalize(name), ext)
-			if err = file.WriteContent(c.Directory, c.ResourceName, filename, v.Bytes()); err != nil {
+			if err = tx.WriteContent(c.ResourceName, filename, []byte(v)); err != nil {
 				return nil, err
 			}
 
 			// Replace content attribute of the nested block with file function expression
-			path := filepath.Join(".", "content", c.ResourceName, filename)
+			path := filePath(c, "content", c.ResourceName, filename)
 			tokens := buildFileFunction(path)
 			nestedBlockBody.SetAttributeRaw("content", tokens)
 		case "snippet":
@@ -467,7 +617,7 @@ func rewriteVCLServiceResource(block *hclwrite.Block, s *tfstate.TFState, c *cli
 			}
 
 			// Get content from TFState
-			v, err := st.ServiceQuery(tfstate.ServiceQueryParams{
+			v, err := s.ServiceQuery(tfstate.ServiceQueryParams{
 				ServiceId:       c.ID,
 				NestedBlockName: nestedBlockType,
 				Name:            name,
@@ -479,12 +629,12 @@ func rewriteVCLServiceResource(block *hclwrite.Block, s *tfstate.TFState, c *cli
 
 			// Save content to a file
 			filename := fmt.Sprintf("snippet_%s.vcl", naming.Normalize(name))
-			if err = file.WriteVCL(c.Directory, c.ResourceName, filename, v.Bytes()); err != nil {
+			if err = tx.WriteVCL(c.ResourceName, filename, []byte(v)); err != nil {
 				return nil, err
 			}
 
 			// Replace content attribute of the nested block with file function expression
-			path := filepath.Join(".", "vcl", c.ResourceName, filename)
+			path := filePath(c, "vcl", c.ResourceName, filename)
 			tokens := buildFileFunction(path)
 			nestedBlockBody.SetAttributeRaw("content", tokens)
 		case "vcl":
@@ -495,7 +645,7 @@ func rewriteVCLServiceResource(block *hclwrite.Block, s *tfstate.TFState, c *cli
 			}
 
 			// Get content from TFState
-			v, err := st.ServiceQuery(tfstate.ServiceQueryParams{
+			v, err := s.ServiceQuery(tfstate.ServiceQueryParams{
 				ServiceId:       c.ID,
 				NestedBlockName: nestedBlockType,
 				Name:            name,
@@ -507,38 +657,14 @@ func rewriteVCLServiceResource(block *hclwrite.Block, s *tfstate.TFState, c *cli
 
 			// Save content to a file
 			filename := fmt.Sprintf("%s.vcl", naming.Normalize(name))
-			if err = file.WriteVCL(c.Directory, c.ResourceName, filename, v.Bytes()); err != nil {
+			if err = tx.WriteVCL(c.ResourceName, filename, []byte(v)); err != nil {
 				return nil, err
 			}
 
 			// Replace content attribute of the nested block with file function expression
-			path := filepath.Join(".", "vcl", c.ResourceName, filename)
+			path := filePath(c, "vcl", c.ResourceName, filename)
 			tokens := buildFileFunction(path)
 			nestedBlockBody.SetAttributeRaw("content", tokens)
-		case "backend":
-			name, err := getStringAttributeValue(nestedBlock, "name")
-			if err != nil {
-				return nil, err
-			}
-
-			// Handling sensitive attrs
-			keys := []string{"ssl_client_cert", "ssl_client_key"}
-			for _, key := range keys {
-				v, err := st.ServiceQuery(tfstate.ServiceQueryParams{
-					ServiceId:       c.ID,
-					NestedBlockName: nestedBlockType,
-					Name:            name,
-					AttributeName:   key,
-				})
-				if err != nil {
-					return nil, err
-				}
-				if v.String() != "" {
-					varName := naming.Normalize(name) + "_" + key
-					nestedBlockBody.SetAttributeTraversal(key, buildVariableRef(varName))
-					sensitiveAttrs = append(sensitiveAttrs, SensitiveAttr{nestedBlockType, varName, v.String()})
-				}
-			}
 		default:
 			if strings.HasPrefix(nestedBlockType, "logging_") {
 				name, err := getStringAttributeValue(nestedBlock, "name")
@@ -546,7 +672,7 @@ func rewriteVCLServiceResource(block *hclwrite.Block, s *tfstate.TFState, c *cli
 					return nil, err
 				}
 
-				format, err := st.ServiceQuery(tfstate.ServiceQueryParams{
+				format, err := s.ServiceQuery(tfstate.ServiceQueryParams{
 					ServiceId:       c.ID,
 					NestedBlockName: nestedBlockType,
 					Name:            name,
@@ -557,117 +683,37 @@ func rewriteVCLServiceResource(block *hclwrite.Block, s *tfstate.TFState, c *cli
 				}
 
 				ext := "txt"
-				if json.Valid(format.Bytes()) {
+				if json.Valid([]byte(format)) {
 					ext = "json"
 				}
 				filename := fmt.Sprintf("%s.%s", naming.Normalize(name), ext)
-				if err = file.WriteLogFormat(c.Directory, c.ResourceName, filename, format.Bytes()); err != nil {
+				if err = tx.WriteLogFormat(c.ResourceName, filename, []byte(format)); err != nil {
 					return nil, err
 				}
 				// Replace content attribute of the nested block with file function expression
-				path := filepath.Join(".", "logformat", c.ResourceName, filename)
+				path := filePath(c, "logformat", c.ResourceName, filename)
 				tokens := buildFileFunction(path)
 				nestedBlockBody.SetAttributeRaw("format", tokens)
-
-				// Handling sensitive attrs
-				var keys []string
-				switch nestedBlockType {
-				case "logging_bigquery":
-					keys = []string{"email", "secret_key"}
-				case "logging_blobstorage":
-					keys = []string{"sas_token"}
-				case "logging_cloudfiles":
-					keys = []string{"access_key"}
-				case "logging_datadog":
-					keys = []string{"token"}
-				case "logging_digitalocean":
-					keys = []string{"access_key", "secret_key"}
-				case "logging_elasticsearch":
-					keys = []string{"password", "tls_client_key"}
-				case "logging_ftp":
-					keys = []string{"password"}
-				case "logging_gcs":
-					keys = []string{"secret_key"}
-				case "logging_googlepubsub":
-					keys = []string{"secret_key"}
-				case "logging_heroku":
-					keys = []string{"token"}
-				case "logging_honeycomb":
-					keys = []string{"token"}
-				case "logging_https":
-					keys = []string{"tls_client_key"}
-				case "logging_kafka":
-					keys = []string{"password", "tls_client_key"}
-				case "logging_kinesis":
-					keys = []string{"access_key", "secret_key"}
-				case "logging_loggly":
-					keys = []string{"token"}
-				case "logging_logshuttle":
-					keys = []string{"token"}
-				case "logging_newrelic":
-					keys = []string{"token"}
-				case "logging_openstack":
-					keys = []string{"access_key"}
-				case "logging_s3":
-					// Need S3 keys when "s3_iam_role" is empty
-					v, err := st.ServiceQuery(tfstate.ServiceQueryParams{
-						ServiceId:       c.ID,
-						NestedBlockName: nestedBlockType,
-						Name:            name,
-						AttributeName:   "s3_iam_role",
-					})
-					if err != nil {
-						return nil, err
-					}
-					if v.String() == "" {
-						keys = []string{"s3_access_key", "s3_secret_key"}
-					}
-				case "logging_scalyr":
-					keys = []string{"token"}
-				case "logging_sftp":
-					keys = []string{"password", "secret_key"}
-				case "logging_splunk":
-					keys = []string{"tls_client_key", "token"}
-				case "logging_syslog":
-					keys = []string{"tls_client_key"}
-				}
-				for _, key := range keys {
-					v, err := st.ServiceQuery(tfstate.ServiceQueryParams{
-						ServiceId:       c.ID,
-						NestedBlockName: nestedBlockType,
-						Name:            name,
-						AttributeName:   key,
-					})
-					if err != nil {
-						return nil, err
-					}
-
-					// the attribute names for under "logging_s3" are redundant. Removing the prefix "s3_" in the variable names
-					varName := naming.Normalize(name) + "_" + strings.TrimPrefix(key, "s3_")
-					nestedBlockBody.SetAttributeTraversal(key, buildVariableRef(varName))
-					sensitiveAttrs = append(sensitiveAttrs, SensitiveAttr{nestedBlockType, varName, v.String()})
-				}
 			}
 		}
+
+		attrs, err := rewriteSensitiveAttributes(nestedBlock, block.Labels()[0], schema, s, c)
+		if err != nil {
+			return nil, err
+		}
+		sensitiveAttrs = append(sensitiveAttrs, attrs...)
 	}
 
 	return sensitiveAttrs, nil
 }
 
-func rewriteComputeServiceResource(block *hclwrite.Block, serviceProp prop.TFBlock, props []prop.TFBlock, s *tfstate.TFState, c *cli.Config) ([]SensitiveAttr, error) {
+func rewriteComputeServiceResource(block *hclwrite.Block, serviceProp prop.TFBlock, props []prop.TFBlock, s *tfstate.TFState, schema *Schema, c *cli.Config, tx *file.Transaction) ([]SensitiveAttr, error) {
 	var sensitiveAttrs []SensitiveAttr
 
-	st, err := s.AddTemplate(tfstate.ServiceQueryTmplate)
-	if err != nil {
-		return nil, err
-	}
-
-	// Remove read-only attributes
+	// Remove the ID and the attributes not to be kept in the configuration.
+	// The computed attributes are removed by RemoveComputedAttributes.
 	body := block.Body()
 	body.RemoveAttribute("id")
-	body.RemoveAttribute("active_version")
-	body.RemoveAttribute("cloned_version")
-	body.RemoveAttribute("imported")
 	body.RemoveAttribute("force_refresh")
 
 	// If no service level comments are set, set blank
@@ -706,11 +752,8 @@ func rewriteComputeServiceResource(block *hclwrite.Block, serviceProp prop.TFBlo
 				if err != nil {
 					return nil, err
 				}
-			} else {
-				nestedBlockBody.RemoveAttribute("dictionary_id")
-				if c.ForceDestroy {
-					nestedBlockBody.SetAttributeValue("force_destroy", cty.BoolVal(true))
-				}
+			} else if c.ForceDestroy {
+				nestedBlockBody.SetAttributeValue("force_destroy", cty.BoolVal(true))
 			}
 		case "product_enablement":
 			nestedBlockBody.RemoveAttribute("name")
@@ -736,106 +779,13 @@ func rewriteComputeServiceResource(block *hclwrite.Block, serviceProp prop.TFBlo
 					break
 				}
 			}
-			nestedBlockBody.RemoveAttribute("link_id")
-		case "backend":
-			name, err := getStringAttributeValue(nestedBlock, "name")
-			if err != nil {
-				return nil, err
-			}
-
-			// Handling sensitive attrs
-			keys := []string{"ssl_client_cert", "ssl_client_key"}
-			for _, key := range keys {
-				v, err := st.ServiceQuery(tfstate.ServiceQueryParams{
-					ServiceId:       c.ID,
-					NestedBlockName: nestedBlockType,
-					Name:            name,
-					AttributeName:   key,
-				})
-				if err != nil {
-					return nil, err
-				}
-				if v.String() != "" {
-					varName := naming.Normalize(name) + "_" + key
-					nestedBlockBody.SetAttributeTraversal(key, buildVariableRef(varName))
-					sensitiveAttrs = append(sensitiveAttrs, SensitiveAttr{nestedBlockType, varName, v.String()})
-				}
-			}
-		default:
-			if strings.HasPrefix(nestedBlockType, "logging_") {
-				name, err := getStringAttributeValue(nestedBlock, "name")
-				if err != nil {
-					return nil, err
-				}
+		}
 
-				// Handling sensitive attrs
-				var keys []string
-				switch nestedBlockType {
-				case "logging_bigquery":
-					keys = []string{"email", "secret_key"}
-				case "logging_blobstorage":
-					keys = []string{"sas_token"}
-				case "logging_cloudfiles":
-					keys = []string{"access_key"}
-				case "logging_datadog":
-					keys = []string{"token"}
-				case "logging_digitalocean":
-					keys = []string{"access_key", "secret_key"}
-				case "logging_elasticsearch":
-					keys = []string{"password", "tls_client_key"}
-				case "logging_ftp":
-					keys = []string{"password"}
-				case "logging_gcs":
-					keys = []string{"secret_key"}
-				case "logging_googlepubsub":
-					keys = []string{"secret_key"}
-				case "logging_heroku":
-					keys = []string{"token"}
-				case "logging_honeycomb":
-					keys = []string{"token"}
-				case "logging_https":
-					keys = []string{"tls_client_key"}
-				case "logging_kafka":
-					keys = []string{"password", "tls_client_key"}
-				case "logging_kinesis":
-					keys = []string{"access_key", "secret_key"}
-				case "logging_loggly":
-					keys = []string{"token"}
-				case "logging_logshuttle":
-					keys = []string{"token"}
-				case "logging_newrelic":
-					keys = []string{"token"}
-				case "logging_openstack":
-					keys = []string{"access_key"}
-				case "logging_s3":
-					keys = []string{"s3_access_key", "s3_secret_key"}
-				case "logging_scalyr":
-					keys = []string{"token"}
-				case "logging_sftp":
-					keys = []string{"password", "secret_key"}
-				case "logging_splunk":
-					keys = []string{"tls_client_key", "token"}
-				case "logging_syslog":
-					keys = []string{"tls_client_key"}
-				}
-				for _, key := range keys {
-					v, err := st.ServiceQuery(tfstate.ServiceQueryParams{
-						ServiceId:       c.ID,
-						NestedBlockName: nestedBlockType,
-						Name:            name,
-						AttributeName:   key,
-					})
-					if err != nil {
-						return nil, err
-					}
-
-					// the attribute names for under "logging_s3" are redundant. Removing the prefix "s3_" in the variable names
-					varName := naming.Normalize(name) + "_" + strings.TrimPrefix(key, "s3_")
-					nestedBlockBody.SetAttributeTraversal(key, buildVariableRef(varName))
-					sensitiveAttrs = append(sensitiveAttrs, SensitiveAttr{nestedBlockType, varName, v.String()})
-				}
-			}
+		attrs, err := rewriteSensitiveAttributes(nestedBlock, block.Labels()[0], schema, s, c)
+		if err != nil {
+			return nil, err
 		}
+		sensitiveAttrs = append(sensitiveAttrs, attrs...)
 	}
 
 	return sensitiveAttrs, nil
@@ -906,7 +856,7 @@ func rewriteDictionaryResource(block *hclwrite.Block, serviceProp prop.TFBlock,
 	return nil
 }
 
-func rewriteDynamicSnippetResource(block *hclwrite.Block, serviceProp prop.TFBlock, s *tfstate.TFState, c *cli.Config) error {
+func rewriteDynamicSnippetResource(block *hclwrite.Block, serviceProp prop.TFBlock, s *tfstate.TFState, c *cli.Config, tx *file.Transaction) error {
 	if err := rewriteCommonAttributes(block, serviceProp, s); err != nil {
 		return err
 	}
@@ -926,11 +876,7 @@ func rewriteDynamicSnippetResource(block *hclwrite.Block, serviceProp prop.TFBlo
 		body.SetAttributeValue("content", cty.StringVal("### Fastly managed ngwaf_config_deliver"))
 	default:
 		// Get content from the state file
-		st, err := s.AddTemplate(tfstate.DsnippetQueryTmplate)
-		if err != nil {
-			return err
-		}
-		v, err := st.DSnippetQuery(tfstate.DSnippetQueryParams{
+		v, err := s.DSnippetQuery(tfstate.DSnippetQueryParams{
 			ResourceName: name,
 		})
 		if err != nil {
@@ -939,12 +885,12 @@ func rewriteDynamicSnippetResource(block *hclwrite.Block, serviceProp prop.TFBlo
 
 		// Save content to a file
 		filename := fmt.Sprintf("dsnippet_%s.vcl", naming.Normalize(name))
-		if err = file.WriteVCL(c.Directory, c.ResourceName, filename, v.Bytes()); err != nil {
+		if err = tx.WriteVCL(c.ResourceName, filename, []byte(v)); err != nil {
 			return err
 		}
 
 		// Replace content attribute with file function expression
-		path := filepath.Join(".", "vcl", c.ResourceName, filename)
+		path := filePath(c, "vcl", c.ResourceName, filename)
 		tokens := buildFileFunction(path)
 		body.SetAttributeRaw("content", tokens)
 	}
@@ -975,11 +921,7 @@ func rewriteCommonAttributes(block *hclwrite.Block, serviceProp prop.TFBlock, s
 	if err != nil {
 		return err
 	}
-	st, err := s.AddTemplate(tfstate.ResourceNameQueryTmplate)
-	if err != nil {
-		return nil
-	}
-	name, err := st.ResourceNameQuery(tfstate.ResourceNameQueryParams{
+	name, err := s.ResourceNameQuery(tfstate.ResourceNameQueryParams{
 		ResourceType:    serviceProp.GetType(),
 		NestedBlockName: attrName,
 		IDName:          idName,
@@ -993,7 +935,7 @@ func rewriteCommonAttributes(block *hclwrite.Block, serviceProp prop.TFBlock, s
 
 	// Add for_each to the resource block
 	body.AppendNewline()
-	tokens := buildForEach(serviceProp, attrName, name.String())
+	tokens := buildForEach(serviceProp, attrName, name)
 	body.SetAttributeRaw("for_each", tokens)
 
 	// Setting the resource ID (acl_id, dictionary_id, snippet_id)
@@ -1012,10 +954,6 @@ func rewriteCommonAttributes(block *hclwrite.Block, serviceProp prop.TFBlock, s
 
 func rewriteWAFResource(block *hclwrite.Block, serviceProp prop.TFBlock) error {
 	body := block.Body()
-	// remove read-only attributes
-	body.RemoveAttribute("active")
-	body.RemoveAttribute("cloned_version")
-	body.RemoveAttribute("number")
 	body.RemoveAttribute("id")
 
 	// set waf_id to represent the resource dependency
@@ -1132,6 +1070,16 @@ func getStringAttributeValue(block *hclwrite.Block, attrKey string) (string, err
 	return value, nil
 }
 
+// filePath returns the path to a file written next to the TF file, for the file function.
+// Relative paths are resolved from the working directory, so the path in a module starts from path.module.
+func filePath(c *cli.Config, elem ...string) string {
+	path := filepath.Join(append([]string{"."}, elem...)...)
+	if c.ModulePath != "" {
+		return "${path.module}/" + filepath.ToSlash(path)
+	}
+	return path
+}
+
 func buildFileFunction(path string) hclwrite.Tokens {
 	return hclwrite.Tokens{
 		{Type: hclsyntax.TokenIdent, Bytes: []byte("file")},
@@ -1232,6 +1180,66 @@ func buildResourceRef(prop prop.TFBlock, attr string) hcl.Traversal {
 	}
 }
 
+// BuildImportBlocks builds an import block for each resource, addressed in the module if the module name is not empty.
+// Resources rewritten with for_each are addressed with their instance key, the name of the ACL, dictionary or dynamic snippet.
+func BuildImportBlocks(moduleName string, props []prop.TFBlock) []byte {
+	f := hclwrite.NewEmptyFile()
+	rootBody := f.Body()
+
+	for i, p := range props {
+		if i != 0 {
+			rootBody.AppendNewline()
+		}
+
+		var to hcl.Traversal
+		if moduleName != "" {
+			to = hcl.Traversal{
+				hcl.TraverseRoot{Name: "module"},
+				hcl.TraverseAttr{Name: moduleName},
+				hcl.TraverseAttr{Name: p.GetType()},
+			}
+		} else {
+			to = hcl.Traversal{hcl.TraverseRoot{Name: p.GetType()}}
+		}
+		to = append(to, hcl.TraverseAttr{Name: p.GetNormalizedName()})
+		switch p.(type) {
+		case *prop.ACLResource, *prop.DictionaryResource, *prop.DynamicSnippetResource:
+			to = append(to, hcl.TraverseIndex{Key: cty.StringVal(p.GetName())})
+		}
+
+		importBody := rootBody.AppendNewBlock("import", nil).Body()
+		importBody.SetAttributeTraversal("to", to)
+		importBody.SetAttributeValue("id", cty.StringVal(p.GetIDforTFImport()))
+	}
+
+	return f.Bytes()
+}
+
+// BuildModuleBlock builds the module block that calls the module from the root module.
+// The inputs lifted from the service are passed with the values they replaced,
+// and the sensitive attributes through the variables of the same name.
+func BuildModuleBlock(moduleName string, attrs []SensitiveAttr, inputs []ModuleInput) []byte {
+	f := hclwrite.NewEmptyFile()
+	moduleBody := f.Body().AppendNewBlock("module", []string{moduleName}).Body()
+	moduleBody.SetAttributeValue("source", cty.StringVal("./"+filepath.ToSlash(file.ModuleDir(moduleName))))
+
+	if len(inputs) > 0 {
+		moduleBody.AppendNewline()
+	}
+	for _, input := range inputs {
+		moduleBody.SetAttributeValue(input.Key, input.Value)
+	}
+
+	if len(attrs) > 0 {
+		moduleBody.AppendNewline()
+	}
+	for _, attr := range attrs {
+		moduleBody.SetAttributeTraversal(attr.Key, buildVariableRef(attr.Key))
+	}
+
+	return f.Bytes()
+}
+
 func BuildTFVars(attrs []SensitiveAttr) []byte {
 	f := hclwrite.NewEmptyFile()
 	rootBody := f.Body()
@@ -0,0 +1,34 @@
+package tfconf
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadGenerated(t *testing.T) {
+	inputFile := filepath.Join("..", "..", "testdata", "generated_config.hcl")
+
+	inputBytes, err := os.ReadFile(inputFile)
+	if err != nil {
+		t.Fatalf("Failed to read input file %s: %v", inputFile, err)
+	}
+
+	conf, err := LoadGenerated(string(inputBytes))
+	if err != nil {
+		t.Fatalf("LoadGenerated failed: %v", err)
+	}
+
+	output := string(conf.Bytes())
+	for _, unexpected := range []string{"__generated__", "null", "activate", "ssl_client_key"} {
+		if strings.Contains(output, unexpected) {
+			t.Errorf("LoadGenerated output contains %q:\n%v", unexpected, output)
+		}
+	}
+	for _, expected := range []string{`"test.terraformify.me"`, `"httpbin.org"`, "#FASTLY recv"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("LoadGenerated output does not contain %q:\n%v", expected, output)
+		}
+	}
+}
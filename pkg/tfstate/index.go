@@ -0,0 +1,125 @@
+package tfstate
+
+// index maps the resources and their nested blocks for the lookups, so that each lookup takes constant time
+// however many nested blocks the service has. It is built on the first lookup and dropped by every edit
+// made by the package, so that the next lookup builds it again.
+type index struct {
+	byType map[string][]*Resource
+	// byAddress holds the first resource of each module, type and name
+	byAddress map[resourceKey]*Resource
+	// byID maps the "id" attribute of each instance to the resources that have the instance
+	byID map[string][]*Resource
+	// named maps the "name" of each nested block to the blocks in the instance with the id
+	named map[namedBlockKey][]nestedBlock
+	// byBlockAttr holds the nested blocks by the value of an attribute, such as acl_id. It is filled in by blocksWith
+	// for each combination of the resource type, the block type and the attribute that is looked up.
+	byBlockAttr map[blockAttrKey]map[string][]nestedBlock
+}
+
+type resourceKey struct {
+	module       string
+	resourceType string
+	name         string
+}
+
+type namedBlockKey struct {
+	id        string
+	blockType string
+	name      string
+}
+
+type blockAttrKey struct {
+	resourceType string
+	blockType    string
+	attribute    string
+}
+
+// nestedBlock is a nested block and its position among the blocks of the same type, as ordered in the state
+type nestedBlock struct {
+	attributes map[string]interface{}
+	position   int
+}
+
+// lookup returns the index of the state, building it if it is not built yet
+func (s *TFState) lookup() *index {
+	if s.idx != nil {
+		return s.idx
+	}
+
+	idx := &index{
+		byType:      map[string][]*Resource{},
+		byAddress:   map[resourceKey]*Resource{},
+		byID:        map[string][]*Resource{},
+		named:       map[namedBlockKey][]nestedBlock{},
+		byBlockAttr: map[blockAttrKey]map[string][]nestedBlock{},
+	}
+	for _, r := range s.Resources {
+		idx.byType[r.Type] = append(idx.byType[r.Type], r)
+		if key := (resourceKey{module: r.Module, resourceType: r.Type, name: r.Name}); idx.byAddress[key] == nil {
+			idx.byAddress[key] = r
+		}
+
+		seen := map[string]bool{}
+		for _, i := range r.Instances {
+			id := i.StringAttribute("id")
+			if !seen[id] {
+				seen[id] = true
+				idx.byID[id] = append(idx.byID[id], r)
+			}
+
+			for blockType, v := range i.Attributes {
+				idx.addNamedBlocks(id, blockType, v)
+			}
+		}
+	}
+
+	s.idx = idx
+	return idx
+}
+
+// invalidate drops the index after an edit to the resources, their instances or their attributes
+func (s *TFState) invalidate() {
+	s.idx = nil
+}
+
+// addNamedBlocks adds the nested blocks with a name in the value of the attribute, if it is a list of nested blocks.
+// The position of each block is counted as NestedBlocks does.
+func (idx *index) addNamedBlocks(id, blockType string, v interface{}) {
+	list, _ := v.([]interface{})
+	n := 0
+	for _, e := range list {
+		b, ok := e.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if name, ok := b["name"]; ok {
+			key := namedBlockKey{id: id, blockType: blockType, name: stringValue(name)}
+			idx.named[key] = append(idx.named[key], nestedBlock{attributes: b, position: n})
+		}
+		n++
+	}
+}
+
+// namedBlocks returns the nested blocks of the type with the name in the instances with the id
+func (idx *index) namedBlocks(id, blockType, name string) []nestedBlock {
+	return idx.named[namedBlockKey{id: id, blockType: blockType, name: name}]
+}
+
+// blocksWith returns the nested blocks of the type in the resources of the type whose attribute has the value
+func (idx *index) blocksWith(resourceType, blockType, attribute, value string) []nestedBlock {
+	key := blockAttrKey{resourceType: resourceType, blockType: blockType, attribute: attribute}
+	blocks, ok := idx.byBlockAttr[key]
+	if !ok {
+		blocks = map[string][]nestedBlock{}
+		for _, r := range idx.byType[resourceType] {
+			for _, i := range r.Instances {
+				for n, b := range i.NestedBlocks(blockType) {
+					v := stringValue(b[attribute])
+					blocks[v] = append(blocks[v], nestedBlock{attributes: b, position: n})
+				}
+			}
+		}
+		idx.byBlockAttr[key] = blocks
+	}
+	return blocks[value]
+}
@@ -1,20 +1,14 @@
 package tfstate
 
 import (
-	"bytes"
 	"fmt"
 )
 
-// query templates for gojq
-const setActivateTemplate = `(.resources[] | select(.instances[].attributes.id == "{{.ServiceId}}") | .instances[].attributes.activate) |= true`
-const setActivateWAFTemplate = `(.resources[] | select(.instances[].attributes.id == "{{.WafId}}") | .instances[].attributes.activate) |= true`
-const setIndexKeyTmplate = `(.resources[] | select(.type == "{{.ResourceType}}") | select(.instances[].attributes.service_id == "{{.ServiceId}}") | select(.name == "{{.ResourceName}}") | .instances[]) += {index_key: "{{.Name}}"}`
-const setSensitiveAttributeTemplate = `(.resources[] | select(.instances[].attributes.id == "{{.ServiceId}}") | .instances[].sensitive_attributes) += [[{type: "get_attr", value: "{{.BlockType}}"}]]`
-const setManageAttributeTemplate = `(.resources[] | select(.type == "{{.ResourceType}}") | select(.instances[].attributes.service_id == "{{.ServiceId}}") | .instances[].attributes.{{.AttributeName}}) |= true`
-const setServiceForceDestroyTemplate = `(.resources[] | select(.instances[].attributes.id == "{{.ServiceId}}") | .instances[].attributes.force_destroy) |= true`
-const setACLForceDestroyTemplate = `(.resources[] | select(.instances[].attributes.id == "{{.ServiceId}}") | .instances[].attributes | .acl[].force_destroy) |= true`
-const setDictionaryForceDestroyTemplate = `(.resources[] | select(.instances[].attributes.id == "{{.ServiceId}}") | .instances[].attributes | .dictionary[].force_destroy) |= true`
-const setPackageFilenameTemplate = `(.resources[] | select(.instances[].attributes.id == "{{.ServiceId}}") | .instances[].attributes.package[]) += {filename: "{{.PackageFilename}}"}`
+type SetModuleParams struct {
+	Module       string
+	ResourceType string
+	ResourceName string
+}
 
 type SetActivateWAFTemplateParams struct {
 	WafId string
@@ -41,181 +35,166 @@ type SetPackageFilenameParams struct {
 	PackageFilename string
 }
 
-type setSensitiveAttributeParams struct {
-	ServiceId string
-	BlockType string
-}
-
-type setManageAttributeParams struct {
-	ServiceId     string
-	ResourceType  string
-	AttributeName string
-}
-
-func (s *TFState) SetActivateWAFAttribute(param SetActivateWAFTemplateParams) (*TFState, error) {
-	var q bytes.Buffer
+// The edits are made on a copy of the state, so that the state they are called on is left as it is.
+// The copy is looked up while it is edited, so its index is dropped once the edit is done.
+// Copying the state takes time in proportion to its size, so the edits made for each of the resources of a service,
+// such as SetIndexKey and SetModule, take all of them in one call.
 
-	st, err := s.AddTemplate(setActivateWAFTemplate)
+// editByID runs f on each instance of the resources with an instance whose "id" attribute is the id
+func (s *TFState) editByID(id string, f func(i *Instance)) (*TFState, error) {
+	ns, err := s.Clone()
 	if err != nil {
 		return nil, err
 	}
-
-	err = st.Execute(&q, param)
-	if err != nil {
-		return nil, fmt.Errorf("tfstate: invalid params: %w", err)
+	for _, r := range ns.ResourcesWithID(id) {
+		for _, i := range r.Instances {
+			f(i)
+		}
 	}
+	ns.invalidate()
+	return ns, nil
+}
 
-	return st.TFState.Query(q.String())
+func (s *TFState) SetActivateWAFAttribute(param SetActivateWAFTemplateParams) (*TFState, error) {
+	return s.editByID(param.WafId, func(i *Instance) {
+		i.SetAttribute("activate", true)
+	})
 }
 
 func (s *TFState) SetActivateAttribute(param SetActivateTemplateParams) (*TFState, error) {
-	var q bytes.Buffer
-
-	st, err := s.AddTemplate(setActivateTemplate)
-	if err != nil {
-		return nil, err
-	}
-
-	err = st.Execute(&q, param)
-	if err != nil {
-		return nil, fmt.Errorf("tfstate: invalid params: %w", err)
-	}
-
-	return st.TFState.Query(q.String())
+	return s.editByID(param.ServiceId, func(i *Instance) {
+		i.SetAttribute("activate", true)
+	})
 }
 
-func (s *TFState) SetIndexKey(param SetIndexKeyParams) (*TFState, error) {
-	var q bytes.Buffer
-
-	st, err := s.AddTemplate(setIndexKeyTmplate)
+// SetIndexKey sets the key of the instance of each resource created with for_each over the nested blocks of the service,
+// such as fastly_service_acl_entries, so that it matches the key in the configuration
+func (s *TFState) SetIndexKey(params ...SetIndexKeyParams) (*TFState, error) {
+	ns, err := s.Clone()
 	if err != nil {
 		return nil, err
 	}
-
-	err = st.Execute(&q, param)
-	if err != nil {
-		return nil, fmt.Errorf("tfstate: invalid params: %w", err)
+	for _, param := range params {
+		r := ns.Resource("", param.ResourceType, param.ResourceName)
+		if r == nil || !r.hasInstanceWith("service_id", param.ServiceId) {
+			continue
+		}
+		for _, i := range r.Instances {
+			i.IndexKey = param.Name
+		}
 	}
-
-	return st.TFState.Query(q.String())
+	ns.invalidate()
+	return ns, nil
 }
 
 func (s *TFState) SetPackageFilename(param SetPackageFilenameParams) (*TFState, error) {
-	var q bytes.Buffer
+	return s.editByID(param.ServiceId, func(i *Instance) {
+		for _, p := range i.NestedBlocks("package") {
+			p["filename"] = param.PackageFilename
+		}
+	})
+}
 
-	st, err := s.AddTemplate(setPackageFilenameTemplate)
+// SetModule moves each resource of the type and the name from the root module into the module, such as "module.cdn".
+// The dependencies of its instances are moved along with it, as a resource in a module can only depend on the resources in the module.
+func (s *TFState) SetModule(params ...SetModuleParams) (*TFState, error) {
+	ns, err := s.Clone()
 	if err != nil {
 		return nil, err
 	}
-
-	err = st.Execute(&q, param)
-	if err != nil {
-		return nil, fmt.Errorf("tfstate: invalid params: %w", err)
+	for _, param := range params {
+		// The resources by type are left as they are by the moves, unlike those by address
+		for _, r := range ns.FindResources(param.ResourceType) {
+			if r.Module != "" || r.Name != param.ResourceName {
+				continue
+			}
+			r.Module = param.Module
+			for _, i := range r.Instances {
+				if err := i.moveDependencies(param.Module); err != nil {
+					return nil, fmt.Errorf("tfstate: failed to move %s: %w", r.Address(), err)
+				}
+			}
+		}
 	}
-
-	return st.TFState.Query(q.String())
+	ns.invalidate()
+	return ns, nil
 }
 
-func (s *TFState) SetSensitiveAttributes(serviceId string, blockTypes map[string]struct{}) (*TFState, error) {
-	for blockType := range blockTypes {
-		var q bytes.Buffer
-
-		st, err := s.AddTemplate(setSensitiveAttributeTemplate)
-		if err != nil {
-			return nil, err
+// SetSensitiveAttributes adds the paths to sensitive_attributes of the service, skipping those already listed
+func (s *TFState) SetSensitiveAttributes(serviceId string, paths []Path) (*TFState, error) {
+	return s.editByID(serviceId, func(i *Instance) {
+		seen := map[string]bool{}
+		for _, p := range i.SensitiveAttributes {
+			seen[p.String()] = true
 		}
-
-		err = st.Execute(&q, setSensitiveAttributeParams{
-			ServiceId: serviceId,
-			BlockType: blockType,
-		})
-
-		if err != nil {
-			return nil, fmt.Errorf("tfstate: invalid params: %w", err)
+		for _, p := range paths {
+			if key := p.String(); !seen[key] {
+				seen[key] = true
+				i.SensitiveAttributes = append(i.SensitiveAttributes, p)
+			}
 		}
-
-		s, err = st.TFState.Query(q.String())
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	return s, nil
+	})
 }
 
 func (s *TFState) SetManageAttributes(serviceId string) (*TFState, error) {
-	params := []setManageAttributeParams{
-		{serviceId, "fastly_service_dynamic_snippet_content", "manage_snippets"},
-		{serviceId, "fastly_service_dictionary_items", "manage_items"},
-		{serviceId, "fastly_service_acl_entries", "manage_entries"},
+	params := []struct {
+		resourceType  string
+		attributeName string
+	}{
+		{"fastly_service_dynamic_snippet_content", "manage_snippets"},
+		{"fastly_service_dictionary_items", "manage_items"},
+		{"fastly_service_acl_entries", "manage_entries"},
 	}
 
+	ns, err := s.Clone()
+	if err != nil {
+		return nil, err
+	}
 	for _, param := range params {
-		var q bytes.Buffer
-
-		st, err := s.AddTemplate(setManageAttributeTemplate)
-		if err != nil {
-			return nil, err
+		for _, r := range ns.resourcesWith(param.resourceType, "service_id", serviceId) {
+			for _, i := range r.Instances {
+				i.SetAttribute(param.attributeName, true)
+			}
 		}
+	}
+	ns.invalidate()
+	return ns, nil
+}
 
-		err = st.Execute(&q, param)
-		if err != nil {
-			return nil, fmt.Errorf("tfstate: invalid params: %w", err)
+// SetForceDestroy sets force_destroy of the service and its dictionaries, and its ACLs for a VCL service
+func (s *TFState) SetForceDestroy(param SetForceDestroyParams) (*TFState, error) {
+	return s.editByID(param.ServiceId, func(i *Instance) {
+		i.SetAttribute("force_destroy", true)
+		for _, d := range i.NestedBlocks("dictionary") {
+			d["force_destroy"] = true
 		}
-
-		s, err = st.TFState.Query(q.String())
-		if err != nil {
-			return nil, err
+		if param.ResourceType == "fastly_service_vcl" {
+			for _, a := range i.NestedBlocks("acl") {
+				a["force_destroy"] = true
+			}
 		}
-	}
-
-	return s, nil
+	})
 }
 
-func (s *TFState) SetForceDestroy(param SetForceDestroyParams) (*TFState, error) {
-	var q bytes.Buffer
-	st, err := s.AddTemplate(setServiceForceDestroyTemplate)
-	if err != nil {
-		return nil, err
-	}
-	err = st.Execute(&q, param)
-	if err != nil {
-		return nil, fmt.Errorf("tfstate: invalid params: %w", err)
-	}
-	s, err = st.TFState.Query(q.String())
-	if err != nil {
-		return nil, err
+// RemoveResources removes the resources at the addresses, such as "module.cdn.fastly_service_vcl.service",
+// along with all their instances, as "terraform state rm" does
+func (s *TFState) RemoveResources(addresses []string) (*TFState, error) {
+	remove := map[string]bool{}
+	for _, addr := range addresses {
+		remove[addr] = true
 	}
 
-	q.Reset()
-	st, err = s.AddTemplate(setDictionaryForceDestroyTemplate)
+	ns, err := s.Clone()
 	if err != nil {
 		return nil, err
 	}
-	err = st.Execute(&q, param)
-	if err != nil {
-		return nil, fmt.Errorf("tfstate: invalid params: %w", err)
-	}
-	s, err = st.TFState.Query(q.String())
-	if err != nil {
-		return nil, err
-	}
-
-	if param.ResourceType == "fastly_service_vcl" {
-		q.Reset()
-		st, err = s.AddTemplate(setACLForceDestroyTemplate)
-		if err != nil {
-			return nil, err
-		}
-		err = st.Execute(&q, param)
-		if err != nil {
-			return nil, fmt.Errorf("tfstate: invalid params: %w", err)
-		}
-		s, err = st.TFState.Query(q.String())
-		if err != nil {
-			return nil, err
+	resources := []*Resource{}
+	for _, r := range ns.Resources {
+		if !remove[r.Address()] {
+			resources = append(resources, r)
 		}
 	}
-
-	return s, nil
+	ns.Resources = resources
+	ns.invalidate()
+	return ns, nil
 }
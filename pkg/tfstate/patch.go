@@ -0,0 +1,92 @@
+package tfstate
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Patch is an operation of a JSON Patch (RFC 6902) describing a change to the state
+type Patch struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// Diff returns the operations that turn the state before into the state after.
+// The operations apply in order, so the elements removed from the end of an array are listed from the last one.
+func Diff(before, after *TFState) []Patch {
+	var a, b interface{}
+	// Both are states read or built by this package, so they are always converted
+	_ = convert(before, &a)
+	_ = convert(after, &b)
+	return diffValue("", a, b, nil)
+}
+
+func diffValue(path string, a, b interface{}, patches []Patch) []Patch {
+	switch a := a.(type) {
+	case map[string]interface{}:
+		if b, ok := b.(map[string]interface{}); ok {
+			return diffObject(path, a, b, patches)
+		}
+	case []interface{}:
+		if b, ok := b.([]interface{}); ok {
+			return diffArray(path, a, b, patches)
+		}
+	}
+	if reflect.DeepEqual(a, b) {
+		return patches
+	}
+	return append(patches, Patch{Op: "replace", Path: path, Value: b})
+}
+
+func diffObject(path string, a, b map[string]interface{}, patches []Patch) []Patch {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		p := path + "/" + escapePointer(k)
+		av, inA := a[k]
+		bv, inB := b[k]
+		switch {
+		case !inA:
+			patches = append(patches, Patch{Op: "add", Path: p, Value: bv})
+		case !inB:
+			patches = append(patches, Patch{Op: "remove", Path: p})
+		default:
+			patches = diffValue(p, av, bv, patches)
+		}
+	}
+	return patches
+}
+
+func diffArray(path string, a, b []interface{}, patches []Patch) []Patch {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	for i := 0; i < n; i++ {
+		patches = diffValue(path+"/"+strconv.Itoa(i), a[i], b[i], patches)
+	}
+	for i := n; i < len(b); i++ {
+		patches = append(patches, Patch{Op: "add", Path: path + "/" + strconv.Itoa(i), Value: b[i]})
+	}
+	for i := len(a) - 1; i >= n; i-- {
+		patches = append(patches, Patch{Op: "remove", Path: path + "/" + strconv.Itoa(i)})
+	}
+	return patches
+}
+
+// escapePointer escapes the key for a JSON Pointer (RFC 6901)
+func escapePointer(key string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
@@ -0,0 +1,33 @@
+package tfstate_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hrmsk66/terraformify/pkg/tfstate"
+)
+
+func TestDiff(t *testing.T) {
+	before, err := tfstate.Parse([]byte(`{"serial":1,"resources":[{"type":"fastly_service_vcl","instances":[{"attributes":{"activate":false,"a/b":1},"sensitive_attributes":[]}]},{"x":1},{"y":2}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := tfstate.Parse([]byte(`{"serial":1,"resources":[{"type":"fastly_service_vcl","instances":[{"attributes":{"activate":true,"a/b":1,"force_destroy":true},"index_key":"k","sensitive_attributes":[[{"type":"get_attr","value":"backend"}]]}]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []tfstate.Patch{
+		{Op: "replace", Path: "/resources/0/instances/0/attributes/activate", Value: true},
+		{Op: "add", Path: "/resources/0/instances/0/attributes/force_destroy", Value: true},
+		{Op: "add", Path: "/resources/0/instances/0/index_key", Value: "k"},
+		{Op: "add", Path: "/resources/0/instances/0/sensitive_attributes/0", Value: []interface{}{
+			map[string]interface{}{"type": "get_attr", "value": "backend"},
+		}},
+		{Op: "remove", Path: "/resources/2"},
+		{Op: "remove", Path: "/resources/1"},
+	}
+	if got := tfstate.Diff(before, after); !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff = %#v, want %#v", got, want)
+	}
+}
@@ -1,16 +1,9 @@
 package tfstate
 
 import (
-	"bytes"
 	"fmt"
 )
 
-// query templates for gojq
-const ServiceQueryTmplate = `.resources[] | select(.instances[].attributes.id == "{{.ServiceId}}") | .instances[].attributes.{{.NestedBlockName}}[] | select(.name == "{{.Name}}") | .{{.AttributeName}}`
-const DsnippetQueryTmplate = `.resources[] | select(.type == "fastly_service_dynamic_snippet_content") | select(.name == "{{.ResourceName}}") | .instances[].attributes.content`
-const ResourceNameQueryTmplate = `.resources[] | select(.type == "{{.ResourceType}}") | .instances[].attributes.{{.NestedBlockName}}[] | select(.{{.IDName}} == "{{.ID}}") | .name`
-const RateLimiterContentQueryTemplate = `.resources[] | select(.instances[].attributes.id == "{{.ServiceId}}") | .instances[].attributes.rate_limiter[] | select(.name == "{{.Name}}") | .response[] | .content`
-
 type ServiceQueryParams struct {
 	ServiceId       string
 	NestedBlockName string
@@ -18,6 +11,12 @@ type ServiceQueryParams struct {
 	AttributeName   string
 }
 
+type NestedBlockIndexQueryParams struct {
+	ServiceId       string
+	NestedBlockName string
+	Name            string
+}
+
 type DSnippetQueryParams struct {
 	ResourceName string
 }
@@ -29,43 +28,143 @@ type ResourceNameQueryParams struct {
 	ID              string
 }
 
+type ResourceIDQueryParams struct {
+	// Module is the module path of the resource, such as "module.cdn". Empty means the root module.
+	Module       string
+	ResourceType string
+	ResourceName string
+}
+
+type ResourceCountQueryParams struct {
+	// Module is the module path of the resource, such as "module.cdn". Empty means the root module.
+	Module       string
+	ResourceType string
+	ResourceName string
+}
+
+type WAFIDQueryParams struct {
+	ServiceId string
+}
+
 type RateLimiterContentQueryParams struct {
 	ServiceId string
 	Name      string
 }
 
-func (s *TFStateWithTemplate) ServiceQuery(params ServiceQueryParams) (*TFState, error) {
-	var q bytes.Buffer
-	if err := s.Execute(&q, params); err != nil {
-		return nil, fmt.Errorf("tfstate: invalid params: %w", err)
+// ServiceQuery returns the attribute of the named nested block of the service, such as the content of a snippet
+func (s *TFState) ServiceQuery(params ServiceQueryParams) (string, error) {
+	var results []interface{}
+	for _, b := range s.lookup().namedBlocks(params.ServiceId, params.NestedBlockName, params.Name) {
+		results = append(results, b.attributes[params.AttributeName])
+	}
+
+	what := fmt.Sprintf("%s of %s %q in service %s", params.AttributeName, params.NestedBlockName, params.Name, params.ServiceId)
+	return single(results, what)
+}
+
+// NestedBlockIndexQuery returns the index of the named nested block of the service, as the blocks are ordered in the state
+func (s *TFState) NestedBlockIndexQuery(params NestedBlockIndexQueryParams) (int, error) {
+	var results []int
+	for _, b := range s.lookup().namedBlocks(params.ServiceId, params.NestedBlockName, params.Name) {
+		results = append(results, b.position)
+	}
+
+	what := fmt.Sprintf("%s %q in service %s", params.NestedBlockName, params.Name, params.ServiceId)
+	if len(results) == 0 {
+		return 0, fmt.Errorf("tfstate: %s is not found in the state", what)
+	}
+	if len(results) > 1 {
+		return 0, fmt.Errorf("tfstate: found multiple %s in the state", what)
+	}
+	return results[0], nil
+}
+
+// DSnippetQuery returns the content of the fastly_service_dynamic_snippet_content resource
+func (s *TFState) DSnippetQuery(params DSnippetQueryParams) (string, error) {
+	var results []interface{}
+	for _, r := range s.FindResources("fastly_service_dynamic_snippet_content") {
+		if r.Name != params.ResourceName {
+			continue
+		}
+		for _, i := range r.Instances {
+			results = append(results, i.Attributes["content"])
+		}
+	}
+
+	what := fmt.Sprintf("content of fastly_service_dynamic_snippet_content.%s", params.ResourceName)
+	return single(results, what)
+}
+
+// ResourceNameQuery returns the name of the nested block whose ID attribute is the ID, such as the name of a dictionary
+func (s *TFState) ResourceNameQuery(params ResourceNameQueryParams) (string, error) {
+	var results []interface{}
+	for _, b := range s.lookup().blocksWith(params.ResourceType, params.NestedBlockName, params.IDName, params.ID) {
+		results = append(results, b.attributes["name"])
 	}
 
-	return s.TFState.Query(q.String())
+	what := fmt.Sprintf("name of %s with %s %q in %s", params.NestedBlockName, params.IDName, params.ID, params.ResourceType)
+	return single(results, what)
 }
 
-func (s *TFStateWithTemplate) DSnippetQuery(params DSnippetQueryParams) (*TFState, error) {
-	var q bytes.Buffer
-	if err := s.Execute(&q, params); err != nil {
-		return nil, fmt.Errorf("tfstate: invalid params: %w", err)
+// ResourceIDQuery returns the ID of the resource
+func (s *TFState) ResourceIDQuery(params ResourceIDQueryParams) (string, error) {
+	var results []interface{}
+	if r := s.Resource(params.Module, params.ResourceType, params.ResourceName); r != nil {
+		for _, i := range r.Instances {
+			results = append(results, i.Attributes["id"])
+		}
 	}
 
-	return s.TFState.Query(q.String())
+	what := fmt.Sprintf("id of %s", (&Resource{Module: params.Module, Type: params.ResourceType, Name: params.ResourceName}).Address())
+	return single(results, what)
+}
+
+// ResourceCountQuery returns the number of resources of the type and the name in the module
+func (s *TFState) ResourceCountQuery(params ResourceCountQueryParams) int {
+	n := 0
+	for _, r := range s.FindResources(params.ResourceType) {
+		if r.Module == params.Module && r.Name == params.ResourceName {
+			n++
+		}
+	}
+	return n
 }
 
-func (s *TFStateWithTemplate) ResourceNameQuery(params ResourceNameQueryParams) (*TFState, error) {
-	var q bytes.Buffer
-	if err := s.Execute(&q, params); err != nil {
-		return nil, fmt.Errorf("tfstate: invalid params: %w", err)
+// WAFIDQuery returns the ID of the WAF attached to the service
+func (s *TFState) WAFIDQuery(params WAFIDQueryParams) (string, error) {
+	var results []interface{}
+	for _, r := range s.ResourcesWithID(params.ServiceId) {
+		for _, i := range r.Instances {
+			for _, b := range i.NestedBlocks("waf") {
+				results = append(results, b["waf_id"])
+			}
+		}
 	}
 
-	return s.TFState.Query(q.String())
+	what := fmt.Sprintf("waf_id of service %s", params.ServiceId)
+	return single(results, what)
 }
 
-func (s *TFStateWithTemplate) RateLimiterContentQuery(params RateLimiterContentQueryParams) (*TFState, error) {
-	var q bytes.Buffer
-	if err := s.Execute(&q, params); err != nil {
-		return nil, fmt.Errorf("tfstate: invalid params: %w", err)
+// RateLimiterContentQuery returns the content of the response of the named rate limiter
+func (s *TFState) RateLimiterContentQuery(params RateLimiterContentQueryParams) (string, error) {
+	var results []interface{}
+	for _, b := range s.lookup().namedBlocks(params.ServiceId, "rate_limiter", params.Name) {
+		for _, resp := range objects(b.attributes["response"]) {
+			results = append(results, resp["content"])
+		}
 	}
 
-	return s.TFState.Query(q.String())
+	what := fmt.Sprintf("content of the response of rate_limiter %q in service %s", params.Name, params.ServiceId)
+	return single(results, what)
+}
+
+// single returns the only result of a lookup as a string
+func single(results []interface{}, what string) (string, error) {
+	if len(results) == 0 {
+		return "", fmt.Errorf("tfstate: %s is not found in the state", what)
+	}
+	if len(results) > 1 {
+		return "", fmt.Errorf("tfstate: found multiple values for %s in the state", what)
+	}
+	return stringValue(results[0]), nil
 }
@@ -0,0 +1,181 @@
+package tfstate_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/hrmsk66/terraformify/pkg/tfstate"
+)
+
+// largeState builds a state of a service with n of each nested block, and fastly_service_acl_entries for each ACL block.
+// The first ACL has tens of thousands of entries.
+func largeState(tb testing.TB, n int) *tfstate.TFState {
+	tb.Helper()
+	var backends, snippets, acls []interface{}
+	resources := []interface{}{}
+	for i := 0; i < n; i++ {
+		backends = append(backends, map[string]interface{}{"name": fmt.Sprintf("backend_%d", i), "address": "example.com", "ssl_client_key": "secret"})
+		snippets = append(snippets, map[string]interface{}{"name": fmt.Sprintf("snippet_%d", i), "content": "set req.http.X = \"1\";"})
+		aclID := fmt.Sprintf("acl%019d", i)
+		acls = append(acls, map[string]interface{}{"name": fmt.Sprintf("acl_%d", i), "acl_id": aclID, "force_destroy": false})
+
+		size := 10
+		if i == 0 {
+			size = 20000
+		}
+		var entries []interface{}
+		for j := 0; j < size; j++ {
+			entries = append(entries, map[string]interface{}{"ip": fmt.Sprintf("10.%d.%d.0", j/256, j%256), "subnet": "24", "negated": false})
+		}
+		resources = append(resources, map[string]interface{}{
+			"mode":     "managed",
+			"type":     "fastly_service_acl_entries",
+			"name":     fmt.Sprintf("acl_%d", i),
+			"provider": `provider["registry.terraform.io/fastly/fastly"]`,
+			"instances": []interface{}{map[string]interface{}{
+				"schema_version": 0,
+				"attributes":     map[string]interface{}{"id": serviceID + "/" + aclID, "service_id": serviceID, "acl_id": aclID, "entry": entries},
+			}},
+		})
+	}
+	resources = append([]interface{}{map[string]interface{}{
+		"mode":     "managed",
+		"type":     "fastly_service_vcl",
+		"name":     "service",
+		"provider": `provider["registry.terraform.io/fastly/fastly"]`,
+		"instances": []interface{}{map[string]interface{}{
+			"schema_version": 0,
+			"attributes":     map[string]interface{}{"id": serviceID, "backend": backends, "snippet": snippets, "acl": acls},
+		}},
+	}}, resources...)
+
+	b, err := json.Marshal(map[string]interface{}{
+		"version":           4,
+		"terraform_version": "1.9.5",
+		"serial":            1,
+		"lineage":           "3f1c2b7a-8d2e-4b6f-9a51-0c7e4d2f9b13",
+		"outputs":           map[string]interface{}{},
+		"resources":         resources,
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	s, err := tfstate.Parse(b)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return s
+}
+
+// rewriteLookups runs the lookups that rewriting the configuration of the service makes for each nested block
+func rewriteLookups(tb testing.TB, s *tfstate.TFState, n int) {
+	for i := 0; i < n; i++ {
+		backend := fmt.Sprintf("backend_%d", i)
+		if _, err := s.ServiceQuery(tfstate.ServiceQueryParams{ServiceId: serviceID, NestedBlockName: "backend", Name: backend, AttributeName: "ssl_client_key"}); err != nil {
+			tb.Fatal(err)
+		}
+		index, err := s.NestedBlockIndexQuery(tfstate.NestedBlockIndexQueryParams{ServiceId: serviceID, NestedBlockName: "backend", Name: backend})
+		if err != nil || index != i {
+			tb.Fatalf("NestedBlockIndexQuery = %d, %v, want %d", index, err, i)
+		}
+		if _, err := s.ServiceQuery(tfstate.ServiceQueryParams{ServiceId: serviceID, NestedBlockName: "snippet", Name: fmt.Sprintf("snippet_%d", i), AttributeName: "content"}); err != nil {
+			tb.Fatal(err)
+		}
+
+		acl := fmt.Sprintf("acl_%d", i)
+		aclID, err := s.ServiceQuery(tfstate.ServiceQueryParams{ServiceId: serviceID, NestedBlockName: "acl", Name: acl, AttributeName: "acl_id"})
+		if err != nil {
+			tb.Fatal(err)
+		}
+		if _, err := s.ResourceIDQuery(tfstate.ResourceIDQueryParams{ResourceType: "fastly_service_acl_entries", ResourceName: acl}); err != nil {
+			tb.Fatal(err)
+		}
+		name, err := s.ResourceNameQuery(tfstate.ResourceNameQueryParams{ResourceType: "fastly_service_vcl", NestedBlockName: "acl", IDName: "acl_id", ID: aclID})
+		if err != nil || name != acl {
+			tb.Fatalf("ResourceNameQuery = %q, %v, want %q", name, err, acl)
+		}
+	}
+}
+
+// rewriteEdits makes the edits that an import makes to the state of the service after it is rewritten,
+// and returns the edited state
+func rewriteEdits(tb testing.TB, s *tfstate.TFState, n int) *tfstate.TFState {
+	s, err := s.SetActivateAttribute(tfstate.SetActivateTemplateParams{ServiceId: serviceID})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if s, err = s.SetManageAttributes(serviceID); err != nil {
+		tb.Fatal(err)
+	}
+	if s, err = s.SetForceDestroy(tfstate.SetForceDestroyParams{ServiceId: serviceID, ResourceType: "fastly_service_vcl"}); err != nil {
+		tb.Fatal(err)
+	}
+
+	indexKeys := make([]tfstate.SetIndexKeyParams, 0, n)
+	paths := make([]tfstate.Path, 0, n)
+	modules := []tfstate.SetModuleParams{{Module: "module.cdn", ResourceType: "fastly_service_vcl", ResourceName: "service"}}
+	for i := 0; i < n; i++ {
+		acl := fmt.Sprintf("acl_%d", i)
+		indexKeys = append(indexKeys, tfstate.SetIndexKeyParams{ServiceId: serviceID, ResourceType: "fastly_service_acl_entries", ResourceName: acl, Name: acl})
+		paths = append(paths, tfstate.BlockPath("backend"))
+		modules = append(modules, tfstate.SetModuleParams{Module: "module.cdn", ResourceType: "fastly_service_acl_entries", ResourceName: acl})
+	}
+	if s, err = s.SetIndexKey(indexKeys...); err != nil {
+		tb.Fatal(err)
+	}
+	if s, err = s.SetSensitiveAttributes(serviceID, paths); err != nil {
+		tb.Fatal(err)
+	}
+	if s, err = s.SetModule(modules...); err != nil {
+		tb.Fatal(err)
+	}
+	return s
+}
+
+func TestQueriesLargeState(t *testing.T) {
+	s := largeState(t, 50)
+	rewriteLookups(t, s, 50)
+
+	// All the resources are edited in one call
+	edited := rewriteEdits(t, s, 50)
+	for i := 0; i < 50; i++ {
+		acl := fmt.Sprintf("acl_%d", i)
+		r := edited.Resource("module.cdn", "fastly_service_acl_entries", acl)
+		if r == nil || r.Instances[0].IndexKey != acl {
+			t.Fatalf("%s is not moved into the module with its index key: %+v", acl, r)
+		}
+	}
+	if err := edited.Validate(); err != nil {
+		t.Errorf("Validate failed: %v", err)
+	}
+
+	// An edit returns a copy, which is looked up on its own
+	edited, err := s.RemoveResources([]string{"fastly_service_acl_entries.acl_0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := edited.ResourceIDQuery(tfstate.ResourceIDQueryParams{ResourceType: "fastly_service_acl_entries", ResourceName: "acl_0"}); err == nil {
+		t.Error("ResourceIDQuery found a removed resource")
+	}
+	if _, err := s.ResourceIDQuery(tfstate.ResourceIDQueryParams{ResourceType: "fastly_service_acl_entries", ResourceName: "acl_0"}); err != nil {
+		t.Errorf("ResourceIDQuery failed on the original state: %v", err)
+	}
+}
+
+// BenchmarkRewriteLookups measures the lookups made while rewriting a service with n of each nested block,
+// including the time taken to index the state, and the edits made to the state after that
+func BenchmarkRewriteLookups(b *testing.B) {
+	for _, n := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprintf("blocks=%d", n), func(b *testing.B) {
+			base := largeState(b, n)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// A state of the same resources that has not been looked up yet
+				s := &tfstate.TFState{Version: base.Version, Resources: base.Resources}
+				rewriteLookups(b, s, n)
+				rewriteEdits(b, s, n)
+			}
+		})
+	}
+}
@@ -1,91 +1,521 @@
 package tfstate
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
-	"html/template"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
-	"github.com/itchyny/gojq"
+	tfjson "github.com/hashicorp/terraform-json"
 )
 
+// TFState is the version 4 state format written by Terraform 0.12 and later, and by OpenTofu.
+// Only the parts that the import edits or looks up are modelled. The other fields, such as outputs
+// and check_results, are kept as they were read so that writing the state back preserves them.
 type TFState struct {
-	Value interface{}
+	Version          int
+	TerraformVersion string
+	// Serial is incremented every time the state is written
+	Serial int64
+	// Lineage is the unique ID assigned to the state when it was created
+	Lineage   string
+	Resources []*Resource
+
+	extra map[string]json.RawMessage
+	// idx is built by the first lookup
+	idx *index
+}
+
+// Resource is a resource block, which holds an instance for each key of count or for_each
+type Resource struct {
+	// Module is the address of the module the resource is in, such as "module.cdn". Empty means the root module.
+	Module    string
+	Mode      string
+	Type      string
+	Name      string
+	Provider  string
+	Instances []*Instance
+
+	extra map[string]json.RawMessage
 }
 
-type TFStateWithTemplate struct {
-	*template.Template
-	*TFState
+// Instance is an instance of a resource
+type Instance struct {
+	// IndexKey is the key of the instance, which is a string for for_each and a json.Number for count.
+	// It is nil for a resource without either.
+	IndexKey interface{}
+	// Attributes holds the attribute values as decoded from JSON, with the numbers as json.Number
+	Attributes map[string]interface{}
+	// SensitiveAttributes lists the paths to the values that Terraform hides in its output
+	SensitiveAttributes []Path
+
+	extra map[string]json.RawMessage
 }
 
-func (s *TFState) AddTemplate(tmpl string) (*TFStateWithTemplate, error) {
-	t, err := template.New("template").Parse(tmpl)
+// Path is a path to a value in the attributes of an instance
+type Path []PathStep
+
+// PathStep is a step of a Path. Type is "get_attr" for an attribute, where Value is its name,
+// or "index" for an element of a list, set or map, where Value is its index or key.
+type PathStep struct {
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+// String returns the path as JSON, which is the same for equal paths
+func (p Path) String() string {
+	b, err := json.Marshal(p)
 	if err != nil {
-		return nil, fmt.Errorf("tfstate: invalid template: %w", err)
+		return fmt.Sprint([]PathStep(p))
 	}
+	return string(b)
+}
 
-	return &TFStateWithTemplate{t, s}, nil
+// BlockPath returns the path to the nested blocks of the type. Terraform cannot mark a value in an element of a set
+// as sensitive, so it marks the whole set instead, such as all the logging_s3 blocks for the secret key of one of them.
+func BlockPath(blockType string) Path {
+	return Path{{Type: "get_attr", Value: blockType}}
 }
 
-func Load(workingDir string) (state *TFState, err error) {
+// AttributePath returns the path to the attribute of the nested block at the index in a list,
+// such as the secret key of the second block, written as Terraform writes it
+func AttributePath(blockType string, index int, attribute string) Path {
+	return Path{
+		{Type: "get_attr", Value: blockType},
+		{Type: "index", Value: map[string]interface{}{"value": index, "type": "number"}},
+		{Type: "get_attr", Value: attribute},
+	}
+}
+
+// The fields are written in the order Terraform writes them. The fields not listed follow in alphabetical order.
+var (
+	stateFieldOrder    = []string{"version", "terraform_version", "serial", "lineage", "outputs", "resources", "check_results"}
+	resourceFieldOrder = []string{"module", "mode", "type", "name", "each", "provider", "instances"}
+	instanceFieldOrder = []string{"index_key", "schema_version", "attributes", "sensitive_attributes", "private", "dependencies", "create_before_destroy"}
+)
+
+func Load(workingDir string) (*TFState, error) {
 	file := filepath.Join(workingDir, "terraform.tfstate")
-	f, err := os.Open(file)
+	b, err := os.ReadFile(file)
 	if err != nil {
 		return nil, err
 	}
-	defer func() {
-		if err1 := f.Close(); err1 != nil {
-			err = err1
-		}
-	}()
+	return Parse(b)
+}
 
+// Parse parses a state in JSON, such as the output of "terraform state pull"
+func Parse(b []byte) (*TFState, error) {
 	var s TFState
-	if err := json.NewDecoder(f).Decode(&s.Value); err != nil {
+	if err := json.Unmarshal(b, &s); err != nil {
 		return nil, fmt.Errorf("tfstate: invalid json: %w", err)
 	}
-
 	return &s, nil
 }
 
-func (s TFState) Bytes() []byte {
-	switch v := (s.Value).(type) {
-	case string:
-		return []byte(v)
-	default:
-		b, _ := json.Marshal(v)
-		return b
+func (s *TFState) UnmarshalJSON(b []byte) error {
+	fields, err := decodeObject(b)
+	if err != nil {
+		return err
+	}
+	if err := takeField(fields, "version", &s.Version); err != nil {
+		return err
+	}
+	if err := takeField(fields, "terraform_version", &s.TerraformVersion); err != nil {
+		return err
+	}
+	if err := takeField(fields, "serial", &s.Serial); err != nil {
+		return err
+	}
+	if err := takeField(fields, "lineage", &s.Lineage); err != nil {
+		return err
+	}
+	if err := takeField(fields, "resources", &s.Resources); err != nil {
+		return err
 	}
+	s.extra = fields
+	s.invalidate()
+	return nil
 }
 
-func (s TFState) String() string {
-	return string(s.Bytes())
+func (s TFState) MarshalJSON() ([]byte, error) {
+	fields := copyFields(s.extra)
+	resources := s.Resources
+	if resources == nil {
+		resources = []*Resource{}
+	}
+	if err := putField(fields, "version", s.Version); err != nil {
+		return nil, err
+	}
+	if err := putField(fields, "terraform_version", s.TerraformVersion); err != nil {
+		return nil, err
+	}
+	if err := putField(fields, "serial", s.Serial); err != nil {
+		return nil, err
+	}
+	if err := putField(fields, "lineage", s.Lineage); err != nil {
+		return nil, err
+	}
+	if err := putField(fields, "resources", resources); err != nil {
+		return nil, err
+	}
+	return encodeObject(fields, stateFieldOrder), nil
 }
 
-func (s *TFState) Query(query string) (*TFState, error) {
-	jq, err := gojq.Parse(query)
+func (r *Resource) UnmarshalJSON(b []byte) error {
+	fields, err := decodeObject(b)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	for key, v := range map[string]*string{"module": &r.Module, "mode": &r.Mode, "type": &r.Type, "name": &r.Name, "provider": &r.Provider} {
+		if err := takeField(fields, key, v); err != nil {
+			return err
+		}
+	}
+	if err := takeField(fields, "instances", &r.Instances); err != nil {
+		return err
+	}
+	r.extra = fields
+	return nil
+}
 
-	var results []interface{}
-	iter := jq.Run(s.Value)
-	for {
-		v, ok := iter.Next()
-		if !ok {
-			break
+func (r Resource) MarshalJSON() ([]byte, error) {
+	fields := copyFields(r.extra)
+	instances := r.Instances
+	if instances == nil {
+		instances = []*Instance{}
+	}
+	if r.Module != "" {
+		if err := putField(fields, "module", r.Module); err != nil {
+			return nil, err
 		}
-		if err, ok := v.(error); ok {
+	}
+	for key, v := range map[string]interface{}{"mode": r.Mode, "type": r.Type, "name": r.Name, "provider": r.Provider, "instances": instances} {
+		if err := putField(fields, key, v); err != nil {
 			return nil, err
 		}
-		results = append(results, v)
 	}
+	return encodeObject(fields, resourceFieldOrder), nil
+}
 
-	if len(results) == 0 {
-		return nil, fmt.Errorf("tfstate: %s is not found in the state", query)
+func (i *Instance) UnmarshalJSON(b []byte) error {
+	fields, err := decodeObject(b)
+	if err != nil {
+		return err
+	}
+	if err := takeField(fields, "index_key", &i.IndexKey); err != nil {
+		return err
+	}
+	if err := takeField(fields, "attributes", &i.Attributes); err != nil {
+		return err
+	}
+	if err := takeField(fields, "sensitive_attributes", &i.SensitiveAttributes); err != nil {
+		return err
 	}
-	if len(results) > 1 {
-		return nil, fmt.Errorf("tfstate: %s returned multiple results", query)
+	i.extra = fields
+	return nil
+}
+
+func (i Instance) MarshalJSON() ([]byte, error) {
+	fields := copyFields(i.extra)
+	if i.IndexKey != nil {
+		if err := putField(fields, "index_key", i.IndexKey); err != nil {
+			return nil, err
+		}
+	}
+	if i.Attributes != nil {
+		if err := putField(fields, "attributes", i.Attributes); err != nil {
+			return nil, err
+		}
+	}
+	if i.SensitiveAttributes != nil {
+		if err := putField(fields, "sensitive_attributes", i.SensitiveAttributes); err != nil {
+			return nil, err
+		}
+	}
+	return encodeObject(fields, instanceFieldOrder), nil
+}
+
+func decodeObject(b []byte) (map[string]json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+	if fields == nil {
+		return nil, fmt.Errorf("tfstate: unexpected value: %s", b)
+	}
+	return fields, nil
+}
+
+// takeField decodes the field into v and removes it from the fields. The numbers in untyped values are decoded
+// as json.Number so that they are written back as they were read.
+func takeField(fields map[string]json.RawMessage, key string, v interface{}) error {
+	raw, ok := fields[key]
+	if !ok {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("tfstate: invalid %s: %w", key, err)
+	}
+	delete(fields, key)
+	return nil
+}
+
+func putField(fields map[string]json.RawMessage, key string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("tfstate: invalid %s: %w", key, err)
+	}
+	fields[key] = b
+	return nil
+}
+
+func copyFields(fields map[string]json.RawMessage) map[string]json.RawMessage {
+	c := make(map[string]json.RawMessage, len(fields))
+	for k, v := range fields {
+		c[k] = v
+	}
+	return c
+}
+
+func encodeObject(fields map[string]json.RawMessage, order []string) []byte {
+	keys := make([]string, 0, len(fields))
+	seen := map[string]bool{}
+	for _, k := range order {
+		if _, ok := fields[k]; ok {
+			keys = append(keys, k)
+			seen[k] = true
+		}
+	}
+	var rest []string
+	for k := range fields {
+		if !seen[k] {
+			rest = append(rest, k)
+		}
+	}
+	sort.Strings(rest)
+	keys = append(keys, rest...)
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for n, k := range keys {
+		if n > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(k)
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(fields[k])
+	}
+	buf.WriteByte('}')
+	return buf.Bytes()
+}
+
+// Clone returns a deep copy of the state, which the edits are made on
+func (s *TFState) Clone() (*TFState, error) {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(b)
+}
+
+// Empty returns a state with the same lineage and no resources
+func (s *TFState) Empty() (*TFState, error) {
+	empty, err := s.Clone()
+	if err != nil {
+		return nil, err
+	}
+	empty.Resources = []*Resource{}
+	empty.invalidate()
+	empty.extra["outputs"] = json.RawMessage("{}")
+	delete(empty.extra, "check_results")
+	return empty, nil
+}
+
+// CheckNotManaged returns an error if the state already holds a resource of the type and the name in the module.
+// An empty module is the root module. A nil state, which is what a workspace without any state has, holds nothing.
+func (s *TFState) CheckNotManaged(module, resourceType, resourceName string) error {
+	if s == nil {
+		return nil
+	}
+
+	n := s.ResourceCountQuery(ResourceCountQueryParams{
+		Module:       module,
+		ResourceType: resourceType,
+		ResourceName: resourceName,
+	})
+	if n != 0 {
+		addr := (&Resource{Module: module, Type: resourceType, Name: resourceName}).Address()
+		return fmt.Errorf("tfstate: %s already exists in the state. choose another resource name with the -n option or another workspace", addr)
+	}
+	return nil
+}
+
+// FromPlan builds a state from the resources being imported in the plan.
+// The result has the same layout as terraform.tfstate so that the same queries can be run against it.
+func FromPlan(plan *tfjson.Plan) (*TFState, error) {
+	s := &TFState{Version: 4, Resources: []*Resource{}}
+	for _, rc := range plan.ResourceChanges {
+		if rc.Mode != tfjson.ManagedResourceMode || rc.Change == nil || rc.Change.Importing == nil {
+			continue
+		}
+
+		// "before" holds the object read by the import
+		attrs := rc.Change.Before
+		if attrs == nil {
+			attrs = rc.Change.After
+		}
+
+		instance := &Instance{
+			IndexKey:            rc.Index,
+			SensitiveAttributes: []Path{},
+		}
+		if err := convert(attrs, &instance.Attributes); err != nil {
+			return nil, fmt.Errorf("tfstate: unexpected attributes of %s: %w", rc.Address, err)
+		}
+		s.Resources = append(s.Resources, &Resource{
+			Module:    rc.ModuleAddress,
+			Mode:      string(rc.Mode),
+			Type:      rc.Type,
+			Name:      rc.Name,
+			Provider:  rc.ProviderName,
+			Instances: []*Instance{instance},
+		})
+	}
+
+	return s, nil
+}
+
+// convert decodes the value into v through JSON, with the numbers as json.Number
+func convert(value, v interface{}) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+func (s TFState) Bytes() []byte {
+	b, _ := json.Marshal(s)
+	return b
+}
+
+func (s TFState) String() string {
+	return string(s.Bytes())
+}
+
+// Lookup
+
+// FindResources returns the resources of the type. An empty type matches any.
+func (s *TFState) FindResources(resourceType string) []*Resource {
+	if resourceType == "" {
+		return append([]*Resource(nil), s.Resources...)
+	}
+	return append([]*Resource(nil), s.lookup().byType[resourceType]...)
+}
+
+// Resource returns the resource of the type and the name in the module, or nil if there is none.
+// An empty module is the root module.
+func (s *TFState) Resource(module, resourceType, name string) *Resource {
+	return s.lookup().byAddress[resourceKey{module: module, resourceType: resourceType, name: name}]
+}
+
+// ResourcesWithID returns the resources with an instance whose "id" attribute is the id, such as the service
+func (s *TFState) ResourcesWithID(id string) []*Resource {
+	return append([]*Resource(nil), s.lookup().byID[id]...)
+}
+
+func (s *TFState) resourcesWith(resourceType, attr, value string) []*Resource {
+	var resources []*Resource
+	for _, r := range s.FindResources(resourceType) {
+		if r.hasInstanceWith(attr, value) {
+			resources = append(resources, r)
+		}
+	}
+	return resources
+}
+
+// hasInstanceWith tells whether the resource has an instance whose attribute is the value
+func (r *Resource) hasInstanceWith(attr, value string) bool {
+	for _, i := range r.Instances {
+		if i.StringAttribute(attr) == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Attribute returns the value of the attribute and whether it is set
+func (i *Instance) Attribute(name string) (interface{}, bool) {
+	v, ok := i.Attributes[name]
+	return v, ok
+}
+
+// StringAttribute returns the value of the attribute as a string. It is empty if the attribute is not set or null.
+func (i *Instance) StringAttribute(name string) string {
+	v, _ := i.Attribute(name)
+	return stringValue(v)
+}
+
+// SetAttribute sets the value of the attribute
+func (i *Instance) SetAttribute(name string, value interface{}) {
+	if i.Attributes == nil {
+		i.Attributes = map[string]interface{}{}
+	}
+	i.Attributes[name] = value
+}
+
+// moveDependencies prefixes the dependencies of the instance on the resources in the root module with the module path
+func (i *Instance) moveDependencies(module string) error {
+	if _, ok := i.extra["dependencies"]; !ok {
+		return nil
+	}
+	var deps []string
+	if err := takeField(copyFields(i.extra), "dependencies", &deps); err != nil {
+		return err
+	}
+	for n, dep := range deps {
+		if !strings.HasPrefix(dep, "module.") {
+			deps[n] = module + "." + dep
+		}
+	}
+	return putField(i.extra, "dependencies", deps)
+}
+
+// NestedBlocks returns the nested blocks of the type, such as the "backend" blocks of a service.
+// They are stored as a list of objects in the attributes.
+func (i *Instance) NestedBlocks(blockType string) []map[string]interface{} {
+	v, _ := i.Attribute(blockType)
+	return objects(v)
+}
+
+func objects(v interface{}) []map[string]interface{} {
+	list, _ := v.([]interface{})
+	var objs []map[string]interface{}
+	for _, e := range list {
+		if obj, ok := e.(map[string]interface{}); ok {
+			objs = append(objs, obj)
+		}
+	}
+	return objs
+}
+
+// stringValue returns a string value as it is, and any other value other than null in JSON
+func stringValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		b, _ := json.Marshal(v)
+		return string(b)
 	}
-	return &TFState{Value: results[0]}, nil
 }
@@ -0,0 +1,302 @@
+package tfstate_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hrmsk66/terraformify/pkg/tfstate"
+)
+
+const serviceID = "7ManTUgtlSytxeXRMPYY33"
+
+func readState(t *testing.T) []byte {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join("..", "..", "testdata", "tfstate", "state_v4.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func parseState(t *testing.T) *tfstate.TFState {
+	t.Helper()
+	s, err := tfstate.Parse(readState(t))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	return s
+}
+
+// decode decodes JSON for comparison, keeping the numbers as they are written
+func decode(t *testing.T, b []byte) interface{} {
+	t.Helper()
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestRoundTrip(t *testing.T) {
+	b := readState(t)
+	s := parseState(t)
+
+	if s.Version != 4 || s.Serial != 12 || s.Lineage != "3f1c2b7a-8d2e-4b6f-9a51-0c7e4d2f9b13" {
+		t.Errorf("version, serial, lineage = %d, %d, %s", s.Version, s.Serial, s.Lineage)
+	}
+	if n := len(s.Resources); n != 4 {
+		t.Fatalf("got %d resources, want 4", n)
+	}
+	if s.Resources[2].Module != "module.cdn" {
+		t.Errorf("module = %q, want module.cdn", s.Resources[2].Module)
+	}
+
+	if got, want := decode(t, s.Bytes()), decode(t, b); !reflect.DeepEqual(got, want) {
+		t.Errorf("state is changed by the round trip:\ngot  %s\nwant %s", s.Bytes(), b)
+	}
+}
+
+func TestEditsPreserveTheRest(t *testing.T) {
+	s := parseState(t)
+	name := "allow \"list\" \\ & more"
+
+	edited, err := s.SetIndexKey(tfstate.SetIndexKeyParams{
+		ServiceId:    serviceID,
+		ResourceType: "fastly_service_acl_entries",
+		ResourceName: "allow_list",
+		Name:         name,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	edited, err = edited.SetActivateAttribute(tfstate.SetActivateTemplateParams{ServiceId: serviceID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	edited, err = edited.SetManageAttributes(serviceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edited, err = edited.SetForceDestroy(tfstate.SetForceDestroyParams{ServiceId: serviceID, ResourceType: "fastly_service_vcl"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []tfstate.Patch{
+		{Op: "replace", Path: "/resources/0/instances/0/attributes/acl/0/force_destroy", Value: true},
+		{Op: "replace", Path: "/resources/0/instances/0/attributes/activate", Value: true},
+		{Op: "replace", Path: "/resources/0/instances/0/attributes/dictionary/0/force_destroy", Value: true},
+		{Op: "add", Path: "/resources/0/instances/0/attributes/force_destroy", Value: true},
+		{Op: "replace", Path: "/resources/1/instances/0/attributes/manage_entries", Value: true},
+		{Op: "add", Path: "/resources/1/instances/0/index_key", Value: name},
+	}
+	if got := tfstate.Diff(s, edited); !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff = %#v, want %#v", got, want)
+	}
+
+	// The original is left as it was
+	if got, want := decode(t, s.Bytes()), decode(t, readState(t)); !reflect.DeepEqual(got, want) {
+		t.Errorf("original state is changed by the edits:\n%s", s.Bytes())
+	}
+}
+
+func TestQueries(t *testing.T) {
+	s := parseState(t)
+	name := "allow \"list\" \\ & more"
+
+	id, err := s.ServiceQuery(tfstate.ServiceQueryParams{
+		ServiceId:       serviceID,
+		NestedBlockName: "acl",
+		Name:            name,
+		AttributeName:   "acl_id",
+	})
+	if err != nil || id != "2Csd4ocnhkhD3J5KIP4OeK" {
+		t.Errorf("ServiceQuery = %q, %v, want 2Csd4ocnhkhD3J5KIP4OeK", id, err)
+	}
+
+	got, err := s.ResourceNameQuery(tfstate.ResourceNameQueryParams{
+		ResourceType:    "fastly_service_vcl",
+		NestedBlockName: "acl",
+		IDName:          "acl_id",
+		ID:              "2Csd4ocnhkhD3J5KIP4OeK",
+	})
+	if err != nil || got != name {
+		t.Errorf("ResourceNameQuery = %q, %v, want %q", got, err, name)
+	}
+
+	if _, err := s.ServiceQuery(tfstate.ServiceQueryParams{
+		ServiceId:       serviceID,
+		NestedBlockName: "acl",
+		Name:            "missing",
+		AttributeName:   "acl_id",
+	}); err == nil {
+		t.Error("ServiceQuery for a missing block succeeded")
+	}
+
+	index, err := s.NestedBlockIndexQuery(tfstate.NestedBlockIndexQueryParams{
+		ServiceId:       serviceID,
+		NestedBlockName: "backend",
+		Name:            "httpbin",
+	})
+	if err != nil || index != 0 {
+		t.Errorf("NestedBlockIndexQuery = %d, %v, want 0", index, err)
+	}
+
+	if err := s.CheckNotManaged("", "fastly_service_vcl", "service"); err == nil {
+		t.Error("CheckNotManaged succeeded for a managed resource")
+	}
+	if err := s.CheckNotManaged("", "fastly_service_vcl", "new"); err != nil {
+		t.Errorf("CheckNotManaged failed: %v", err)
+	}
+	if err := s.CheckNotManaged("module.cdn", "fastly_service_vcl", "other"); err == nil {
+		t.Error("CheckNotManaged succeeded for a resource managed in the module")
+	}
+	if err := s.CheckNotManaged("", "fastly_service_vcl", "other"); err != nil {
+		t.Errorf("CheckNotManaged failed for a resource managed only in a module: %v", err)
+	}
+
+	// A resource in a module is not taken for the resource of the same type and name in the root module
+	if id, err := s.ResourceIDQuery(tfstate.ResourceIDQueryParams{Module: "module.cdn", ResourceType: "fastly_service_vcl", ResourceName: "other"}); err != nil || id != "OtherServiceId0000000" {
+		t.Errorf("ResourceIDQuery in module.cdn = %q, %v, want OtherServiceId0000000", id, err)
+	}
+	if _, err := s.ResourceIDQuery(tfstate.ResourceIDQueryParams{ResourceType: "fastly_service_vcl", ResourceName: "other"}); err == nil {
+		t.Error("ResourceIDQuery in the root module found the resource in module.cdn")
+	}
+}
+
+func TestSetModule(t *testing.T) {
+	s := parseState(t)
+
+	edited, err := s.SetModule(tfstate.SetModuleParams{
+		Module:       "module.edge",
+		ResourceType: "fastly_service_vcl",
+		ResourceName: "service",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	edited, err = edited.SetModule(tfstate.SetModuleParams{
+		Module:       "module.edge",
+		ResourceType: "fastly_service_acl_entries",
+		ResourceName: "allow_list",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []tfstate.Patch{
+		{Op: "add", Path: "/resources/0/module", Value: "module.edge"},
+		{Op: "replace", Path: "/resources/1/instances/0/dependencies/0", Value: "module.edge.fastly_service_vcl.service"},
+		{Op: "add", Path: "/resources/1/module", Value: "module.edge"},
+	}
+	if got := tfstate.Diff(s, edited); !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff = %#v, want %#v", got, want)
+	}
+
+	// The lookups made while moving the resources do not leave them at their old addresses
+	if edited.Resource("", "fastly_service_vcl", "service") != nil || edited.Resource("module.edge", "fastly_service_vcl", "service") == nil {
+		t.Error("Resource does not find the service at its new address")
+	}
+	if err := edited.Validate(); err != nil {
+		t.Errorf("Validate failed: %v", err)
+	}
+}
+
+func TestSetSensitiveAttributes(t *testing.T) {
+	s := parseState(t)
+	path := tfstate.AttributePath("backend", 0, "ssl_client_key")
+
+	// The path is added once, however many times it is given
+	edited, err := s.SetSensitiveAttributes(serviceID, []tfstate.Path{path, path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	edited, err = edited.SetSensitiveAttributes(serviceID, []tfstate.Path{path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []tfstate.Patch{
+		{Op: "add", Path: "/resources/0/instances/0/sensitive_attributes/1", Value: []interface{}{
+			map[string]interface{}{"type": "get_attr", "value": "backend"},
+			map[string]interface{}{"type": "index", "value": map[string]interface{}{"type": "number", "value": json.Number("0")}},
+			map[string]interface{}{"type": "get_attr", "value": "ssl_client_key"},
+		}},
+	}
+	if got := tfstate.Diff(s, edited); !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff = %#v, want %#v", got, want)
+	}
+	if err := edited.Validate(); err != nil {
+		t.Errorf("Validate failed: %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := parseState(t).Validate(); err != nil {
+		t.Fatalf("Validate failed on a valid state: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		edit    func(s *tfstate.TFState)
+		wantErr string
+	}{
+		{
+			name:    "unsupported version",
+			edit:    func(s *tfstate.TFState) { s.Version = 3 },
+			wantErr: "unsupported state version 3",
+		},
+		{
+			name:    "missing lineage",
+			edit:    func(s *tfstate.TFState) { s.Lineage = "" },
+			wantErr: "lineage is missing",
+		},
+		{
+			name: "duplicate resource",
+			edit: func(s *tfstate.TFState) {
+				s.Resources = append(s.Resources, s.Resources[0])
+			},
+			wantErr: "duplicate resource fastly_service_vcl.service",
+		},
+		{
+			name: "duplicate instance",
+			edit: func(s *tfstate.TFState) {
+				r := s.Resources[2]
+				r.Instances = append(r.Instances, r.Instances[0])
+			},
+			wantErr: "duplicate instance module.cdn.",
+		},
+		{
+			name: "invalid index_key",
+			edit: func(s *tfstate.TFState) {
+				s.Resources[0].Instances[0].IndexKey = true
+			},
+			wantErr: "neither a string nor a number",
+		},
+		{
+			name: "invalid sensitive_attributes",
+			edit: func(s *tfstate.TFState) {
+				i := s.Resources[0].Instances[0]
+				i.SensitiveAttributes = append(i.SensitiveAttributes, tfstate.Path{{Type: "get_attr"}})
+			},
+			wantErr: "invalid sensitive_attributes of fastly_service_vcl.service",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := parseState(t)
+			tt.edit(s)
+			err := s.Validate()
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
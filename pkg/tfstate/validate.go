@@ -0,0 +1,134 @@
+package tfstate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Address returns the address of the resource, such as "module.cdn.fastly_service_vcl.service"
+func (r *Resource) Address() string {
+	var parts []string
+	if r.Module != "" {
+		parts = append(parts, r.Module)
+	}
+	if r.Mode == "data" {
+		parts = append(parts, "data")
+	}
+	parts = append(parts, r.Type, r.Name)
+	return strings.Join(parts, ".")
+}
+
+// Validate checks that the state is a well-formed version 4 state, as Terraform reads it.
+// It is run before the state is written so that an edit gone wrong cannot corrupt the state in the backend.
+func (s *TFState) Validate() error {
+	if s.Version != 4 {
+		return fmt.Errorf("tfstate: unsupported state version %d", s.Version)
+	}
+	if s.TerraformVersion == "" {
+		return errors.New("tfstate: terraform_version is missing")
+	}
+	if s.Lineage == "" {
+		return errors.New("tfstate: lineage is missing")
+	}
+	if s.Serial < 0 {
+		return fmt.Errorf("tfstate: invalid serial %d", s.Serial)
+	}
+
+	addresses := map[string]bool{}
+	for _, r := range s.Resources {
+		if r == nil {
+			return errors.New("tfstate: null resource")
+		}
+		if err := r.validate(); err != nil {
+			return err
+		}
+		addr := r.Address()
+		if addresses[addr] {
+			return fmt.Errorf("tfstate: duplicate resource %s", addr)
+		}
+		addresses[addr] = true
+	}
+	return nil
+}
+
+func (r *Resource) validate() error {
+	if r.Mode != "managed" && r.Mode != "data" {
+		return fmt.Errorf("tfstate: invalid mode %q of %s.%s", r.Mode, r.Type, r.Name)
+	}
+	if r.Type == "" || r.Name == "" {
+		return fmt.Errorf("tfstate: resource without a type or a name: %q.%q", r.Type, r.Name)
+	}
+	addr := r.Address()
+	if r.Provider == "" {
+		return fmt.Errorf("tfstate: provider of %s is missing", addr)
+	}
+
+	keys := map[string]bool{}
+	for _, i := range r.Instances {
+		if i == nil {
+			return fmt.Errorf("tfstate: null instance in %s", addr)
+		}
+		key, err := indexKey(i.IndexKey)
+		if err != nil {
+			return fmt.Errorf("tfstate: invalid instance of %s: %w", addr, err)
+		}
+		if keys[key] {
+			return fmt.Errorf("tfstate: duplicate instance %s%s", addr, key)
+		}
+		keys[key] = true
+
+		if i.Attributes == nil {
+			if _, ok := i.extra["attributes_flat"]; !ok {
+				return fmt.Errorf("tfstate: attributes of %s%s are missing", addr, key)
+			}
+		}
+		for _, p := range i.SensitiveAttributes {
+			if err := p.validate(); err != nil {
+				return fmt.Errorf("tfstate: invalid sensitive_attributes of %s%s: %w", addr, key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// indexKey returns the key as it appears in the instance address, such as [0] or ["allow list"]
+func indexKey(key interface{}) (string, error) {
+	switch key := key.(type) {
+	case nil:
+		return "", nil
+	case string:
+		b, _ := json.Marshal(key)
+		return "[" + string(b) + "]", nil
+	case json.Number:
+		n, err := key.Int64()
+		if err != nil || n < 0 {
+			return "", fmt.Errorf("index_key %s is not a count index", key)
+		}
+		return fmt.Sprintf("[%d]", n), nil
+	default:
+		return "", fmt.Errorf("index_key %v is neither a string nor a number", key)
+	}
+}
+
+func (p Path) validate() error {
+	if len(p) == 0 {
+		return errors.New("empty path")
+	}
+	for _, step := range p {
+		switch step.Type {
+		case "get_attr":
+			if name, ok := step.Value.(string); !ok || name == "" {
+				return fmt.Errorf("get_attr step with a value %v that is not an attribute name", step.Value)
+			}
+		case "index":
+			if step.Value == nil {
+				return errors.New("index step without a value")
+			}
+		default:
+			return fmt.Errorf("unknown step type %q", step.Type)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+# __generated__ by Terraform
+# Please review these resources and move them into your main configuration files.
+
+# __generated__ by Terraform from "SU1Z0isxPaozGVKXdv0eY"
+resource "fastly_service_vcl" "service" {
+  activate       = null
+  comment        = ""
+  default_host   = null
+  default_ttl    = 3600
+  force_destroy  = null
+  http3          = false
+  name           = "test.terraformify.me"
+  stale_if_error = false
+  backend {
+    address               = "httpbin.org"
+    name                  = "httpbin"
+    port                  = 443
+    ssl_client_cert       = null # sensitive
+    ssl_client_key        = null # sensitive
+  }
+  domain {
+    comment = ""
+    name    = "test.terraformify.me"
+  }
+  vcl {
+    content = <<-EOT
+      sub vcl_recv {
+      #FASTLY recv
+      }
+    EOT
+    main    = true
+    name    = "main"
+  }
+}
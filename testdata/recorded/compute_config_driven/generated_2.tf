@@ -0,0 +1,29 @@
+# __generated__ by Terraform
+# Please review these resources and move them into your main configuration files.
+
+# __generated__ by Terraform from "4t1ATv0LyQ6GPNXxZHpcAz"
+resource "fastly_secretstore" "my_store" {
+  force_destroy = null
+  name          = "my store"
+}
+
+# __generated__ by Terraform from "7ManTUgtlSytxeXRMPYY33"
+resource "fastly_service_compute" "service" {
+  activate      = null
+  comment       = "terraformify test service"
+  force_destroy = null
+  name          = "terraformify test"
+  reuse         = null
+  domain {
+    comment = ""
+    name    = "test.terraformify.me"
+  }
+  package {
+    filename         = null
+    source_code_hash = "2d5ffb4c3b1d4dbb0b9b9bcee9a1c6c8a4b3c2e1"
+  }
+  resource_link {
+    name        = "my store"
+    resource_id = "4t1ATv0LyQ6GPNXxZHpcAz"
+  }
+}
@@ -0,0 +1,8 @@
+# __generated__ by Terraform
+# Please review these resources and move them into your main configuration files.
+
+# __generated__ by Terraform from "4t1ATv0LyQ6GPNXxZHpcAz"
+resource "fastly_secretstore" "my_store" {
+  force_destroy = null
+  name          = "my store"
+}
@@ -0,0 +1,31 @@
+# __generated__ by Terraform
+# Please review these resources and move them into your main configuration files.
+
+# __generated__ by Terraform from "7ManTUgtlSytxeXRMPYY33"
+resource "fastly_service_vcl" "service" {
+  activate       = null
+  comment        = "terraformify test service"
+  default_host   = null
+  default_ttl    = 3600
+  force_destroy  = null
+  http3          = false
+  name           = "terraformify test"
+  stale_if_error = false
+  acl {
+    force_destroy = false
+    name          = "allow list"
+  }
+  acl {
+    force_destroy = false
+    name          = "deny list"
+  }
+  dictionary {
+    force_destroy = false
+    name          = "redirects"
+    write_only    = false
+  }
+  domain {
+    comment = ""
+    name    = "test.terraformify.me"
+  }
+}
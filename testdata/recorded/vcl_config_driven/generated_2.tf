@@ -0,0 +1,67 @@
+# __generated__ by Terraform
+# Please review these resources and move them into your main configuration files.
+
+# __generated__ by Terraform from "7ManTUgtlSytxeXRMPYY33"
+resource "fastly_service_vcl" "service" {
+  activate       = null
+  comment        = "terraformify test service"
+  default_host   = null
+  default_ttl    = 3600
+  force_destroy  = null
+  http3          = false
+  name           = "terraformify test"
+  stale_if_error = false
+  acl {
+    force_destroy = false
+    name          = "allow list"
+  }
+  acl {
+    force_destroy = false
+    name          = "deny list"
+  }
+  dictionary {
+    force_destroy = false
+    name          = "redirects"
+    write_only    = false
+  }
+  domain {
+    comment = ""
+    name    = "test.terraformify.me"
+  }
+}
+
+# __generated__ by Terraform from "7ManTUgtlSytxeXRMPYY33/2Csd4ocnhkhD3J5KIP4OeK"
+resource "fastly_service_acl_entries" "allow_list" {
+  acl_id         = "2Csd4ocnhkhD3J5KIP4OeK"
+  manage_entries = null
+  service_id     = "7ManTUgtlSytxeXRMPYY33"
+  entry {
+    comment = ""
+    ip      = "192.168.0.0"
+    negated = false
+    subnet  = "24"
+  }
+}
+
+# __generated__ by Terraform from "7ManTUgtlSytxeXRMPYY33/3Htd5pdOvnjE4ZrSQkPh2c"
+resource "fastly_service_acl_entries" "deny_list" {
+  acl_id         = "3Htd5pdOvnjE4ZrSQkPh2c"
+  manage_entries = null
+  service_id     = "7ManTUgtlSytxeXRMPYY33"
+  entry {
+    comment = ""
+    ip      = "10.0.0.0"
+    negated = false
+    subnet  = "24"
+  }
+}
+
+# __generated__ by Terraform from "7ManTUgtlSytxeXRMPYY33/1BgQ8zvPpl6HqVuS0rXm4b"
+resource "fastly_service_dictionary_items" "redirects" {
+  dictionary_id = "1BgQ8zvPpl6HqVuS0rXm4b"
+  items = {
+    "/old" = "/new"
+  }
+  manage_items = null
+  service_id   = "7ManTUgtlSytxeXRMPYY33"
+}
@@ -0,0 +1,75 @@
+variable "domain" {
+  type = string
+}
+
+resource "fastly_service_vcl" "service" {
+  name = var.domain
+
+  domain {
+    name = var.domain
+  }
+
+  backend {
+    name              = "httpbin"
+    address           = "httpbin.org"
+    port              = 443
+    use_ssl           = true
+    ssl_cert_hostname = "httpbin.org"
+    ssl_sni_hostname  = "httpbin.org"
+  }
+
+  backend {
+    name              = "example"
+    address           = "www.example.com"
+    port              = 443
+    use_ssl           = true
+    ssl_cert_hostname = "www.example.com"
+    ssl_sni_hostname  = "www.example.com"
+  }
+
+  condition {
+    name      = "is api"
+    statement = "req.url ~ \"^/api\""
+    type      = "REQUEST"
+    priority  = 10
+  }
+
+  condition {
+    name      = "is static"
+    statement = "req.url.ext ~ \"^(css|js|png)$\""
+    type      = "REQUEST"
+    priority  = 20
+  }
+
+  header {
+    name        = "remove x-powered-by"
+    action      = "delete"
+    destination = "http.X-Powered-By"
+    type        = "cache"
+  }
+
+  header {
+    name        = "set x-served-by"
+    action      = "set"
+    destination = "http.X-Served-By"
+    source      = "server.identity"
+    type        = "response"
+    priority    = 20
+  }
+
+  header {
+    name         = "rewrite v1"
+    action       = "regex"
+    destination  = "url"
+    source       = "req.url"
+    regex        = "^/v1"
+    substitution = "/v2"
+    type         = "request"
+  }
+
+  force_destroy = true
+}
+
+output "id" {
+  value = fastly_service_vcl.service.id
+}
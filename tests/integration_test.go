@@ -1,6 +1,7 @@
 package tests
 
 import (
+	"context"
 	"errors"
 	"os"
 	"path/filepath"
@@ -98,19 +99,23 @@ func TestImportService(t *testing.T) {
 	testCases := []struct {
 		resourceType     string
 		name             string
+		format           string
 		expResourceCount int
 	}{
-		{"vcl", "service_custom_vcl.tf", 1},
-		{"vcl", "service_acl.tf", 3},
-		{"vcl", "service_dictionary.tf", 3},
-		{"vcl", "service_dynamic_snippet.tf", 3},
-		{"vcl", "service_waf.tf", 2},
-		{"compute", "service_compute.tf", 3},
-		{"compute", "service_compute_datastores.tf", 5},
+		{"vcl", "service_custom_vcl.tf", cli.FormatHCL, 1},
+		{"vcl", "service_acl.tf", cli.FormatHCL, 3},
+		{"vcl", "service_dictionary.tf", cli.FormatHCL, 3},
+		{"vcl", "service_dynamic_snippet.tf", cli.FormatHCL, 3},
+		{"vcl", "service_waf.tf", cli.FormatHCL, 2},
+		{"compute", "service_compute.tf", cli.FormatHCL, 3},
+		{"compute", "service_compute_datastores.tf", cli.FormatHCL, 5},
+		{"vcl", "service_custom_vcl.tf", cli.FormatJSON, 1},
+		{"vcl", "service_acl.tf", cli.FormatJSON, 3},
+		{"compute", "service_compute_datastores.tf", cli.FormatJSON, 5},
 	}
 
 	for _, tc := range testCases {
-		t.Run(tc.name, func(t *testing.T) {
+		t.Run(tc.name+"/"+tc.format, func(t *testing.T) {
 			var prepOpt *terraform.Options
 			var err error
 
@@ -142,13 +147,14 @@ func TestImportService(t *testing.T) {
 				ResourceName: resourceName,
 				Package:      packageFile,
 				Directory:    testDirPath,
+				Format:       tc.format,
 				ForceDestroy: true,
 				TestMode:     true,
 			}
 
 			if tc.resourceType == "vcl" {
 				// Run terraformify
-				if err = cmd.ImportVCL(c); err != nil {
+				if err = cmd.ImportVCL(context.Background(), c); err != nil {
 					t.Errorf("Failed to import the service: %s", err)
 				}
 			}
@@ -164,18 +170,19 @@ func TestImportService(t *testing.T) {
 				}
 
 				// Run terraformify
-				if err = cmd.ImportCompute(c); err != nil {
+				if err = cmd.ImportCompute(context.Background(), c); err != nil {
 					t.Errorf("Failed to import the service: %s", err)
 				}
 			}
 
-			// Run "terraform apply". add/change/destroy counts should all be 0
+			// Run "terraform validate" and "terraform apply". add/change/destroy counts should all be 0
 			testOpt := terraform.WithDefaultRetryableErrors(
 				t,
 				&terraform.Options{
 					TerraformDir: testDirPath,
 				},
 			)
+			terraform.Validate(t, testOpt)
 			applyString := terraform.Apply(t, testOpt)
 			applyCounts := terraform.GetResourceCount(t, applyString)
 			require.Equal(t, 0, applyCounts.Add)
@@ -189,3 +196,60 @@ func TestImportService(t *testing.T) {
 		})
 	}
 }
+
+// The repeated blocks collapsed into dynamic blocks must generate the same blocks, so "terraform plan" shows no changes
+func TestImportServiceDynamicBlocks(t *testing.T) {
+	for _, data := range []string{"", cli.DataJSON, cli.DataYAML} {
+		name := data
+		if name == "" {
+			name = "locals"
+		}
+		t.Run(name, func(t *testing.T) {
+			prepOpt, err := prep(t, "service_dynamic_blocks.tf")
+			if err != nil {
+				t.Fatalf("Failed to set up a test service: %s", err)
+			}
+
+			testDirPath, err := os.MkdirTemp("", testDir)
+			if err != nil {
+				t.Fatalf("Failed to create a working directory: %s", err)
+			}
+			defer os.RemoveAll(testDirPath)
+
+			serviceID := terraform.Output(t, prepOpt, "id")
+			c := cli.Config{
+				ID:                serviceID,
+				ResourceName:      resourceName,
+				Directory:         testDirPath,
+				Format:            cli.FormatHCL,
+				DynamicBlocks:     true,
+				DynamicBlocksData: data,
+				ForceDestroy:      true,
+				TestMode:          true,
+			}
+			if err = cmd.ImportVCL(context.Background(), c); err != nil {
+				t.Fatalf("Failed to import the service: %s", err)
+			}
+
+			conf, err := os.ReadFile(filepath.Join(testDirPath, "service.tf"))
+			require.NoError(t, err)
+			for _, blockType := range []string{"backend", "condition", "header"} {
+				require.Contains(t, string(conf), `dynamic "`+blockType+`"`)
+			}
+
+			// Run "terraform plan -detailed-exitcode", which exits with 0 only if there are no changes
+			testOpt := terraform.WithDefaultRetryableErrors(
+				t,
+				&terraform.Options{
+					TerraformDir: testDirPath,
+				},
+			)
+			terraform.Validate(t, testOpt)
+			require.Equal(t, 0, terraform.PlanExitCode(t, testOpt))
+
+			destroyString := terraform.Destroy(t, testOpt)
+			destroyCounts := terraform.GetResourceCount(t, destroyString)
+			require.Equal(t, 1, destroyCounts.Destroy)
+		})
+	}
+}